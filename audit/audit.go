@@ -0,0 +1,92 @@
+// Package audit publishes an event recording the fate of each refund request,
+// so that what was decided for it can be proven after the fact.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/topic"
+)
+
+// SchemaName is the name of the outcome event schema in the schema registry.
+const SchemaName = "refund-request-outcome"
+
+// Sender sends a message to kafka. It is satisfied by producer.Producer.
+type Sender interface {
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// Encoder encodes a message with an avro schema. It is satisfied by
+// avro.Schema.
+type Encoder interface {
+	Marshal(v interface{}) ([]byte, error)
+}
+
+// Publisher publishes outcome events to the audit topic.
+type Publisher struct {
+	Topic  string
+	Sender Sender
+	Schema Encoder
+}
+
+// New returns a Publisher which encodes outcome events with schema.
+func New(topic string, sender Sender, schema Encoder) *Publisher {
+	return &Publisher{
+		Topic:  topic,
+		Sender: sender,
+		Schema: schema,
+	}
+}
+
+// Publish sends an outcome event, keyed by payment ID so that the events of a
+// payment stay in order.
+func (p *Publisher) Publish(event data.RefundRequestOutcome) error {
+	value, err := p.Schema.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding refund request outcome: %w", err)
+	}
+
+	_, _, err = p.Sender.SendMessage(&sarama.ProducerMessage{
+		Topic: p.Topic,
+		Key:   sarama.StringEncoder(event.PaymentID),
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing refund request outcome to topic [%s]: %w", p.Topic, err)
+	}
+
+	return nil
+}
+
+// Decoder decodes a message with an avro schema. It is satisfied by
+// avro.Schema.
+type Decoder interface {
+	Unmarshal(message []byte, v interface{}) error
+}
+
+// Load applies every outcome event published to the topic since the given
+// time, up to its tail when Load is called, returning the offsets to follow
+// the topic from. Messages which cannot be decoded are skipped.
+func Load(ctx context.Context, client topic.OffsetClient, consumer sarama.Consumer, name string, since time.Time, schema Decoder, apply func(data.RefundRequestOutcome)) (topic.Offsets, error) {
+	return topic.Load(ctx, client, consumer, name, since.UnixMilli(), events(schema, apply))
+}
+
+// Follow applies every outcome event published to the topic from the
+// offsets, until the context is cancelled.
+func Follow(ctx context.Context, consumer sarama.Consumer, name string, offsets topic.Offsets, schema Decoder, apply func(data.RefundRequestOutcome), onError func(error)) error {
+	return topic.Follow(ctx, consumer, name, offsets, events(schema, apply), onError)
+}
+
+// events returns a func applying the outcome event in each message.
+func events(schema Decoder, apply func(data.RefundRequestOutcome)) func(*sarama.ConsumerMessage) {
+	return func(msg *sarama.ConsumerMessage) {
+		var event data.RefundRequestOutcome
+		if err := schema.Unmarshal(msg.Value, &event); err == nil {
+			apply(event)
+		}
+	}
+}
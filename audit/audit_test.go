@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/avro"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const schema = `{"type":"record","name":"refund_request_outcome","namespace":"payments","fields":[{"name":"payment_id","type":"string"},{"name":"refund_reference","type":"string"},{"name":"amount_pence","type":"long"},{"name":"attempt","type":"int"},{"name":"outcome","type":"string"},{"name":"failure_class","type":"string"},{"name":"http_status","type":"int"},{"name":"latency_ms","type":"long"},{"name":"consumer_role","type":"string"},{"name":"topic","type":"string"},{"name":"partition","type":"int"},{"name":"offset","type":"long"},{"name":"processed_at","type":"long"}]}`
+
+type mockSender struct {
+	sent []*sarama.ProducerMessage
+	err  error
+}
+
+func (m *mockSender) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	m.sent = append(m.sent, msg)
+	return 0, 0, m.err
+}
+
+var event = data.RefundRequestOutcome{
+	PaymentID:       "payment-1",
+	RefundReference: "ref",
+	AmountPence:     10000,
+	Attempt:         2,
+	Outcome:         "succeeded",
+	HTTPStatus:      201,
+	LatencyMillis:   120,
+	Role:            "main",
+	Topic:           "refund-request",
+	Partition:       3,
+	Offset:          42,
+	ProcessedAt:     1704164645000,
+}
+
+func TestUnitPublish(t *testing.T) {
+	sender := &mockSender{}
+	avroSchema := &avro.Schema{Definition: schema}
+	p := New("refund-request-outcome", sender, avroSchema)
+
+	require.NoError(t, p.Publish(event))
+	require.Len(t, sender.sent, 1)
+
+	sent := sender.sent[0]
+	assert.Equal(t, "refund-request-outcome", sent.Topic)
+	assert.Equal(t, sarama.StringEncoder("payment-1"), sent.Key)
+
+	value, _ := sent.Value.Encode()
+	var decoded data.RefundRequestOutcome
+	require.NoError(t, avroSchema.Unmarshal(value, &decoded))
+	assert.Equal(t, event, decoded)
+}
+
+func TestUnitPublish_SendError(t *testing.T) {
+	sender := &mockSender{err: errors.New("broker down")}
+	p := New("refund-request-outcome", sender, &avro.Schema{Definition: schema})
+
+	err := p.Publish(event)
+	assert.ErrorContains(t, err, "refund-request-outcome")
+	assert.ErrorIs(t, err, sender.err)
+}
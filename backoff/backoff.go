@@ -0,0 +1,67 @@
+// Package backoff calculates when a retried message is next due to be
+// processed.
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy is an exponential backoff with jitter. The delay before attempt n is
+// Base doubled n-1 times, capped at Max, then reduced by a random fraction of
+// up to Jitter so that a burst of retries is spread out. A policy with
+// MinDelay set extends the delay by the jitter instead, so that no attempt is
+// due before its delay.
+type Policy struct {
+	Base     time.Duration
+	Max      time.Duration
+	Jitter   float64
+	MinDelay bool
+	random   func() float64
+}
+
+// New returns a Policy with the given base and maximum delay and a jitter of
+// jitterPercent percent.
+func New(base, max time.Duration, jitterPercent int) *Policy {
+	return &Policy{
+		Base:   base,
+		Max:    max,
+		Jitter: math.Min(math.Max(float64(jitterPercent)/100, 0), 1),
+		random: rand.Float64,
+	}
+}
+
+// Fixed returns a Policy whose delay is always delay, extended by a random
+// fraction of up to jitterPercent percent.
+func Fixed(delay time.Duration, jitterPercent int) *Policy {
+	p := New(delay, delay, jitterPercent)
+	p.MinDelay = true
+	return p
+}
+
+// Delay returns the delay before an attempt. Attempts are counted from one.
+func (p *Policy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := float64(p.Base) * math.Pow(2, float64(attempt-1))
+	if p.Max > 0 && delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+
+	if p.Jitter > 0 && p.random != nil {
+		if p.MinDelay {
+			delay += delay * p.Jitter * p.random()
+		} else {
+			delay -= delay * p.Jitter * p.random()
+		}
+	}
+	return time.Duration(delay)
+}
+
+// Due returns when an attempt at a message published at published is due.
+func (p *Policy) Due(published time.Time, attempt int) time.Time {
+	return published.Add(p.Delay(attempt))
+}
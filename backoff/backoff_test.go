@@ -0,0 +1,66 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitDelay(t *testing.T) {
+	p := New(time.Second, 10*time.Second, 0)
+
+	assert.Equal(t, time.Second, p.Delay(0))
+	assert.Equal(t, time.Second, p.Delay(1))
+	assert.Equal(t, 2*time.Second, p.Delay(2))
+	assert.Equal(t, 8*time.Second, p.Delay(4))
+	assert.Equal(t, 10*time.Second, p.Delay(5))
+	assert.Equal(t, 10*time.Second, p.Delay(100))
+}
+
+func TestUnitDelayJitter(t *testing.T) {
+	p := New(10*time.Second, time.Minute, 20)
+
+	p.random = func() float64 { return 0 }
+	assert.Equal(t, 10*time.Second, p.Delay(1))
+
+	p.random = func() float64 { return 0.5 }
+	assert.Equal(t, 9*time.Second, p.Delay(1))
+
+	p.random = func() float64 { return 1 }
+	assert.Equal(t, 8*time.Second, p.Delay(1))
+
+	p = New(10*time.Second, time.Minute, 20)
+	for i := 0; i < 100; i++ {
+		delay := p.Delay(2)
+		assert.True(t, delay > 16*time.Second && delay <= 20*time.Second, "delay %s out of range", delay)
+	}
+}
+
+func TestUnitFixedDelayJitter(t *testing.T) {
+	p := Fixed(10*time.Second, 20)
+
+	p.random = func() float64 { return 0 }
+	assert.Equal(t, 10*time.Second, p.Delay(1))
+
+	p.random = func() float64 { return 1 }
+	assert.Equal(t, 12*time.Second, p.Delay(3))
+
+	p = Fixed(10*time.Second, 20)
+	for i := 0; i < 100; i++ {
+		delay := p.Delay(i)
+		assert.True(t, delay >= 10*time.Second && delay <= 12*time.Second, "delay %s out of range", delay)
+	}
+}
+
+func TestUnitNewClampsJitter(t *testing.T) {
+	assert.Equal(t, 0.0, New(time.Second, 0, -5).Jitter)
+	assert.Equal(t, 1.0, New(time.Second, 0, 150).Jitter)
+}
+
+func TestUnitDue(t *testing.T) {
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := New(time.Minute, time.Hour, 0)
+
+	assert.Equal(t, published.Add(4*time.Minute), p.Due(published, 3))
+}
@@ -0,0 +1,158 @@
+// Package codec decodes refund requests published as avro, json or protobuf.
+// The decoder of a message is chosen by its content-type header, or if it has
+// none by the topic it was consumed from.
+package codec
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+)
+
+// ContentTypeHeader is the header naming the format of a message.
+const ContentTypeHeader = "content-type"
+
+// Formats of refund request messages.
+const (
+	FormatAvro     = "avro"
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// contentTypes maps the content types of each format to the format.
+var contentTypes = map[string]string{
+	"avro/binary":                        FormatAvro,
+	"application/avro":                   FormatAvro,
+	"application/vnd.apache.avro+binary": FormatAvro,
+	"application/json":                   FormatJSON,
+	"application/protobuf":               FormatProtobuf,
+	"application/x-protobuf":             FormatProtobuf,
+	"application/vnd.google.protobuf":    FormatProtobuf,
+}
+
+// Decoder decodes the value of a message into a refund request.
+type Decoder interface {
+	Decode(value []byte) (data.RefundRequest, error)
+}
+
+// Unmarshaler decodes a message into a struct. It is satisfied by
+// avro.Schema and registry.Decoder.
+type Unmarshaler interface {
+	Unmarshal(message []byte, v interface{}) error
+}
+
+// Avro decodes avro refund requests with a schema.
+type Avro struct {
+	Schema Unmarshaler
+}
+
+// Decode implements Decoder.Decode.
+func (a Avro) Decode(value []byte) (data.RefundRequest, error) {
+	var rr data.RefundRequest
+	err := a.Schema.Unmarshal(value, &rr)
+	return rr, err
+}
+
+// Selector chooses the decoder of each message. Messages without a
+// content-type header are decoded in the format configured for their topic,
+// or as avro if none is.
+type Selector struct {
+	decoders map[string]Decoder
+	topics   map[string]string
+}
+
+// NewSelector returns a selector decoding avro with the given decoder, and
+// json and protobuf with this package's decoders. topics maps topics to the
+// format of the messages published to them without a content-type header.
+func NewSelector(avro Decoder, topics map[string]string) (*Selector, error) {
+	s := &Selector{
+		decoders: map[string]Decoder{FormatAvro: avro, FormatJSON: JSON{}, FormatProtobuf: Protobuf{}},
+		topics:   topics,
+	}
+	for topic, format := range topics {
+		if !knownFormat(format) {
+			return nil, fmt.Errorf("unknown format [%s] of topic [%s], expected %s, %s or %s", format, topic, FormatAvro, FormatJSON, FormatProtobuf)
+		}
+	}
+	return s, nil
+}
+
+// Decode decodes a message with the decoder of its format.
+func (s *Selector) Decode(msg *sarama.ConsumerMessage) (data.RefundRequest, error) {
+	format, err := s.Format(msg)
+	if err != nil {
+		return data.RefundRequest{}, err
+	}
+	return s.DecodeFormat(format, msg.Value)
+}
+
+// DecodeFormat decodes the value of a message known to be in the given format.
+func (s *Selector) DecodeFormat(format string, value []byte) (data.RefundRequest, error) {
+	decoder, ok := s.decoders[format]
+	if !ok {
+		return data.RefundRequest{}, fmt.Errorf("unknown format [%s], expected %s, %s or %s", format, FormatAvro, FormatJSON, FormatProtobuf)
+	}
+	rr, err := decoder.Decode(value)
+	if err != nil {
+		return data.RefundRequest{}, fmt.Errorf("error decoding %s refund request: %w", format, err)
+	}
+	return rr, nil
+}
+
+// Format returns the format of a message, given by its content-type header,
+// its topic or else the default of avro.
+func (s *Selector) Format(msg *sarama.ConsumerMessage) (string, error) {
+	for _, header := range msg.Headers {
+		if header == nil || !strings.EqualFold(string(header.Key), ContentTypeHeader) {
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(string(header.Value))
+		if err != nil {
+			return "", fmt.Errorf("invalid content type [%s]: %w", header.Value, err)
+		}
+		format, ok := contentTypes[mediaType]
+		if !ok {
+			return "", fmt.Errorf("unsupported content type [%s]", mediaType)
+		}
+		return format, nil
+	}
+
+	if format, ok := s.topics[msg.Topic]; ok {
+		return format, nil
+	}
+	return FormatAvro, nil
+}
+
+// ParseTopicFormats parses specs of the form topic=format, such as
+// refund-request-ui=json, into a map of topic to format.
+func ParseTopicFormats(specs []string) (map[string]string, error) {
+	topics := map[string]string{}
+	for _, value := range specs {
+		for _, spec := range strings.Split(value, ",") {
+			spec = strings.TrimSpace(spec)
+			if spec == "" {
+				continue
+			}
+			topic, format, ok := strings.Cut(spec, "=")
+			topic, format = strings.TrimSpace(topic), strings.ToLower(strings.TrimSpace(format))
+			if !ok || topic == "" || format == "" {
+				return nil, fmt.Errorf("invalid topic format [%s], expected topic=format", spec)
+			}
+			if !knownFormat(format) {
+				return nil, fmt.Errorf("unknown format [%s] of topic [%s], expected %s, %s or %s", format, topic, FormatAvro, FormatJSON, FormatProtobuf)
+			}
+			if _, seen := topics[topic]; seen {
+				return nil, fmt.Errorf("duplicate format of topic [%s]", topic)
+			}
+			topics[topic] = format
+		}
+	}
+	return topics, nil
+}
+
+func knownFormat(format string) bool {
+	return format == FormatAvro || format == FormatJSON || format == FormatProtobuf
+}
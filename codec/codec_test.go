@@ -0,0 +1,164 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var refundRequest = data.RefundRequest{
+	Attempt:         2,
+	PaymentID:       "payment-id",
+	RefundAmount:    "12.50",
+	RefundReference: "ref",
+	Currency:        "GBP",
+	Reason:          "duplicate",
+	RequestedBy:     "payments-ui",
+}
+
+// fakeAvro decodes every message as refundRequest.
+type fakeAvro struct{}
+
+func (fakeAvro) Decode(value []byte) (data.RefundRequest, error) {
+	if string(value) != "avro" {
+		return data.RefundRequest{}, errors.New("not avro")
+	}
+	return refundRequest, nil
+}
+
+func encodeProtobuf(rr data.RefundRequest) []byte {
+	b := protowire.AppendTag(nil, fieldAttempt, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(rr.Attempt))
+	for num, s := range map[protowire.Number]string{
+		fieldPaymentID:       rr.PaymentID,
+		fieldRefundAmount:    rr.RefundAmount,
+		fieldRefundReference: rr.RefundReference,
+		fieldCurrency:        rr.Currency,
+		fieldReason:          rr.Reason,
+		fieldRequestedBy:     rr.RequestedBy,
+	} {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+const refundRequestJSON = `{"attempt":2,"payment_id":"payment-id","refund_amount":"12.50","refund_reference":"ref",` +
+	`"currency":"GBP","reason":"duplicate","requested_by":"payments-ui"}`
+
+func TestUnitJSON(t *testing.T) {
+	rr, err := JSON{}.Decode([]byte(refundRequestJSON))
+	require.NoError(t, err)
+	assert.Equal(t, refundRequest, rr)
+
+	rr, err = JSON{}.Decode([]byte(`{"payment_id":"payment-id","refund_amount":"1.00","refund_reference":"ref","channel":"web"}`))
+	require.NoError(t, err, "unknown fields are ignored")
+	assert.Equal(t, data.RefundRequest{PaymentID: "payment-id", RefundAmount: "1.00", RefundReference: "ref"}, rr)
+
+	_, err = JSON{}.Decode([]byte(`{"refund_amount":12.5}`))
+	assert.Error(t, err)
+}
+
+func TestUnitProtobuf(t *testing.T) {
+	rr, err := Protobuf{}.Decode(encodeProtobuf(refundRequest))
+	require.NoError(t, err)
+	assert.Equal(t, refundRequest, rr)
+
+	t.Run("unknown fields are skipped", func(t *testing.T) {
+		b := protowire.AppendTag(nil, 20, protowire.BytesType)
+		b = protowire.AppendString(b, "web")
+		b = protowire.AppendTag(b, 21, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, 1)
+		b = append(b, encodeProtobuf(refundRequest)...)
+
+		rr, err := Protobuf{}.Decode(b)
+		require.NoError(t, err)
+		assert.Equal(t, refundRequest, rr)
+	})
+
+	t.Run("a known field of the wrong type is an error", func(t *testing.T) {
+		b := protowire.AppendTag(nil, fieldPaymentID, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+
+		_, err := Protobuf{}.Decode(b)
+		assert.Error(t, err)
+	})
+
+	t.Run("truncated messages are an error", func(t *testing.T) {
+		b := encodeProtobuf(refundRequest)
+
+		_, err := Protobuf{}.Decode(b[:len(b)-1])
+		assert.Error(t, err)
+	})
+}
+
+func TestUnitSelector(t *testing.T) {
+	s, err := NewSelector(fakeAvro{}, map[string]string{"refund-request-ui": FormatJSON})
+	require.NoError(t, err)
+
+	header := func(contentType string) []*sarama.RecordHeader {
+		return []*sarama.RecordHeader{{Key: []byte("Content-Type"), Value: []byte(contentType)}}
+	}
+
+	tests := []struct {
+		name   string
+		msg    *sarama.ConsumerMessage
+		format string
+	}{
+		{"messages are avro by default", &sarama.ConsumerMessage{Topic: "refund-request", Value: []byte("avro")}, FormatAvro},
+		{"the topic's format is used without a header", &sarama.ConsumerMessage{Topic: "refund-request-ui", Value: []byte(refundRequestJSON)}, FormatJSON},
+		{"the content-type header overrides the topic", &sarama.ConsumerMessage{Topic: "refund-request-ui", Value: encodeProtobuf(refundRequest), Headers: header("application/x-protobuf")}, FormatProtobuf},
+		{"content-type parameters are ignored", &sarama.ConsumerMessage{Topic: "refund-request", Value: []byte(refundRequestJSON), Headers: header("application/json; charset=utf-8")}, FormatJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := s.Format(tt.msg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, format)
+
+			rr, err := s.Decode(tt.msg)
+			require.NoError(t, err)
+			assert.Equal(t, refundRequest, rr)
+		})
+	}
+
+	t.Run("unsupported content types are an error", func(t *testing.T) {
+		_, err := s.Decode(&sarama.ConsumerMessage{Value: []byte("<xml/>"), Headers: header("application/xml")})
+		assert.ErrorContains(t, err, "unsupported content type [application/xml]")
+	})
+
+	t.Run("decoding errors name the format", func(t *testing.T) {
+		_, err := s.Decode(&sarama.ConsumerMessage{Value: []byte("{"), Headers: header("application/json")})
+		assert.ErrorContains(t, err, "error decoding json refund request")
+	})
+
+	t.Run("a value can be decoded in a known format", func(t *testing.T) {
+		rr, err := s.DecodeFormat(FormatProtobuf, encodeProtobuf(refundRequest))
+		require.NoError(t, err)
+		assert.Equal(t, refundRequest, rr)
+
+		_, err = s.DecodeFormat("xml", []byte("<xml/>"))
+		assert.ErrorContains(t, err, "unknown format [xml]")
+	})
+
+	t.Run("unknown topic formats are an error", func(t *testing.T) {
+		_, err := NewSelector(fakeAvro{}, map[string]string{"refund-request": "xml"})
+		assert.Error(t, err)
+	})
+}
+
+func TestUnitParseTopicFormats(t *testing.T) {
+	topics, err := ParseTopicFormats([]string{"refund-request-ui=JSON, refund-request-proto=protobuf", "refund-request=avro"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"refund-request-ui": FormatJSON, "refund-request-proto": FormatProtobuf, "refund-request": FormatAvro}, topics)
+
+	for _, specs := range [][]string{{"refund-request"}, {"=json"}, {"refund-request=xml"}, {"refund-request=json", "refund-request=avro"}} {
+		_, err := ParseTopicFormats(specs)
+		assert.Error(t, err, specs)
+	}
+}
@@ -0,0 +1,33 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+)
+
+// jsonRefundRequest is a refund request published as json. Fields are named
+// as in the avro schema.
+type jsonRefundRequest struct {
+	Attempt         int32  `json:"attempt"`
+	PaymentID       string `json:"payment_id"`
+	RefundAmount    string `json:"refund_amount"`
+	RefundReference string `json:"refund_reference"`
+	Currency        string `json:"currency"`
+	Reason          string `json:"reason"`
+	RequestedBy     string `json:"requested_by"`
+}
+
+// JSON decodes json refund requests. Fields the decoder does not know are
+// ignored, so that producers can add fields.
+type JSON struct{}
+
+// Decode implements Decoder.Decode.
+func (JSON) Decode(value []byte) (data.RefundRequest, error) {
+	var r jsonRefundRequest
+	if err := json.NewDecoder(bytes.NewReader(value)).Decode(&r); err != nil {
+		return data.RefundRequest{}, err
+	}
+	return data.RefundRequest(r), nil
+}
@@ -0,0 +1,71 @@
+package codec
+
+import (
+	"fmt"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the RefundRequest message in refund_request.proto.
+const (
+	fieldAttempt         protowire.Number = 1
+	fieldPaymentID       protowire.Number = 2
+	fieldRefundAmount    protowire.Number = 3
+	fieldRefundReference protowire.Number = 4
+	fieldCurrency        protowire.Number = 5
+	fieldReason          protowire.Number = 6
+	fieldRequestedBy     protowire.Number = 7
+)
+
+// Protobuf decodes refund requests encoded as the RefundRequest message in
+// refund_request.proto. Unknown fields are skipped, so that producers can add
+// fields.
+type Protobuf struct{}
+
+// Decode implements Decoder.Decode.
+func (Protobuf) Decode(value []byte) (data.RefundRequest, error) {
+	var rr data.RefundRequest
+	strings := map[protowire.Number]*string{
+		fieldPaymentID:       &rr.PaymentID,
+		fieldRefundAmount:    &rr.RefundAmount,
+		fieldRefundReference: &rr.RefundReference,
+		fieldCurrency:        &rr.Currency,
+		fieldReason:          &rr.Reason,
+		fieldRequestedBy:     &rr.RequestedBy,
+	}
+
+	for len(value) > 0 {
+		num, typ, n := protowire.ConsumeTag(value)
+		if n < 0 {
+			return data.RefundRequest{}, protowire.ParseError(n)
+		}
+		value = value[n:]
+
+		switch s, ok := strings[num]; {
+		case num == fieldAttempt && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(value)
+			if n < 0 {
+				return data.RefundRequest{}, fmt.Errorf("error decoding field attempt: %w", protowire.ParseError(n))
+			}
+			rr.Attempt = int32(v)
+			value = value[n:]
+		case ok && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(value)
+			if n < 0 {
+				return data.RefundRequest{}, fmt.Errorf("error decoding field [%d]: %w", num, protowire.ParseError(n))
+			}
+			*s = v
+			value = value[n:]
+		case num == fieldAttempt || ok:
+			return data.RefundRequest{}, fmt.Errorf("field [%d] has unexpected wire type [%d]", num, typ)
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, value)
+			if n < 0 {
+				return data.RefundRequest{}, fmt.Errorf("error skipping field [%d]: %w", num, protowire.ParseError(n))
+			}
+			value = value[n:]
+		}
+	}
+	return rr, nil
+}
@@ -0,0 +1,428 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/refund-request-consumer/audit"
+	"github.com/companieshouse/refund-request-consumer/codec"
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/ladder"
+	"github.com/companieshouse/refund-request-consumer/registry"
+	"github.com/companieshouse/refund-request-consumer/replay"
+	"github.com/companieshouse/refund-request-consumer/service"
+	"github.com/companieshouse/refund-request-consumer/validation"
+)
+
+const commandServe = "serve"
+
+// command is a subcommand of the binary. Every command is given the
+// configuration read from the environment and the flags before the command
+// name, and the arguments after it.
+type command struct {
+	name        string
+	description string
+	run         func(cfg *config.Config, args []string) error
+}
+
+var commands = []command{
+	{commandServe, "Run the consumers. This is the default command.", runServe},
+	{"replay", "Republish failed refund requests from the error or dead-letter topic to the main topic.", runReplay},
+	{"inspect-topic", "Show the offsets of a topic and decode its latest messages.", runInspectTopic},
+	{"submit", "Submit one refund to the payments api as a consumer would.", runSubmit},
+	{"validate-config", "Check the configuration and show the consumer group of each role.", runValidateConfig},
+	{"validate-request", "Check refund requests against the consumers' validation rules.", runValidateRequest},
+	{"lag", "Show how far the consumer group of each role is behind its topic.", runLag},
+}
+
+// commandFor returns the command with the given name.
+func commandFor(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// printUsage writes the available commands to w.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [configuration flags] [command] [command flags]\n\nCommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.description)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun '%s [command] -h' for the flags of a command.\n", os.Args[0])
+}
+
+// newFlagSet returns the flag set of a command, which returns an error rather
+// than exiting when the flags are invalid.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+// signalContext returns a context cancelled when the command is interrupted.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// newKafkaClient returns a client for the brokers. Message timestamps, shown
+// by some commands, are only fetched from kafka 0.10 onwards.
+func newKafkaClient(cfg *config.Config) (sarama.Client, error) {
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.Version = sarama.V0_10_0_0
+	client, err := sarama.NewClient(cfg.BrokerAddr, kafkaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kafka client: %w", err)
+	}
+	return client, nil
+}
+
+// refundRequestSchema returns the refund request schema from the registry, or
+// if it is unavailable the cached or embedded schema.
+func refundRequestSchema(cfg *config.Config) (*registry.Provider, error) {
+	return service.LoadSchema(cfg, service.RefundRequestSubject, data.RefundRequestSchema)
+}
+
+// refundRequestDecoder returns a decoder of refund requests in the format of
+// their content type or topic. Avro refund requests written with any
+// compatible schema are read, and those without a schema ID with the latest
+// schema.
+func refundRequestDecoder(cfg *config.Config, latest registry.Unmarshaler) (*codec.Selector, error) {
+	avroDecoder, err := registry.NewDecoder(registry.NewClient(cfg.SchemaRegistryURL, nil), data.RefundRequestSchema, latest)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising refund request decoder: %w", err)
+	}
+	formats, err := codec.ParseTopicFormats(cfg.TopicFormats)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring topic formats: %w", err)
+	}
+	decoder, err := codec.NewSelector(codec.Avro{Schema: avroDecoder}, formats)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising refund request decoder: %w", err)
+	}
+	return decoder, nil
+}
+
+// assignments returns the assignment of every consumer role.
+func assignments(cfg *config.Config) ([]service.Assignment, error) {
+	tiers, err := ladder.ParseTiers(cfg.ConsumerTopic, cfg.RetryTiers)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring retry ladder: %w", err)
+	}
+	return service.Assignments(cfg, tiers), nil
+}
+
+// runValidateConfig reports every problem with the configuration, and the
+// consumer group and topic of each role.
+func runValidateConfig(cfg *config.Config, args []string) error {
+	if err := newFlagSet("validate-config").Parse(args); err != nil {
+		return err
+	}
+
+	if err := service.ValidateConfig(cfg); err != nil {
+		fmt.Fprintln(os.Stdout, "Configuration is invalid:")
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			for _, e := range joined.Unwrap() {
+				fmt.Fprintf(os.Stdout, "  - %s\n", e)
+			}
+		}
+		return errors.New("configuration is invalid")
+	}
+
+	fmt.Fprintln(os.Stdout, "Configuration is valid.")
+	as, err := assignments(cfg)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tGROUP\tTOPIC\tACTIVE")
+	for _, a := range as {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", a.Role, a.Group, a.Topic, a.Active)
+	}
+	return tw.Flush()
+}
+
+// runLag shows the lag of the consumer group of every role on each partition
+// of its topic.
+func runLag(cfg *config.Config, args []string) error {
+	flags := newFlagSet("lag")
+	role := flags.String("role", "", "Only show the lag of this role")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	as, err := assignments(cfg)
+	if err != nil {
+		return err
+	}
+	if *role != "" {
+		a, ok := service.AssignmentFor(as, *role)
+		if !ok {
+			return fmt.Errorf("unknown role [%s]", *role)
+		}
+		as = []service.Assignment{a}
+	}
+
+	client, err := newKafkaClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return fmt.Errorf("error creating kafka cluster admin: %w", err)
+	}
+
+	lags, err := service.GroupLag(as, client, admin)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tGROUP\tTOPIC\tPARTITION\tCOMMITTED\tNEWEST\tLAG")
+	for _, l := range lags {
+		committed := "-"
+		if l.Committed >= 0 {
+			committed = fmt.Sprint(l.Committed)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d\t%d\n", l.Role, l.Group, l.Topic, l.Partition, committed, l.Newest, l.Lag)
+	}
+	return tw.Flush()
+}
+
+// runInspectTopic shows the oldest and newest offsets of each partition of a
+// topic, and optionally decodes the latest messages on each.
+func runInspectTopic(cfg *config.Config, args []string) error {
+	flags := newFlagSet("inspect-topic")
+	topic := flags.String("topic", cfg.ConsumerTopic, "Topic to inspect")
+	role := flags.String("role", "", "Inspect the topic consumed by this role rather than -topic")
+	messages := flags.Int64("messages", 0, "Number of the latest messages on each partition to decode")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *role != "" {
+		as, err := assignments(cfg)
+		if err != nil {
+			return err
+		}
+		a, ok := service.AssignmentFor(as, *role)
+		if !ok {
+			return fmt.Errorf("unknown role [%s]", *role)
+		}
+		*topic = a.Topic
+	}
+
+	client, err := newKafkaClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	partitions, err := client.Partitions(*topic)
+	if err != nil {
+		return fmt.Errorf("error listing partitions of topic [%s]: %w", *topic, err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "TOPIC\tPARTITION\tOLDEST\tNEWEST\tMESSAGES\n")
+	for _, partition := range partitions {
+		oldest, err := client.GetOffset(*topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return fmt.Errorf("error getting oldest offset of partition %d: %w", partition, err)
+		}
+		newest, err := client.GetOffset(*topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return fmt.Errorf("error getting newest offset of partition %d: %w", partition, err)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", *topic, partition, oldest, newest, newest-oldest)
+	}
+	if err := tw.Flush(); err != nil || *messages <= 0 {
+		return err
+	}
+
+	avroSchema, err := refundRequestSchema(cfg)
+	if err != nil {
+		return err
+	}
+	decoder, err := refundRequestDecoder(cfg, avroSchema)
+	if err != nil {
+		return err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("error creating kafka consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	// Messages on the dead-letter topic are unwrapped from their envelopes.
+	source := replay.SourceError
+	if *topic == cfg.DeadLetterTopic {
+		source = replay.SourceDeadLetter
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	fmt.Fprintln(os.Stdout)
+	encoder := json.NewEncoder(os.Stdout)
+	reader := &replay.Reader{Topic: *topic, Source: source, Client: client, Consumer: consumer, Decoder: decoder}
+	return reader.Read(ctx, replay.Filter{Last: *messages}, func(record replay.Record) error {
+		return encoder.Encode(replay.LineOf(record))
+	})
+}
+
+// runValidateRequest checks one refund request given by flags, or json refund
+// requests read from stdin one per line, against the validation rules of the
+// consumers, reporting every invalid field.
+func runValidateRequest(cfg *config.Config, args []string) error {
+	flags := newFlagSet("validate-request")
+	paymentID := flags.String("payment-id", "", "Payment ID to refund")
+	amount := flags.String("amount", "", "Amount to refund in pounds, e.g. 10.50")
+	reference := flags.String("refund-reference", "", "Reference of the refund")
+	attempt := flags.Int("attempt", 1, "Attempt number of the refund request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	rules, err := service.ValidationRules(cfg)
+	if err != nil {
+		return err
+	}
+
+	var requests []data.RefundRequest
+	if *paymentID != "" || *amount != "" || *reference != "" {
+		requests = append(requests, data.RefundRequest{Attempt: int32(*attempt), PaymentID: *paymentID, RefundAmount: *amount, RefundReference: *reference})
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			rr, err := codec.JSON{}.Decode(scanner.Bytes())
+			if err != nil {
+				return fmt.Errorf("error decoding refund request on line %d: %w", line, err)
+			}
+			requests = append(requests, rr)
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading refund requests: %w", err)
+		}
+	}
+
+	invalid := 0
+	for _, rr := range requests {
+		pence, err := rules.Validate(rr)
+		var verr *validation.Error
+		if errors.As(err, &verr) {
+			invalid++
+			fmt.Fprintf(os.Stdout, "Refund request for payment [%s] is invalid:\n", rr.PaymentID)
+			for _, f := range verr.Fields {
+				fmt.Fprintf(os.Stdout, "  - %s\n", f)
+			}
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "Refund request for payment [%s] of %d pence is valid.\n", rr.PaymentID, pence)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d refund requests are invalid", invalid, len(requests))
+	}
+	return nil
+}
+
+// runSubmit submits one refund to the payments api through the same code path
+// as a consumed refund request, sharing the consumers' idempotency store and
+// refund policy. A refund breaching the policy is held for approval instead.
+func runSubmit(cfg *config.Config, args []string) error {
+	flags := newFlagSet("submit")
+	paymentID := flags.String("payment-id", "", "Payment ID to refund (required)")
+	amount := flags.String("amount", "", "Amount to refund in pounds, e.g. 10.50 (required)")
+	reference := flags.String("refund-reference", "", "Reference of the refund (required)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *paymentID == "" || *amount == "" || *reference == "" {
+		flags.Usage()
+		return errors.New("-payment-id, -amount and -refund-reference are required")
+	}
+
+	dedupe, err := idempotency.NewStore(cfg.IdempotencyStore, cfg.IdempotencyStorePath, cfg.IdempotencyStoreSize, time.Duration(cfg.IdempotencyStoreTTL)*time.Hour)
+	if err != nil {
+		return fmt.Errorf("error initialising idempotency store: %w", err)
+	}
+	defer dedupe.Close()
+
+	svc, err := service.NewSubmitter(cfg, dedupe)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	// The refund is checked against the refund policy as a consumed one is,
+	// and its outcome published so that the consumers count it towards the
+	// policy totals.
+	limits := service.PolicyLimits(cfg)
+	if limits.Enabled() || cfg.OutcomeTopic != "" {
+		p, err := producer.New(&producer.Config{Acks: &producer.WaitForAll, BrokerAddrs: cfg.BrokerAddr})
+		if err != nil {
+			return fmt.Errorf("error creating kafka producer: %w", err)
+		}
+		defer p.Close()
+
+		schemas, err := service.NewSchemas(cfg)
+		if err != nil {
+			return fmt.Errorf("error loading schemas: %w", err)
+		}
+		if cfg.OutcomeTopic != "" {
+			svc.Audit = audit.New(cfg.OutcomeTopic, p, schemas.Outcome)
+		}
+		if limits.Enabled() {
+			if cfg.HeldTopic == "" {
+				return errors.New("the refund policy requires a held topic")
+			}
+			if limits.Totals() && cfg.OutcomeTopic == "" {
+				return errors.New("the refund policy totals require an outcome topic")
+			}
+			engine, holds, stopPolicy, err := startPolicy(ctx, cfg, p, dedupe, schemas)
+			if err != nil {
+				return err
+			}
+			defer stopPolicy()
+			svc.Policy, svc.Holds = engine, holds
+		}
+	}
+
+	rr := data.RefundRequest{Attempt: 1, PaymentID: *paymentID, RefundAmount: *amount, RefundReference: *reference}
+	err = svc.Submit(ctx, rr)
+	if errors.Is(err, service.ErrHeld) {
+		fmt.Fprintf(os.Stdout, "Refund of %s for payment [%s] not submitted: %v.\n", *amount, *paymentID, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Refund of %s submitted for payment [%s].\n", *amount, *paymentID)
+	return nil
+}
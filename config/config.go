@@ -19,14 +19,56 @@ type Config struct {
 	ZookeeperURL           string      `env:"KAFKA_ZOOKEEPER_ADDR"              flag:"zookeeper-addr"                    flagDesc:"Zookeeper address"`
 	ConsumerGroupName      string      `env:"REFUND_REQUEST_GROUP_NAME"         flag:"refund-request-group-name"         flagDesc:"Refund Request Group Name"`
 	ConsumerRetryGroupName string      `env:"REFUND_REQUEST_RETRY_GROUP_NAME"   flag:"refund-request-retry-group-name"   flagDesc:"Refund Request retry Group Name"`
+	ConsumerErrorGroupName string      `env:"REFUND_REQUEST_ERROR_GROUP_NAME"   flag:"refund-request-error-group-name"   flagDesc:"Refund Request error Group Name"`
 	ConsumerTopic          string      `env:"REFUND_REQUEST_TOPIC"              flag:"refund-request-topic"              flagDesc:"Refund Request topic"`
 	ConsumerTopicOffset    int64       `env:"REFUND_REQUEST_TOPIC_OFFSET"       flag:"refund-request-topic-offset"       flagDesc:"Refund Request topic offset value"`
 	RetryTopicOffset       int64       `env:"REFUND_REQUEST_RETRY_TOPIC_OFFSET" flag:"refund-request-retry-topic-offset" flagDesc:"Refund Request retry topic offset value"`
-	RetryThrottleRate      int         `env:"RETRY_THROTTLE_RATE_SECONDS"       flag:"retry-throttle-rate-seconds"       flagDesc:"Retry throttle rate seconds"`
+	RetryThrottleRate      int         `env:"RETRY_THROTTLE_RATE_SECONDS"       flag:"retry-throttle-rate-seconds"       flagDesc:"Delay before the first retry in seconds, doubled for each further attempt"`
+	RetryBackoffMax        int         `env:"RETRY_BACKOFF_MAX_SECONDS"         flag:"retry-backoff-max-seconds"         flagDesc:"Maximum delay before a retry"`
+	RetryBackoffJitter     int         `env:"RETRY_BACKOFF_JITTER_PERCENT"      flag:"retry-backoff-jitter-percent"      flagDesc:"Percentage by which a retry delay is randomly reduced, or a retry tier delay randomly extended"`
 	MaxRetryAttempts       int         `env:"MAXIMUM_RETRY_ATTEMPTS"            flag:"max-retry-attempts"                flagDesc:"Maximum retry attempts"`
+	RetryTiers             []string    `env:"REFUND_REQUEST_RETRY_TIERS"        flag:"refund-request-retry-tiers"        flagDesc:"Delays of the retry topic ladder, e.g. 1m,10m,1h, replacing the single retry topic"`
+	TopicFormats           []string    `env:"REFUND_REQUEST_TOPIC_FORMATS"      flag:"refund-request-topic-formats"      flagDesc:"Formats of messages without a content-type header by topic, e.g. refund-request-ui=json, avro if not listed"`
 	IsErrorConsumer        bool        `env:"IS_ERROR_QUEUE_CONSUMER"           flag:"is-error-queue-consumer"           flagDesc:"Set this flag if it is an error queue consumer"`
 	PaymentsAPIURL         string      `env:"PAYMENTS_API_URL"                  flag:"payments-api-url"                  flagDesc:"Base URL for the Payment Service API"`
 	ChsAPIKey              string      `env:"REFUNDS_API_KEY"                   flag:"refunds-api-key"                   flagDesc:"API access key"`
+	PaymentsConnectTimeout int         `env:"PAYMENTS_CONNECT_TIMEOUT_SECONDS"  flag:"payments-connect-timeout-seconds"  flagDesc:"Payments API connect timeout seconds"`
+	PaymentsReadTimeout    int         `env:"PAYMENTS_READ_TIMEOUT_SECONDS"     flag:"payments-read-timeout-seconds"     flagDesc:"Payments API response header timeout seconds"`
+	PaymentsTimeout        int         `env:"PAYMENTS_TIMEOUT_SECONDS"          flag:"payments-timeout-seconds"          flagDesc:"Payments API overall request timeout seconds"`
+	PaymentsHealthURL      string      `env:"PAYMENTS_HEALTHCHECK_URL"          flag:"payments-healthcheck-url"          flagDesc:"Payments API URL probed by the readiness check"`
+	CircuitFailures        int         `env:"PAYMENTS_CIRCUIT_FAILURES"         flag:"payments-circuit-failures"         flagDesc:"Consecutive payments API failures opening the circuit, 0 to disable"`
+	CircuitOpenTimeout     int         `env:"PAYMENTS_CIRCUIT_OPEN_SECONDS"     flag:"payments-circuit-open-seconds"     flagDesc:"Seconds the payments API circuit stays open before probing"`
+	CircuitProbes          int         `env:"PAYMENTS_CIRCUIT_PROBES"           flag:"payments-circuit-probes"           flagDesc:"Successful probes needed to close the payments API circuit"`
+	RateLimit              float64     `env:"PAYMENTS_RATE_LIMIT"               flag:"payments-rate-limit"               flagDesc:"Refund submissions per second allowed across all consumers, 0 for no limit"`
+	RateLimitBurst         int         `env:"PAYMENTS_RATE_LIMIT_BURST"         flag:"payments-rate-limit-burst"         flagDesc:"Refund submissions allowed in a burst above the rate limit"`
+	AdminAPIKey            string      `env:"ADMIN_API_KEY"                     flag:"admin-api-key"                     flagDesc:"Key authenticating requests to the admin endpoints, which are disabled without one"`
+	RecentOutcomes         int         `env:"ADMIN_RECENT_OUTCOMES"             flag:"admin-recent-outcomes"             flagDesc:"Number of recent message outcomes kept by each consumer for the admin endpoints"`
+	StallTimeout           int         `env:"CONSUMER_STALL_TIMEOUT_SECONDS"    flag:"consumer-stall-timeout-seconds"    flagDesc:"Seconds processing one message before the consumer is not ready"`
+	SchemaCacheDir         string      `env:"SCHEMA_CACHE_DIR"                  flag:"schema-cache-dir"                  flagDesc:"Directory caching the last schemas fetched from the schema registry, not used if empty"`
+	SchemaFallback         bool        `env:"SCHEMA_EMBEDDED_FALLBACK"          flag:"schema-embedded-fallback"          flagDesc:"Use the schemas built into the consumer if neither the schema registry nor the cache provide them"`
+	SchemaRefresh          int         `env:"SCHEMA_REFRESH_SECONDS"            flag:"schema-refresh-seconds"            flagDesc:"Seconds between refreshes of the schemas from the schema registry, never refreshed if 0"`
+	SchemaMaxAge           int         `env:"SCHEMA_MAX_AGE_SECONDS"            flag:"schema-max-age-seconds"            flagDesc:"Seconds a schema can go unrefreshed before readiness fails, unlimited if 0"`
+	DeadLetterTopic        string      `env:"REFUND_REQUEST_DLQ_TOPIC"          flag:"refund-request-dlq-topic"          flagDesc:"Refund Request dead-letter topic"`
+	OutcomeTopic           string      `env:"REFUND_REQUEST_OUTCOME_TOPIC"      flag:"refund-request-outcome-topic"      flagDesc:"Topic of the refund request outcome audit events, which are not published if empty"`
+	IdempotencyStore       string      `env:"IDEMPOTENCY_STORE"                 flag:"idempotency-store"                 flagDesc:"Idempotency store kind: memory or file"`
+	IdempotencyStorePath   string      `env:"IDEMPOTENCY_STORE_PATH"            flag:"idempotency-store-path"            flagDesc:"Idempotency store file path"`
+	IdempotencyStoreSize   int         `env:"IDEMPOTENCY_STORE_SIZE"            flag:"idempotency-store-size"            flagDesc:"Maximum records held by the memory idempotency store"`
+	IdempotencyStoreTTL    int         `env:"IDEMPOTENCY_STORE_TTL_HOURS"       flag:"idempotency-store-ttl-hours"       flagDesc:"Hours the file idempotency store keeps a record after it was last updated"`
+	SuccessStatuses        []string    `env:"PAYMENTS_API_SUCCESS_STATUSES"     flag:"payments-api-success-statuses"     flagDesc:"Payments API statuses treated as a successful refund"`
+	DuplicateStatuses      []string    `env:"PAYMENTS_API_DUPLICATE_STATUSES"   flag:"payments-api-duplicate-statuses"   flagDesc:"Payments API statuses treated as an already processed refund"`
+	RetryableStatuses      []string    `env:"PAYMENTS_API_RETRYABLE_STATUSES"   flag:"payments-api-retryable-statuses"   flagDesc:"Payments API statuses treated as a retryable failure"`
+	Concurrency            int         `env:"CONSUMER_CONCURRENCY"              flag:"consumer-concurrency"              flagDesc:"Number of messages processed concurrently by each consumer"`
+	OrderBy                string      `env:"CONSUMER_ORDER_BY"                 flag:"consumer-order-by"                 flagDesc:"Messages processed in order per partition or per payment_id"`
+	DrainTimeout           int         `env:"SHUTDOWN_DRAIN_TIMEOUT_SECONDS"    flag:"shutdown-drain-timeout-seconds"    flagDesc:"Seconds in-flight work may take to finish at shutdown before it is cancelled"`
+	PaymentIDPattern       string      `env:"PAYMENT_ID_PATTERN"                flag:"payment-id-pattern"                flagDesc:"Regular expression valid payment IDs match"`
+	MaxReferenceLength     int         `env:"REFUND_REFERENCE_MAX_LENGTH"       flag:"refund-reference-max-length"       flagDesc:"Maximum length of a refund reference"`
+	MaxRefundAmount        int         `env:"REFUND_MAX_AMOUNT_PENCE"           flag:"refund-max-amount-pence"           flagDesc:"Largest valid refund in pence, the payments API limit if 0"`
+	MaxAttempt             int         `env:"REFUND_REQUEST_MAX_ATTEMPT"        flag:"refund-request-max-attempt"        flagDesc:"Largest valid attempt number of a refund request"`
+	PolicyHoldAbove        int         `env:"REFUND_POLICY_HOLD_ABOVE_PENCE"    flag:"refund-policy-hold-above-pence"    flagDesc:"Refunds above this many pence are held for approval, none if 0"`
+	PolicyDailyPayment     int         `env:"REFUND_POLICY_DAILY_PAYMENT_PENCE" flag:"refund-policy-daily-payment-pence" flagDesc:"Refunds taking one payment above this many pence in 24 hours are held for approval, none if 0"`
+	PolicyHourly           int         `env:"REFUND_POLICY_HOURLY_PENCE"        flag:"refund-policy-hourly-pence"        flagDesc:"Refunds taking all payments above this many pence in an hour are held for approval, none if 0"`
+	HeldTopic              string      `env:"REFUND_REQUEST_HELD_TOPIC"         flag:"refund-request-held-topic"         flagDesc:"Topic of the refund requests held for approval"`
+	VerifyPayment          bool        `env:"PAYMENTS_VERIFY_PAYMENT"           flag:"payments-verify-payment"           flagDesc:"Check the payment is paid and has enough left to refund before submitting a refund"`
 }
 
 // Namespace implements service.Config.Namespace.
@@ -47,11 +89,36 @@ func Get() (*Config, error) {
 		ZookeeperChroot:        "",
 		ConsumerGroupName:      "refund-request-consumer",
 		ConsumerRetryGroupName: "refund-request-consumer-retry",
+		ConsumerErrorGroupName: "refund-request-consumer-error",
 		ConsumerTopic:          "refund-request",
 		ConsumerTopicOffset:    int64(-1),
 		RetryTopicOffset:       int64(-1),
 		RetryThrottleRate:      3,
+		RetryBackoffMax:        300,
+		RetryBackoffJitter:     20,
 		MaxRetryAttempts:       2,
+		PaymentsConnectTimeout: 5,
+		PaymentsReadTimeout:    30,
+		PaymentsTimeout:        60,
+		CircuitFailures:        5,
+		CircuitOpenTimeout:     30,
+		CircuitProbes:          2,
+		RateLimitBurst:         1,
+		RecentOutcomes:         100,
+		StallTimeout:           120,
+		SchemaCacheDir:         "/tmp/refund-request-consumer/schemas",
+		SchemaRefresh:          300,
+		DeadLetterTopic:        "refund-request-dlq",
+		IdempotencyStore:       "memory",
+		IdempotencyStoreSize:   10000,
+		IdempotencyStoreTTL:    168,
+		Concurrency:            4,
+		OrderBy:                "partition",
+		DrainTimeout:           30,
+		MaxReferenceLength:     64,
+		MaxAttempt:             100,
+		HeldTopic:              "refund-request-held",
+		VerifyPayment:          true,
 	}
 
 	err := gofigure.Gofigure(cfg)
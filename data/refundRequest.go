@@ -1,12 +1,29 @@
 // Package data contains the required data structures.
 package data
 
-// RefundRequest represents the avro schema.
+// RefundRequestSchema is the schema refund requests are read as. Messages
+// written with any compatible schema can be read, so fields added by producers
+// must be optional and are only read once added here. It is also used when the
+// schema cannot be fetched from the schema registry.
+const RefundRequestSchema = `{"type":"record","name":"refund_request","namespace":"payments","fields":[` +
+	`{"name":"attempt","type":"int"},` +
+	`{"name":"payment_id","type":"string"},` +
+	`{"name":"refund_amount","type":"string"},` +
+	`{"name":"refund_reference","type":"string"},` +
+	`{"name":"currency","type":["null","string"],"default":null},` +
+	`{"name":"reason","type":["null","string"],"default":null},` +
+	`{"name":"requested_by","type":["null","string"],"default":null}]}`
+
+// RefundRequest represents the avro schema. The optional fields are empty when
+// the producer did not set them.
 type RefundRequest struct {
 	Attempt         int32  `avro:"attempt"`
 	PaymentID       string `avro:"payment_id"`
 	RefundAmount    string `avro:"refund_amount"`
 	RefundReference string `avro:"refund_reference"`
+	Currency        string `avro:"currency"`
+	Reason          string `avro:"reason"`
+	RequestedBy     string `avro:"requested_by"`
 }
 
 // RefundPostRequest represents the request body when posting the payment resource.
@@ -14,3 +31,20 @@ type RefundPostRequest struct {
 	Amount          int    `json:"amount"`
 	RefundReference string `json:"refund_reference"`
 }
+
+// PaymentResource represents the payment resource returned by the payments
+// api. Its amount is a decimal string in pounds.
+type PaymentResource struct {
+	Amount  string           `json:"amount"`
+	Status  string           `json:"status"`
+	Refunds []RefundResource `json:"refunds"`
+}
+
+// RefundResource represents a refund of a payment resource. Its amount is in
+// pence.
+type RefundResource struct {
+	RefundID  string `json:"refund_id"`
+	CreatedAt string `json:"created_at"`
+	Amount    int    `json:"amount"`
+	Status    string `json:"status"`
+}
@@ -0,0 +1,37 @@
+package data
+
+// RefundRequestOutcomeSchema is the schema of the outcome event, used when it
+// cannot be fetched from the schema registry.
+const RefundRequestOutcomeSchema = `{"type":"record","name":"refund_request_outcome","namespace":"payments","fields":[` +
+	`{"name":"payment_id","type":"string"},` +
+	`{"name":"refund_reference","type":"string"},` +
+	`{"name":"amount_pence","type":"long"},` +
+	`{"name":"attempt","type":"int"},` +
+	`{"name":"outcome","type":"string"},` +
+	`{"name":"failure_class","type":"string"},` +
+	`{"name":"http_status","type":"int"},` +
+	`{"name":"latency_ms","type":"long"},` +
+	`{"name":"consumer_role","type":"string"},` +
+	`{"name":"topic","type":"string"},` +
+	`{"name":"partition","type":"int"},` +
+	`{"name":"offset","type":"long"},` +
+	`{"name":"processed_at","type":"long"}]}`
+
+// RefundRequestOutcome represents the avro schema of the event recording what
+// was decided for a refund request. HTTPStatus is zero when the payments api
+// was not called or did not respond.
+type RefundRequestOutcome struct {
+	PaymentID       string `avro:"payment_id"`
+	RefundReference string `avro:"refund_reference"`
+	AmountPence     int64  `avro:"amount_pence"`
+	Attempt         int32  `avro:"attempt"`
+	Outcome         string `avro:"outcome"`
+	FailureClass    string `avro:"failure_class"`
+	HTTPStatus      int32  `avro:"http_status"`
+	LatencyMillis   int64  `avro:"latency_ms"`
+	Role            string `avro:"consumer_role"`
+	Topic           string `avro:"topic"`
+	Partition       int32  `avro:"partition"`
+	Offset          int64  `avro:"offset"`
+	ProcessedAt     int64  `avro:"processed_at"`
+}
@@ -0,0 +1,109 @@
+// Package dlq publishes messages that can never be processed to a dead-letter
+// topic, wrapped in an envelope describing where they came from and why they
+// failed.
+package dlq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// FailureClass categorises why a message was dead-lettered.
+type FailureClass string
+
+// Failure classes recorded on the envelope.
+const (
+	FailureDecode        FailureClass = "decode"
+	FailureInvalidAmount FailureClass = "invalid_amount"
+	FailureInvalid       FailureClass = "invalid_request"
+	FailureRejected      FailureClass = "rejected"
+	FailureNotRefundable FailureClass = "not_refundable"
+)
+
+// Envelope is the record published to the dead-letter topic. Payload holds the
+// original message bytes, unmodified, so the message can be replayed.
+type Envelope struct {
+	Payload      []byte       `json:"payload"`
+	Topic        string       `json:"topic"`
+	Partition    int32        `json:"partition"`
+	Offset       int64        `json:"offset"`
+	FailureClass FailureClass `json:"failure_class"`
+	Error        string       `json:"error"`
+	// Format is the format of the payload, such as json, so that it can be
+	// decoded again to be replayed. Envelopes without one hold a payload in
+	// the format of their topic.
+	Format string `json:"format,omitempty"`
+	// Fields gives the reason each invalid field was rejected, if the message
+	// failed validation.
+	Fields    map[string]string `json:"fields,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// reasoner is implemented by errors giving the reason each invalid field of a
+// message was rejected.
+type reasoner interface {
+	Reasons() map[string]string
+}
+
+// Sender sends a message to kafka. It is satisfied by producer.Producer.
+type Sender interface {
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// Publisher publishes envelopes to the dead-letter topic.
+type Publisher struct {
+	Topic  string
+	Sender Sender
+	now    func() time.Time
+}
+
+// New returns a Publisher that sends envelopes to the given topic.
+func New(topic string, sender Sender) *Publisher {
+	return &Publisher{
+		Topic:  topic,
+		Sender: sender,
+		now:    time.Now,
+	}
+}
+
+// Publish sends the message to the dead-letter topic along with its format,
+// which is empty if it is not known, and the failure class and cause. The
+// consumer must not commit the message's offset unless Publish returns nil.
+func (p *Publisher) Publish(msg *sarama.ConsumerMessage, format string, class FailureClass, cause error) error {
+	envelope := Envelope{
+		Payload:      msg.Value,
+		Topic:        msg.Topic,
+		Partition:    msg.Partition,
+		Offset:       msg.Offset,
+		FailureClass: class,
+		Format:       format,
+		Timestamp:    p.now().UTC(),
+	}
+	if cause != nil {
+		envelope.Error = cause.Error()
+	}
+	var r reasoner
+	if errors.As(cause, &r) {
+		envelope.Fields = r.Reasons()
+	}
+
+	value, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("error marshalling dead-letter envelope: %w", err)
+	}
+
+	_, _, err = p.Sender.SendMessage(&sarama.ProducerMessage{
+		Topic: p.Topic,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing to dead-letter topic [%s]: %w", p.Topic, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package dlq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSender struct {
+	sent []*sarama.ProducerMessage
+	err  error
+}
+
+func (m *mockSender) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	m.sent = append(m.sent, msg)
+	return 0, 0, m.err
+}
+
+var consumerMessage = &sarama.ConsumerMessage{
+	Topic:     "refund-request",
+	Partition: 3,
+	Offset:    42,
+	Key:       []byte("key"),
+	Value:     []byte{0x00, 0x01, 0x02},
+}
+
+func TestUnitPublish(t *testing.T) {
+	sender := &mockSender{}
+	p := New("refund-request-dlq", sender)
+	p.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	err := p.Publish(consumerMessage, "json", FailureInvalidAmount, errors.New("bad amount"))
+	assert.NoError(t, err)
+	assert.Len(t, sender.sent, 1)
+
+	sent := sender.sent[0]
+	assert.Equal(t, "refund-request-dlq", sent.Topic)
+	assert.Equal(t, sarama.ByteEncoder("key"), sent.Key)
+
+	value, _ := sent.Value.Encode()
+	var envelope Envelope
+	assert.NoError(t, json.Unmarshal(value, &envelope))
+	assert.Equal(t, Envelope{
+		Payload:      []byte{0x00, 0x01, 0x02},
+		Topic:        "refund-request",
+		Partition:    3,
+		Offset:       42,
+		FailureClass: FailureInvalidAmount,
+		Error:        "bad amount",
+		Format:       "json",
+		Timestamp:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, envelope)
+}
+
+func TestUnitPublish_SendFailure(t *testing.T) {
+	sender := &mockSender{err: errors.New("broker down")}
+	p := New("refund-request-dlq", sender)
+
+	err := p.Publish(consumerMessage, "", FailureDecode, errors.New("bad avro"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "broker down")
+}
+
+type invalidError struct{}
+
+func (invalidError) Error() string { return "invalid refund request: payment_id is empty" }
+
+func (invalidError) Reasons() map[string]string { return map[string]string{"payment_id": "is empty"} }
+
+func TestUnitPublish_FieldReasons(t *testing.T) {
+	sender := &mockSender{}
+	p := New("refund-request-dlq", sender)
+
+	err := p.Publish(consumerMessage, "", FailureInvalid, fmt.Errorf("error validating refund request: %w", invalidError{}))
+	assert.NoError(t, err)
+
+	value, _ := sender.sent[0].Value.Encode()
+	var envelope Envelope
+	assert.NoError(t, json.Unmarshal(value, &envelope))
+	assert.Equal(t, FailureInvalid, envelope.FailureClass)
+	assert.Equal(t, map[string]string{"payment_id": "is empty"}, envelope.Fields)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/companieshouse/refund-request-consumer/service"
+)
+
+// defaultRecentOutcomes is the number of outcomes listed when none is asked
+// for.
+const defaultRecentOutcomes = 20
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// RequireAPIKey returns a handler serving next only to requests presenting the
+// key as the basic auth username, as chs api keys are presented.
+func RequireAPIKey(key string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _, ok := r.BasicAuth()
+		if !ok || key == "" || subtle.ConstantTimeCompare([]byte(user), []byte(key)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="refund-request-consumer"`)
+			writeJSON(w, http.StatusUnauthorized, adminError{Error: "unauthorised"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Consumers returns a handler listing the status of each consumer.
+func Consumers(consumers []*service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]service.Status, 0, len(consumers))
+		for _, c := range consumers {
+			statuses = append(statuses, c.Status())
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	}
+}
+
+// PauseConsumer returns a handler pausing the consumer, responding 409 if it
+// is already paused.
+func PauseConsumer(consumer *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !consumer.Pause() {
+			writeJSON(w, http.StatusConflict, adminError{Error: "consumer " + consumer.Role + " is already paused"})
+			return
+		}
+		writeJSON(w, http.StatusOK, consumer.Status())
+	}
+}
+
+// ResumeConsumer returns a handler resuming the consumer, responding 409 if it
+// is not paused.
+func ResumeConsumer(consumer *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !consumer.Resume() {
+			writeJSON(w, http.StatusConflict, adminError{Error: "consumer " + consumer.Role + " is not paused"})
+			return
+		}
+		writeJSON(w, http.StatusOK, consumer.Status())
+	}
+}
+
+// RecentOutcomes returns a handler listing the last n messages processed, most
+// recent first, across every consumer or only that given by the role
+// parameter.
+func RecentOutcomes(consumers []*service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := defaultRecentOutcomes
+		if v := r.URL.Query().Get("n"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil || n < 1 {
+				writeJSON(w, http.StatusBadRequest, adminError{Error: "n must be a positive integer"})
+				return
+			}
+		}
+		role := r.URL.Query().Get("role")
+
+		outcomes := []service.Outcome{}
+		for _, c := range consumers {
+			if role == "" || c.Role == role {
+				outcomes = append(outcomes, c.RecentOutcomes(n)...)
+			}
+		}
+		sort.SliceStable(outcomes, func(i, j int) bool { return outcomes[i].ProcessedAt.After(outcomes[j].ProcessedAt) })
+		if len(outcomes) > n {
+			outcomes = outcomes[:n]
+		}
+		writeJSON(w, http.StatusOK, outcomes)
+	}
+}
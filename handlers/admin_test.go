@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/service"
+	"github.com/gorilla/pat"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitAdmin(t *testing.T) {
+	Convey("Admin endpoints", t, func() {
+		main := &service.Service{Role: service.RoleMain, Group: "main-group", Topic: "refund-request"}
+		errorConsumer := &service.Service{Role: service.RoleError, Group: "error-group", Topic: "refund-request-error"}
+		r := pat.New()
+		Init(r, health.NewRegistry(), nil, []*service.Service{main, errorConsumer}, nil, "admin-key")
+
+		serve := func(method, path, key string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, nil)
+			if key != "" {
+				req.SetBasicAuth(key, "")
+			}
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, req)
+			return response
+		}
+
+		Convey("Reject requests without the admin api key", func() {
+			So(serve("GET", "/refund-request-consumer/admin/consumers", "").Code, ShouldEqual, http.StatusUnauthorized)
+			So(serve("GET", "/refund-request-consumer/admin/consumers", "wrong-key").Code, ShouldEqual, http.StatusUnauthorized)
+			So(serve("POST", "/refund-request-consumer/admin/consumers/main/pause", "wrong-key").Code, ShouldEqual, http.StatusUnauthorized)
+			So(main.Status().Paused, ShouldBeFalse)
+		})
+
+		Convey("List the status of each consumer", func() {
+			response := serve("GET", "/refund-request-consumer/admin/consumers", "admin-key")
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			var statuses []service.Status
+			So(json.Unmarshal(response.Body.Bytes(), &statuses), ShouldBeNil)
+			So(statuses, ShouldHaveLength, 2)
+			So(statuses[0].Role, ShouldEqual, service.RoleMain)
+			So(statuses[1].Group, ShouldEqual, "error-group")
+		})
+
+		Convey("Pause and resume a consumer by role", func() {
+			response := serve("POST", "/refund-request-consumer/admin/consumers/error/pause", "admin-key")
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(errorConsumer.Status().Paused, ShouldBeTrue)
+			So(main.Status().Paused, ShouldBeFalse)
+
+			So(serve("POST", "/refund-request-consumer/admin/consumers/error/pause", "admin-key").Code, ShouldEqual, http.StatusConflict)
+
+			So(serve("POST", "/refund-request-consumer/admin/consumers/error/resume", "admin-key").Code, ShouldEqual, http.StatusOK)
+			So(errorConsumer.Status().Paused, ShouldBeFalse)
+			So(serve("POST", "/refund-request-consumer/admin/consumers/error/resume", "admin-key").Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("List recent outcomes", func() {
+			response := serve("GET", "/refund-request-consumer/admin/outcomes?n=5&role=main", "admin-key")
+			So(response.Code, ShouldEqual, http.StatusOK)
+			var outcomes []service.Outcome
+			So(json.Unmarshal(response.Body.Bytes(), &outcomes), ShouldBeNil)
+			So(outcomes, ShouldBeEmpty)
+
+			So(serve("GET", "/refund-request-consumer/admin/outcomes?n=none", "admin-key").Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+
+	Convey("Admin endpoints are not served without an admin api key", t, func() {
+		r := pat.New()
+		Init(r, health.NewRegistry(), nil, []*service.Service{{Role: service.RoleMain}}, nil, "")
+
+		req := httptest.NewRequest("GET", "/refund-request-consumer/admin/consumers", nil)
+		req.SetBasicAuth("", "")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, req)
+		So(response.Code, ShouldEqual, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/companieshouse/refund-request-consumer/service"
+)
+
+// ConsumerGroups returns a handler listing the consumer group and topic of
+// each consumer role.
+func ConsumerGroups(assignments []service.Assignment) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, assignments)
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/refund-request-consumer/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitConsumerGroups(t *testing.T) {
+	Convey("Consumer groups lists the group and topic of each role", t, func() {
+		assignments := []service.Assignment{
+			{Role: service.RoleMain, Group: "main-group", Topic: "refund-request", Active: true},
+			{Role: service.RoleError, Group: "error-group", Topic: "refund-request-error"},
+		}
+
+		req, err := http.NewRequest("GET", "/refund-request-consumer/consumer-groups", nil)
+		So(err, ShouldBeNil)
+		response := httptest.NewRecorder()
+
+		ConsumerGroups(assignments)(response, req)
+		So(response.Code, ShouldEqual, 200)
+
+		var body []service.Assignment
+		So(json.Unmarshal(response.Body.Bytes(), &body), ShouldBeNil)
+		So(body, ShouldResemble, assignments)
+	})
+}
@@ -2,11 +2,34 @@ package handlers
 
 import (
 	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/metrics"
+	"github.com/companieshouse/refund-request-consumer/service"
 	"github.com/gorilla/pat"
 )
 
-func Init(r *pat.Router) {
-	log.Info("initialising healthcheck endpoint beneath basePath: /refund-request-consumer")
+func Init(r *pat.Router, registry *health.Registry, assignments []service.Assignment, consumers []*service.Service, holds *service.Holds, adminAPIKey string) {
+	log.Info("initialising healthcheck, metrics, consumer group and admin endpoints beneath basePath: /refund-request-consumer")
 	appRouter := r.PathPrefix("/refund-request-consumer").Subrouter()
 	appRouter.Path("/healthcheck").Methods("GET").HandlerFunc(HealthCheck)
+	appRouter.Path("/healthcheck/live").Methods("GET").HandlerFunc(Liveness)
+	appRouter.Path("/healthcheck/ready").Methods("GET").HandlerFunc(Readiness(registry))
+	appRouter.Path("/metrics").Methods("GET").Handler(metrics.Handler())
+	appRouter.Path("/consumer-groups").Methods("GET").HandlerFunc(ConsumerGroups(assignments))
+
+	if adminAPIKey == "" {
+		log.Info("no admin api key is configured so the admin endpoints are disabled")
+		return
+	}
+	appRouter.Path("/admin/consumers").Methods("GET").HandlerFunc(RequireAPIKey(adminAPIKey, Consumers(consumers)))
+	appRouter.Path("/admin/outcomes").Methods("GET").HandlerFunc(RequireAPIKey(adminAPIKey, RecentOutcomes(consumers)))
+	for _, c := range consumers {
+		appRouter.Path("/admin/consumers/" + c.Role + "/pause").Methods("POST").HandlerFunc(RequireAPIKey(adminAPIKey, PauseConsumer(c)))
+		appRouter.Path("/admin/consumers/" + c.Role + "/resume").Methods("POST").HandlerFunc(RequireAPIKey(adminAPIKey, ResumeConsumer(c)))
+	}
+	if holds != nil {
+		appRouter.Path("/admin/held").Methods("GET").HandlerFunc(RequireAPIKey(adminAPIKey, HeldRequests(holds)))
+		appRouter.Path("/admin/held/{id}/approve").Methods("POST").HandlerFunc(RequireAPIKey(adminAPIKey, ApproveHeld(holds)))
+		appRouter.Path("/admin/held/{id}/reject").Methods("POST").HandlerFunc(RequireAPIKey(adminAPIKey, RejectHeld(holds)))
+	}
 }
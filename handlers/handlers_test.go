@@ -5,12 +5,13 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/companieshouse/refund-request-consumer/health"
 	"github.com/gorilla/pat"
 )
 
 func TestUnitInit(t *testing.T) {
 	r := pat.New()
-	Init(r)
+	Init(r, health.NewRegistry(), nil, nil, nil, "")
 
 	req := httptest.NewRequest("GET", "/refund-request-consumer/healthcheck", nil)
 	rr := httptest.NewRecorder()
@@ -20,4 +21,15 @@ func TestUnitInit(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
 	}
+
+	for _, path := range []string{"/refund-request-consumer/healthcheck/live", "/refund-request-consumer/healthcheck/ready", "/refund-request-consumer/consumer-groups"} {
+		req = httptest.NewRequest("GET", path, nil)
+		rr = httptest.NewRecorder()
+
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status code %d for %s, got %d", http.StatusOK, path, rr.Code)
+		}
+	}
 }
@@ -1,8 +1,45 @@
 package handlers
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/health"
+)
 
 // HealthCheck returns the health of the application.
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
+
+// Liveness reports that the application is running and able to serve
+// requests. It does not check dependencies, so that a dependency outage does
+// not cause the application to be restarted.
+func Liveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, health.Report{Status: health.StatusOK, Checks: map[string]health.Check{}})
+}
+
+// Readiness returns a handler reporting the state of every dependency in the
+// registry, responding 503 if any check fails.
+func Readiness(registry *health.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := registry.Report()
+
+		status := http.StatusOK
+		if !report.OK() {
+			status = http.StatusServiceUnavailable
+			log.Info("readiness check failed", log.Data{"checks": report.Checks})
+		}
+
+		writeJSON(w, status, report)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error(err)
+	}
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/companieshouse/refund-request-consumer/health"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -18,3 +20,48 @@ func TestUnitHealthCheck(t *testing.T) {
 		So(response.Code, ShouldEqual, 200)
 	})
 }
+
+func TestUnitLiveness(t *testing.T) {
+	Convey("Check 200 Response for Liveness", t, func() {
+		req, err := http.NewRequest("GET", "/refund-request-consumer/healthcheck/live", nil)
+		So(err, ShouldBeNil)
+		response := httptest.NewRecorder()
+
+		Liveness(response, req)
+		So(response.Code, ShouldEqual, 200)
+		So(response.Header().Get("Content-Type"), ShouldEqual, "application/json")
+	})
+}
+
+func TestUnitReadiness(t *testing.T) {
+	Convey("Readiness reports every dependency", t, func() {
+		registry := health.NewRegistry()
+		registry.Register("main.consumer", func() health.Check { return health.Check{Status: health.StatusOK} })
+
+		req, err := http.NewRequest("GET", "/refund-request-consumer/healthcheck/ready", nil)
+		So(err, ShouldBeNil)
+
+		Convey("Given every dependency is healthy then 200 is returned", func() {
+			response := httptest.NewRecorder()
+			Readiness(registry)(response, req)
+
+			So(response.Code, ShouldEqual, 200)
+			var report health.Report
+			So(json.Unmarshal(response.Body.Bytes(), &report), ShouldBeNil)
+			So(report.Status, ShouldEqual, health.StatusOK)
+			So(report.Checks, ShouldContainKey, "main.consumer")
+		})
+
+		Convey("Given a dependency is unhealthy then 503 is returned", func() {
+			registry.Register("payments_api", func() health.Check { return health.Check{Status: health.StatusFail, Detail: "unreachable"} })
+			response := httptest.NewRecorder()
+			Readiness(registry)(response, req)
+
+			So(response.Code, ShouldEqual, 503)
+			var report health.Report
+			So(json.Unmarshal(response.Body.Bytes(), &report), ShouldBeNil)
+			So(report.Status, ShouldEqual, health.StatusFail)
+			So(report.Checks["payments_api"].Detail, ShouldEqual, "unreachable")
+		})
+	})
+}
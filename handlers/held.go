@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/hold"
+	"github.com/companieshouse/refund-request-consumer/service"
+)
+
+// decisionRequest is the optional body of an approval or rejection.
+type decisionRequest struct {
+	Note string `json:"note"`
+}
+
+// HeldRequests returns a handler listing the refund requests held for
+// approval, oldest first.
+func HeldRequests(holds *service.Holds) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, holds.Pending())
+	}
+}
+
+// ApproveHeld returns a handler submitting the held refund request with the
+// id in the path, responding 404 if no request with that id is held and 502
+// if it could not be submitted.
+func ApproveHeld(holds *service.Holds) http.HandlerFunc {
+	return decideHeld(func(r *http.Request, id, note string) (hold.Record, error) {
+		return holds.Approve(r.Context(), id, note)
+	}, http.StatusBadGateway)
+}
+
+// RejectHeld returns a handler rejecting the held refund request with the id
+// in the path, responding 404 if no request with that id is held.
+func RejectHeld(holds *service.Holds) http.HandlerFunc {
+	return decideHeld(func(r *http.Request, id, note string) (hold.Record, error) {
+		return holds.Reject(id, note)
+	}, http.StatusInternalServerError)
+}
+
+func decideHeld(decide func(r *http.Request, id, note string) (hold.Record, error), failure int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body decisionRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: "invalid request body"})
+			return
+		}
+
+		id := r.URL.Query().Get(":id")
+		record, err := decide(r, id, body.Note)
+		switch {
+		case errors.Is(err, service.ErrHoldNotFound):
+			writeJSON(w, http.StatusNotFound, adminError{Error: "no held refund request with id " + id})
+		case err != nil:
+			log.Error(err, log.Data{"hold_id": id})
+			writeJSON(w, failure, adminError{Error: err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, record)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/hold"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/companieshouse/refund-request-consumer/service"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/pat"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordingSender struct {
+	sent []*sarama.ProducerMessage
+}
+
+func (s *recordingSender) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	s.sent = append(s.sent, msg)
+	return 0, 0, nil
+}
+
+func TestUnitHeld(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Held refund request endpoints", t, func() {
+		mockPayment := payment.NewMockPayments(ctrl)
+		submitter := &service.Service{Payments: mockPayment, PaymentsAPIURL: "http://payments", Client: &http.Client{}, Dedupe: idempotency.NewMemoryStore(0)}
+		ledger := hold.NewLedger()
+		holds := service.NewHolds(hold.NewPublisher("refund-request-held", &recordingSender{}), ledger, nil, submitter)
+
+		rr := data.RefundRequest{Attempt: 1, PaymentID: "P1", RefundAmount: "5,000.00", RefundReference: "R1"}
+		held := hold.New(rr, 500000, "over threshold", &sarama.ConsumerMessage{Topic: "refund-request"}, time.Now())
+		ledger.Apply(held)
+
+		r := pat.New()
+		Init(r, health.NewRegistry(), nil, nil, holds, "admin-key")
+
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.SetBasicAuth("admin-key", "")
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, req)
+			return response
+		}
+
+		Convey("List the held requests", func() {
+			response := serve("GET", "/refund-request-consumer/admin/held", "")
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			var records []hold.Record
+			So(json.Unmarshal(response.Body.Bytes(), &records), ShouldBeNil)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].ID, ShouldEqual, held.ID)
+		})
+
+		Convey("Approve a held request", func() {
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), "http://payments/payments/P1/refunds", data.RefundPostRequest{Amount: 500000, RefundReference: "R1"}, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+			response := serve("POST", "/refund-request-consumer/admin/held/"+held.ID+"/approve", `{"note":"checked"}`)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			var record hold.Record
+			So(json.Unmarshal(response.Body.Bytes(), &record), ShouldBeNil)
+			So(record.State, ShouldEqual, hold.StateApproved)
+			So(record.Note, ShouldEqual, "checked")
+			So(holds.Pending(), ShouldBeEmpty)
+		})
+
+		Convey("Reject a held request without a note", func() {
+			response := serve("POST", "/refund-request-consumer/admin/held/"+held.ID+"/reject", "")
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(holds.Pending(), ShouldBeEmpty)
+		})
+
+		Convey("Respond 404 for an unknown request and 400 for an invalid body", func() {
+			So(serve("POST", "/refund-request-consumer/admin/held/unknown/reject", "").Code, ShouldEqual, http.StatusNotFound)
+			So(serve("POST", "/refund-request-consumer/admin/held/"+held.ID+"/reject", "{").Code, ShouldEqual, http.StatusBadRequest)
+			So(holds.Pending(), ShouldHaveLength, 1)
+		})
+	})
+}
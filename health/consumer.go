@@ -0,0 +1,154 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ConsumerTracker records the state of a consumer role's consume loop, its
+// consumer group membership and its producer.
+type ConsumerTracker struct {
+	mu           sync.Mutex
+	stallTimeout time.Duration
+	joined       bool
+	lastMessage  time.Time
+	inFlight     map[uint64]time.Time
+	nextID       uint64
+	producerErr  error
+	consumerErrs int
+	consumerErr  error
+	now          func() time.Time
+}
+
+// consumerErrorLimit is the number of consumer errors in a row, without a
+// message or rebalance in between, after which the consumer is reported as
+// failing.
+const consumerErrorLimit = 5
+
+// NewConsumerTracker returns a ConsumerTracker which reports the consume loop
+// as stalled once it has spent longer than stallTimeout on one message.
+func NewConsumerTracker(stallTimeout time.Duration) *ConsumerTracker {
+	return &ConsumerTracker{
+		stallTimeout: stallTimeout,
+		inFlight:     make(map[uint64]time.Time),
+		now:          time.Now,
+	}
+}
+
+// Joined records whether the consumer is a member of its consumer group, such
+// as when it joins, when a rebalance starts or completes, and when it leaves.
+// Joining clears the consumer errors recorded.
+func (t *ConsumerTracker) Joined(joined bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.joined = joined
+	if joined {
+		t.consumerErrs, t.consumerErr = 0, nil
+	}
+}
+
+// ConsumerError records an error reported by the group consumer. A message
+// received clears the errors recorded.
+func (t *ConsumerTracker) ConsumerError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.consumerErrs++
+	t.consumerErr = err
+}
+
+// Processing records that the consume loop has received a message and
+// started processing it. Several messages may be processed at once; the
+// returned function records that this one has finished.
+func (t *ConsumerTracker) Processing() (done func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastMessage = t.now()
+	t.consumerErrs, t.consumerErr = 0, nil
+	id := t.nextID
+	t.nextID++
+	t.inFlight[id] = t.lastMessage
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		delete(t.inFlight, id)
+	}
+}
+
+// ProducerResult records the outcome of the most recent publish.
+func (t *ConsumerTracker) ProducerResult(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.producerErr = err
+}
+
+// ConsumerCheck reports whether the consumer is a member of its group and is
+// not failing with consumer errors, along with the age of the last message
+// received.
+func (t *ConsumerTracker) ConsumerCheck() Check {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data := map[string]interface{}{"joined": t.joined}
+	if !t.lastMessage.IsZero() {
+		data["last_message_age_seconds"] = int64(t.now().Sub(t.lastMessage).Seconds())
+	}
+
+	if !t.joined {
+		return Check{Status: StatusFail, Detail: "consumer has not joined its group", Data: data}
+	}
+	if t.consumerErrs >= consumerErrorLimit {
+		data["consumer_errors"] = t.consumerErrs
+		return Check{Status: StatusFail, Detail: fmt.Sprintf("%d consumer errors in a row: %s", t.consumerErrs, t.consumerErr), Data: data}
+	}
+	return Check{Status: StatusOK, Data: data}
+}
+
+// LoopCheck fails if the consume loop has been processing a single message
+// for longer than the stall timeout.
+func (t *ConsumerTracker) LoopCheck() Check {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.inFlight) == 0 {
+		return Check{Status: StatusOK}
+	}
+
+	var oldest time.Time
+	for _, started := range t.inFlight {
+		if oldest.IsZero() || started.Before(oldest) {
+			oldest = started
+		}
+	}
+
+	processing := t.now().Sub(oldest)
+	data := map[string]interface{}{"processing_seconds": int64(processing.Seconds()), "in_flight": len(t.inFlight)}
+	if processing > t.stallTimeout {
+		return Check{Status: StatusFail, Detail: fmt.Sprintf("consume loop stalled for over %s", t.stallTimeout), Data: data}
+	}
+	return Check{Status: StatusOK, Data: data}
+}
+
+// ProducerCheck fails if the most recent publish failed.
+func (t *ConsumerTracker) ProducerCheck() Check {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.producerErr != nil {
+		return Check{Status: StatusFail, Detail: t.producerErr.Error()}
+	}
+	return Check{Status: StatusOK}
+}
+
+// Register adds the tracker's checks to the registry, prefixed by role.
+func (t *ConsumerTracker) Register(r *Registry, role string) {
+	r.Register(role+".consumer", t.ConsumerCheck)
+	r.Register(role+".consume_loop", t.LoopCheck)
+	r.Register(role+".producer", t.ProducerCheck)
+}
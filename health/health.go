@@ -0,0 +1,89 @@
+// Package health tracks the state of the service's dependencies and reports
+// it for the liveness and readiness endpoints.
+package health
+
+import (
+	"sort"
+	"sync"
+)
+
+// Check statuses.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is the state of a single dependency.
+type Check struct {
+	Status string                 `json:"status"`
+	Detail string                 `json:"detail,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// OK reports whether the check passed.
+func (c Check) OK() bool {
+	return c.Status == StatusOK
+}
+
+// CheckFunc returns the current state of a dependency.
+type CheckFunc func() Check
+
+// Report is the combined state of every registered dependency. Its status
+// is StatusFail if any check failed.
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// OK reports whether every check passed.
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Registry holds the checks making up the service's readiness.
+type Registry struct {
+	mu     sync.RWMutex
+	checks map[string]CheckFunc
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{checks: make(map[string]CheckFunc)}
+}
+
+// Register adds a check, replacing any existing check with the same name.
+func (r *Registry) Register(name string, check CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks[name] = check
+}
+
+// Names returns the names of the registered checks in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Report runs every check.
+func (r *Registry) Report() Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Check, len(r.checks))}
+	for name, check := range r.checks {
+		result := check()
+		if !result.OK() {
+			report.Status = StatusFail
+		}
+		report.Checks[name] = result
+	}
+	return report
+}
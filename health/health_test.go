@@ -0,0 +1,125 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitRegistryReport(t *testing.T) {
+	r := NewRegistry()
+
+	report := r.Report()
+	assert.True(t, report.OK())
+
+	r.Register("b", func() Check { return Check{Status: StatusOK} })
+	r.Register("a", func() Check { return Check{Status: StatusOK} })
+	assert.True(t, r.Report().OK())
+	assert.Equal(t, []string{"a", "b"}, r.Names())
+
+	r.Register("a", func() Check { return Check{Status: StatusFail, Detail: "down"} })
+	report = r.Report()
+	assert.False(t, report.OK())
+	assert.Equal(t, "down", report.Checks["a"].Detail)
+	assert.True(t, report.Checks["b"].OK())
+}
+
+func TestUnitConsumerTracker(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tracker := NewConsumerTracker(time.Minute)
+	tracker.now = func() time.Time { return now }
+
+	assert.False(t, tracker.ConsumerCheck().OK())
+	tracker.Joined(true)
+	assert.True(t, tracker.ConsumerCheck().OK())
+	assert.NotContains(t, tracker.ConsumerCheck().Data, "last_message_age_seconds")
+
+	first := tracker.Processing()
+	now = now.Add(30 * time.Second)
+	second := tracker.Processing()
+	assert.True(t, tracker.LoopCheck().OK())
+	assert.Equal(t, 2, tracker.LoopCheck().Data["in_flight"])
+	assert.Equal(t, int64(0), tracker.ConsumerCheck().Data["last_message_age_seconds"])
+
+	now = now.Add(31 * time.Second)
+	assert.False(t, tracker.LoopCheck().OK())
+
+	first()
+	assert.True(t, tracker.LoopCheck().OK())
+	assert.Equal(t, int64(31), tracker.LoopCheck().Data["processing_seconds"])
+
+	second()
+	assert.True(t, tracker.LoopCheck().OK())
+	assert.Empty(t, tracker.LoopCheck().Data)
+
+	tracker.ProducerResult(errors.New("broker down"))
+	assert.False(t, tracker.ProducerCheck().OK())
+	tracker.ProducerResult(nil)
+	assert.True(t, tracker.ProducerCheck().OK())
+
+	r := NewRegistry()
+	tracker.Register(r, "main")
+	assert.Equal(t, []string{"main.consume_loop", "main.consumer", "main.producer"}, r.Names())
+}
+
+func TestUnitConsumerTrackerErrors(t *testing.T) {
+	tracker := NewConsumerTracker(time.Minute)
+	tracker.Joined(true)
+
+	for i := 1; i < consumerErrorLimit; i++ {
+		tracker.ConsumerError(errors.New("offset out of range"))
+	}
+	assert.True(t, tracker.ConsumerCheck().OK())
+
+	tracker.ConsumerError(errors.New("broker down"))
+	check := tracker.ConsumerCheck()
+	assert.False(t, check.OK())
+	assert.Contains(t, check.Detail, "broker down")
+
+	tracker.Processing()()
+	assert.True(t, tracker.ConsumerCheck().OK(), "a message clears the errors")
+
+	for i := 0; i < consumerErrorLimit; i++ {
+		tracker.ConsumerError(errors.New("broker down"))
+	}
+	tracker.Joined(false)
+	assert.False(t, tracker.ConsumerCheck().OK())
+	tracker.Joined(true)
+	assert.True(t, tracker.ConsumerCheck().OK(), "rejoining clears the errors")
+}
+
+func TestUnitHTTPCheck(t *testing.T) {
+	status := http.StatusOK
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	check := HTTPCheck(server.Client(), server.URL, time.Hour)
+	assert.True(t, check().OK())
+
+	// The result is cached.
+	status = http.StatusServiceUnavailable
+	assert.True(t, check().OK())
+	assert.Equal(t, 1, calls)
+
+	assert.False(t, HTTPCheck(server.Client(), server.URL, 0)().OK())
+
+	status = http.StatusNotFound
+	assert.True(t, HTTPCheck(server.Client(), server.URL, 0)().OK())
+}
+
+func TestUnitHTTPCheck_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	check := HTTPCheck(server.Client(), server.URL, 0)()
+	assert.False(t, check.OK())
+	assert.NotEmpty(t, check.Detail)
+}
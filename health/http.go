@@ -0,0 +1,48 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPCheck returns a check which passes if url responds with a status below
+// 500. The result is cached for cacheFor so that frequent readiness probes do
+// not load the dependency.
+func HTTPCheck(client *http.Client, url string, cacheFor time.Duration) CheckFunc {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		result  Check
+	)
+
+	return func() Check {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !checked.IsZero() && time.Since(checked) < cacheFor {
+			return result
+		}
+
+		result = probe(client, url)
+		checked = time.Now()
+		return result
+	}
+}
+
+func probe(client *http.Client, url string) Check {
+	data := map[string]interface{}{"url": url}
+
+	res, err := client.Get(url)
+	if err != nil {
+		return Check{Status: StatusFail, Detail: err.Error(), Data: data}
+	}
+	res.Body.Close()
+
+	data["status"] = res.StatusCode
+	if res.StatusCode >= http.StatusInternalServerError {
+		return Check{Status: StatusFail, Detail: fmt.Sprintf("unexpected status [%d]", res.StatusCode), Data: data}
+	}
+	return Check{Status: StatusOK, Data: data}
+}
@@ -0,0 +1,214 @@
+// Package hold parks refund requests which need an operator's approval on the
+// held topic. Every change to a held request is published as a record keyed
+// by its ID, so the requests awaiting a decision can be rebuilt by reading the
+// topic.
+package hold
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/companieshouse/refund-request-consumer/topic"
+)
+
+// State is the state of a held request.
+type State string
+
+// States of a held request.
+const (
+	StateHeld     State = "held"
+	StateApproved State = "approved"
+	StateRejected State = "rejected"
+)
+
+// Record is a change to a held refund request. Reason is why the request is
+// held, and RefundReason the reason given for the refund.
+type Record struct {
+	ID              string    `json:"id"`
+	State           State     `json:"state"`
+	PaymentID       string    `json:"payment_id"`
+	RefundReference string    `json:"refund_reference"`
+	RefundAmount    string    `json:"refund_amount"`
+	AmountPence     int64     `json:"amount_pence"`
+	Currency        string    `json:"currency,omitempty"`
+	RefundReason    string    `json:"refund_reason,omitempty"`
+	RequestedBy     string    `json:"requested_by,omitempty"`
+	Attempt         int32     `json:"attempt"`
+	Reason          string    `json:"reason"`
+	Topic           string    `json:"topic,omitempty"`
+	Partition       int32     `json:"partition"`
+	Offset          int64     `json:"offset"`
+	HeldAt          time.Time `json:"held_at"`
+	DecidedAt       time.Time `json:"decided_at,omitzero"`
+	Note            string    `json:"note,omitempty"`
+}
+
+// New returns the record of a refund request held for the reason when
+// consumed from msg.
+func New(rr data.RefundRequest, amount int64, reason string, msg *sarama.ConsumerMessage, at time.Time) Record {
+	return Record{
+		ID:              ID(rr.PaymentID, amount, rr.RefundReference),
+		State:           StateHeld,
+		PaymentID:       rr.PaymentID,
+		RefundReference: rr.RefundReference,
+		RefundAmount:    rr.RefundAmount,
+		AmountPence:     amount,
+		Currency:        rr.Currency,
+		RefundReason:    rr.Reason,
+		RequestedBy:     rr.RequestedBy,
+		Attempt:         rr.Attempt,
+		Reason:          reason,
+		Topic:           msg.Topic,
+		Partition:       msg.Partition,
+		Offset:          msg.Offset,
+		HeldAt:          at.UTC(),
+	}
+}
+
+// ID returns the ID of a held refund, which is its idempotency key, so that
+// the same refund consumed again is held only once.
+func ID(paymentID string, amount int64, refundReference string) string {
+	return payment.IdempotencyKey(paymentID, data.RefundPostRequest{Amount: int(amount), RefundReference: refundReference})
+}
+
+// Request returns the held refund request.
+func (r Record) Request() data.RefundRequest {
+	return data.RefundRequest{
+		Attempt:         r.Attempt,
+		PaymentID:       r.PaymentID,
+		RefundAmount:    r.RefundAmount,
+		RefundReference: r.RefundReference,
+		Currency:        r.Currency,
+		Reason:          r.RefundReason,
+		RequestedBy:     r.RequestedBy,
+	}
+}
+
+// Decide returns the record of a decision about the held request.
+func (r Record) Decide(state State, note string, at time.Time) Record {
+	r.State, r.Note, r.DecidedAt = state, note, at.UTC()
+	return r
+}
+
+// Sender sends a message to kafka. It is satisfied by producer.Producer.
+type Sender interface {
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// Publisher publishes records to the held topic.
+type Publisher struct {
+	Topic  string
+	Sender Sender
+}
+
+// NewPublisher returns a publisher of records to the topic.
+func NewPublisher(topic string, sender Sender) *Publisher {
+	return &Publisher{Topic: topic, Sender: sender}
+}
+
+// Publish sends a record to the held topic, keyed by its ID so that every
+// record of a request is on the same partition.
+func (p *Publisher) Publish(r Record) error {
+	value, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error marshalling held refund request: %w", err)
+	}
+	_, _, err = p.Sender.SendMessage(&sarama.ProducerMessage{
+		Topic: p.Topic,
+		Key:   sarama.StringEncoder(r.ID),
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing to held topic [%s]: %w", p.Topic, err)
+	}
+	return nil
+}
+
+// Ledger holds the refund requests awaiting a decision.
+type Ledger struct {
+	mu      sync.RWMutex
+	pending map[string]Record
+	decided map[string]State
+}
+
+// NewLedger returns an empty ledger.
+func NewLedger() *Ledger {
+	return &Ledger{pending: map[string]Record{}, decided: map[string]State{}}
+}
+
+// Apply updates the ledger with a record. A held request is pending until a
+// decision about it is applied. A request held again once decided, such as a
+// message consumed again, stays decided.
+func (l *Ledger) Apply(r Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r.State != StateHeld {
+		delete(l.pending, r.ID)
+		l.decided[r.ID] = r.State
+		return
+	}
+	if _, ok := l.decided[r.ID]; ok {
+		return
+	}
+	if _, ok := l.pending[r.ID]; !ok {
+		l.pending[r.ID] = r
+	}
+}
+
+// Get returns the pending request with the given ID.
+func (l *Ledger) Get(id string) (Record, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r, ok := l.pending[id]
+	return r, ok
+}
+
+// Pending returns the pending requests, oldest first.
+func (l *Ledger) Pending() []Record {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	records := make([]Record, 0, len(l.pending))
+	for _, r := range l.pending {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].HeldAt.Equal(records[j].HeldAt) {
+			return records[i].HeldAt.Before(records[j].HeldAt)
+		}
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+// Load applies every record on the topic, up to its tail when Load is called,
+// returning the offsets to follow the topic from. Messages which are not
+// records are skipped.
+func Load(ctx context.Context, client topic.OffsetClient, consumer sarama.Consumer, name string, apply func(Record)) (topic.Offsets, error) {
+	return topic.Load(ctx, client, consumer, name, sarama.OffsetOldest, records(apply))
+}
+
+// Follow applies every record published to the topic from the offsets, such
+// as by another consumer, until the context is cancelled.
+func Follow(ctx context.Context, consumer sarama.Consumer, name string, offsets topic.Offsets, apply func(Record), onError func(error)) error {
+	return topic.Follow(ctx, consumer, name, offsets, records(apply), onError)
+}
+
+// records returns a func applying the record in each message.
+func records(apply func(Record)) func(*sarama.ConsumerMessage) {
+	return func(msg *sarama.ConsumerMessage) {
+		var r Record
+		if err := json.Unmarshal(msg.Value, &r); err == nil && r.ID != "" {
+			apply(r)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package hold
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/topic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	heldAt  = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	request = data.RefundRequest{Attempt: 1, PaymentID: "P1", RefundAmount: "5,000.00", RefundReference: "R1", Currency: "GBP", Reason: "duplicate payment", RequestedBy: "finance"}
+	message = &sarama.ConsumerMessage{Topic: "refund-request", Partition: 2, Offset: 7}
+)
+
+// fakeKafka holds the messages on each partition of one topic, starting at
+// offset zero.
+type fakeKafka struct {
+	partitions [][]*sarama.ConsumerMessage
+}
+
+func (k *fakeKafka) add(partition int, value []byte) {
+	for len(k.partitions) <= partition {
+		k.partitions = append(k.partitions, nil)
+	}
+	msgs := k.partitions[partition]
+	k.partitions[partition] = append(msgs, &sarama.ConsumerMessage{Partition: int32(partition), Offset: int64(len(msgs)), Value: value})
+}
+
+func (k *fakeKafka) GetOffset(topic string, partition int32, when int64) (int64, error) {
+	if when == sarama.OffsetOldest {
+		return 0, nil
+	}
+	return int64(len(k.partitions[partition])), nil
+}
+
+func (k *fakeKafka) Topics() ([]string, error) { return []string{"refund-request-held"}, nil }
+
+func (k *fakeKafka) Partitions(topic string) ([]int32, error) {
+	var partitions []int32
+	for p := range k.partitions {
+		partitions = append(partitions, int32(p))
+	}
+	return partitions, nil
+}
+
+func (k *fakeKafka) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	pc := &fakePartitionConsumer{messages: make(chan *sarama.ConsumerMessage, len(k.partitions[partition]))}
+	for _, msg := range k.partitions[partition][offset:] {
+		pc.messages <- msg
+	}
+	return pc, nil
+}
+
+func (k *fakeKafka) HighWaterMarks() map[string]map[int32]int64 { return nil }
+
+func (k *fakeKafka) Close() error { return nil }
+
+type fakePartitionConsumer struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (pc *fakePartitionConsumer) AsyncClose()                              {}
+func (pc *fakePartitionConsumer) Close() error                             { return nil }
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+func (pc *fakePartitionConsumer) Errors() <-chan *sarama.ConsumerError     { return nil }
+func (pc *fakePartitionConsumer) HighWaterMarkOffset() int64               { return 0 }
+
+type mockSender struct {
+	sent []*sarama.ProducerMessage
+	err  error
+}
+
+func (m *mockSender) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	m.sent = append(m.sent, msg)
+	return 0, 0, m.err
+}
+
+func encode(t *testing.T, r Record) []byte {
+	value, err := json.Marshal(r)
+	require.NoError(t, err)
+	return value
+}
+
+func TestUnitNew(t *testing.T) {
+	r := New(request, 500000, "over threshold", message, heldAt)
+
+	assert.Equal(t, ID("P1", 500000, "R1"), r.ID)
+	assert.Len(t, r.ID, 64)
+	assert.Equal(t, StateHeld, r.State)
+	assert.Equal(t, "refund-request", r.Topic)
+	assert.Equal(t, int64(7), r.Offset)
+	assert.Equal(t, request, r.Request(), "the request is rebuilt with its currency and reason")
+
+	var decoded Record
+	require.NoError(t, json.Unmarshal(encode(t, r), &decoded))
+	assert.Equal(t, request, decoded.Request(), "the currency and reason are published")
+
+	decided := r.Decide(StateRejected, "duplicate", heldAt.Add(time.Hour))
+	assert.Equal(t, StateRejected, decided.State)
+	assert.Equal(t, "duplicate", decided.Note)
+	assert.Equal(t, StateHeld, r.State, "the held record is unchanged")
+}
+
+func TestUnitPublish(t *testing.T) {
+	sender := &mockSender{}
+	p := NewPublisher("refund-request-held", sender)
+	r := New(request, 500000, "over threshold", message, heldAt)
+
+	require.NoError(t, p.Publish(r))
+	require.Len(t, sender.sent, 1)
+	assert.Equal(t, "refund-request-held", sender.sent[0].Topic)
+	assert.Equal(t, sarama.StringEncoder(r.ID), sender.sent[0].Key)
+
+	value, _ := sender.sent[0].Value.Encode()
+	var published Record
+	require.NoError(t, json.Unmarshal(value, &published))
+	assert.Equal(t, r, published)
+
+	sender.err = errors.New("broker down")
+	assert.ErrorContains(t, p.Publish(r), "broker down")
+}
+
+func TestUnitLedger(t *testing.T) {
+	l := NewLedger()
+	first := New(request, 500000, "over threshold", message, heldAt)
+	second := New(data.RefundRequest{PaymentID: "P2", RefundAmount: "1.00", RefundReference: "R2"}, 100, "hourly total", message, heldAt.Add(-time.Minute))
+
+	l.Apply(first)
+	l.Apply(second)
+	l.Apply(first.Decide(StateHeld, "", heldAt.Add(time.Hour)))
+	assert.Equal(t, []Record{second, first}, l.Pending(), "oldest first, each held once")
+
+	l.Apply(first.Decide(StateApproved, "", heldAt))
+	_, ok := l.Get(first.ID)
+	assert.False(t, ok)
+
+	l.Apply(first)
+	assert.Equal(t, []Record{second}, l.Pending(), "a decided request held again stays decided")
+
+	got, ok := l.Get(second.ID)
+	assert.True(t, ok)
+	assert.Equal(t, second, got)
+}
+
+func TestUnitLoad(t *testing.T) {
+	first := New(request, 500000, "over threshold", message, heldAt)
+	second := New(data.RefundRequest{PaymentID: "P2", RefundAmount: "1.00", RefundReference: "R2"}, 100, "hourly total", message, heldAt)
+
+	k := &fakeKafka{}
+	k.add(0, encode(t, first))
+	k.add(0, []byte("not a record"))
+	k.add(1, encode(t, second))
+	k.add(0, encode(t, first.Decide(StateRejected, "", heldAt)))
+
+	l := NewLedger()
+	offsets, err := Load(context.Background(), k, k, "refund-request-held", l.Apply)
+	require.NoError(t, err)
+	assert.Equal(t, []Record{second}, l.Pending())
+	assert.Equal(t, topic.Offsets{0: 3, 1: 1}, offsets)
+}
+
+func TestUnitFollow(t *testing.T) {
+	held := New(request, 500000, "over threshold", message, heldAt)
+
+	k := &fakeKafka{}
+	k.add(0, encode(t, held))
+	k.add(0, encode(t, held.Decide(StateApproved, "", heldAt)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	applied := make(chan Record, 2)
+	require.NoError(t, Follow(ctx, k, "refund-request-held", topic.Offsets{0: 1}, func(r Record) { applied <- r }, func(error) {}))
+
+	select {
+	case r := <-applied:
+		assert.Equal(t, StateApproved, r.State, "records before the offsets are not applied")
+	case <-time.After(time.Second):
+		t.Fatal("record published after the offsets not applied")
+	}
+}
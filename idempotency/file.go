@@ -0,0 +1,203 @@
+package idempotency
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+)
+
+// DefaultFileStoreTTL is how long a file store keeps a record after it was
+// last updated when no TTL is given.
+const DefaultFileStoreTTL = 7 * 24 * time.Hour
+
+// compactInterval is how often a file store drops its expired records and
+// compacts its file.
+const compactInterval = time.Hour
+
+// FileStore is a Store embedded in a local file, so records survive a
+// restart. Records are appended to the file as JSON lines. Records not
+// updated within the store's TTL expire, and the file is compacted to one
+// line per unexpired key when it is opened and then every compactInterval.
+type FileStore struct {
+	mu        sync.Mutex
+	path      string
+	ttl       time.Duration
+	file      *os.File
+	records   map[string]Record
+	compacted time.Time
+	now       func() time.Time
+}
+
+// NewFileStore opens, or creates, the store at path, keeping records for ttl
+// after they were last updated.
+func NewFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("idempotency store path must be set")
+	}
+	if ttl <= 0 {
+		ttl = DefaultFileStoreTTL
+	}
+
+	records, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		path:    path,
+		ttl:     ttl,
+		records: records,
+		now:     time.Now,
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get implements Store.Get. An expired record is not returned.
+func (s *FileStore) Get(key string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok || s.expired(record) {
+		return Record{}, false, nil
+	}
+	return record, true, nil
+}
+
+// Put implements Store.Put. The record is synced to disk before Put returns.
+func (s *FileStore) Put(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling idempotency record: %w", err)
+	}
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing idempotency record: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing idempotency store: %w", err)
+	}
+
+	s.records[record.Key] = record
+
+	// The record is already synced, so a failed compaction leaves the store
+	// intact and is tried again on the next Put.
+	if s.now().Sub(s.compacted) >= compactInterval {
+		if err := s.compact(); err != nil {
+			log.Error(err, log.Data{"path": s.path})
+		}
+	}
+	return nil
+}
+
+// Close implements Store.Close.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// expired reports whether record was last updated longer than the TTL ago.
+func (s *FileStore) expired(record Record) bool {
+	return s.now().Sub(record.UpdatedAt) > s.ttl
+}
+
+// compact drops the expired records and rewrites the file with the rest.
+func (s *FileStore) compact() error {
+	for key, record := range s.records {
+		if s.expired(record) {
+			delete(s.records, key)
+		}
+	}
+
+	file, err := compact(s.path, s.records)
+	if err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	s.compacted = s.now()
+	return nil
+}
+
+// load reads every record in the file at path, keeping the last record
+// written for each key. A missing file is treated as an empty store.
+func load(path string) (map[string]Record, error) {
+	records := make(map[string]Record)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening idempotency store: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn final line from a crash mid-write is skipped; the
+			// record it held was never acknowledged by Put.
+			continue
+		}
+		records[record.Key] = record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading idempotency store: %w", err)
+	}
+
+	return records, nil
+}
+
+// compact atomically replaces the file at path with one line per record. It
+// returns the new file, open for appending further records.
+func compact(path string, records map[string]Record) (*os.File, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("error compacting idempotency store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			tmp.Close()
+			return nil, fmt.Errorf("error compacting idempotency store: %w", err)
+		}
+		w.Write(append(line, '\n'))
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("error compacting idempotency store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("error compacting idempotency store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("error compacting idempotency store: %w", err)
+	}
+
+	return tmp, nil
+}
@@ -0,0 +1,63 @@
+// Package idempotency records the outcome of refund submissions so that a
+// redelivered refund request is not submitted to the payments API twice.
+package idempotency
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is the submission state of a refund.
+type State string
+
+// Submission states. A refund is Submitted immediately before it is posted to
+// the payments API and moves to Succeeded or Failed once the outcome is known.
+// A refund left in Submitted has an unknown outcome, for example because the
+// service stopped while the request was in flight.
+const (
+	Submitted State = "submitted"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+)
+
+// Store kinds supported by NewStore.
+const (
+	KindMemory = "memory"
+	KindFile   = "file"
+)
+
+// Record is the stored state of a single refund.
+type Record struct {
+	Key       string    `json:"key"`
+	State     State     `json:"state"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store persists refund records.
+type Store interface {
+	// Get returns the record for key, and false if there is none.
+	Get(key string) (Record, bool, error)
+	// Put creates or replaces the record with the same key.
+	Put(record Record) error
+	Close() error
+}
+
+// Key returns the idempotency key of a refund. A refund is identified by the
+// payment it refunds and the reference supplied by the requester.
+func Key(paymentID, refundReference string) string {
+	return fmt.Sprintf("%d:%s/%s", len(paymentID), paymentID, refundReference)
+}
+
+// NewStore returns a store of the given kind. Memory stores hold up to size
+// records; file stores persist records to the file at path for ttl after they
+// were last updated.
+func NewStore(kind, path string, size int, ttl time.Duration) (Store, error) {
+	switch kind {
+	case "", KindMemory:
+		return NewMemoryStore(size), nil
+	case KindFile:
+		return NewFileStore(path, ttl)
+	default:
+		return nil, fmt.Errorf("unknown idempotency store kind [%s]", kind)
+	}
+}
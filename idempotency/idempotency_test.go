@@ -0,0 +1,164 @@
+package idempotency
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitKey(t *testing.T) {
+	assert.Equal(t, Key("pay1", "ref1"), Key("pay1", "ref1"))
+	assert.NotEqual(t, Key("pay1", "ref1"), Key("pay1", "ref2"))
+	assert.NotEqual(t, Key("pay1", "ref1"), Key("pay2", "ref1"))
+	// The payment ID length prefix stops separators in either field colliding.
+	assert.NotEqual(t, Key("a/b", "c"), Key("a", "b/c"))
+}
+
+func TestUnitNewStore(t *testing.T) {
+	store, err := NewStore("", "", 0, 0)
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryStore{}, store)
+
+	store, err = NewStore(KindFile, filepath.Join(t.TempDir(), "refunds.db"), 0, 0)
+	assert.NoError(t, err)
+	assert.IsType(t, &FileStore{}, store)
+	assert.NoError(t, store.Close())
+
+	_, err = NewStore("bolt", "", 0, 0)
+	assert.Error(t, err)
+}
+
+func TestUnitMemoryStore(t *testing.T) {
+	store := NewMemoryStore(2)
+
+	_, found, err := store.Get("a")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, store.Put(Record{Key: "a", State: Submitted}))
+	assert.NoError(t, store.Put(Record{Key: "a", State: Succeeded}))
+
+	record, found, err := store.Get("a")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Succeeded, record.State)
+
+	// "a" was used most recently, so adding a third record evicts "b".
+	assert.NoError(t, store.Put(Record{Key: "b", State: Failed}))
+	_, _, _ = store.Get("a")
+	assert.NoError(t, store.Put(Record{Key: "c", State: Submitted}))
+
+	_, found, _ = store.Get("b")
+	assert.False(t, found)
+	_, found, _ = store.Get("a")
+	assert.True(t, found)
+	_, found, _ = store.Get("c")
+	assert.True(t, found)
+}
+
+func TestUnitFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refunds.db")
+	updated := time.Now().UTC().Truncate(time.Second)
+
+	store, err := NewFileStore(path, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Put(Record{Key: "a", State: Submitted, UpdatedAt: updated}))
+	assert.NoError(t, store.Put(Record{Key: "a", State: Succeeded, UpdatedAt: updated}))
+	assert.NoError(t, store.Put(Record{Key: "b", State: Failed, UpdatedAt: updated}))
+	assert.NoError(t, store.Close())
+
+	// Simulate a crash part way through writing a record.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"key":"c","sta`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	store, err = NewFileStore(path, 0)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	record, found, err := store.Get("a")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Record{Key: "a", State: Succeeded, UpdatedAt: updated}, record)
+
+	record, found, _ = store.Get("b")
+	assert.True(t, found)
+	assert.Equal(t, Failed, record.State)
+
+	_, found, _ = store.Get("c")
+	assert.False(t, found)
+
+	// Reopening compacts the file to one line per key.
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, countLines(contents))
+}
+
+func TestUnitFileStore_Expiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refunds.db")
+	now := time.Now().UTC().Add(-25 * time.Hour)
+
+	store, err := NewFileStore(path, 24*time.Hour)
+	assert.NoError(t, err)
+	store.now = func() time.Time { return now }
+	store.compacted = now
+
+	assert.NoError(t, store.Put(Record{Key: "a", State: Succeeded, UpdatedAt: now}))
+	assert.NoError(t, store.Put(Record{Key: "b", State: Submitted, UpdatedAt: now}))
+	assert.NoError(t, store.Put(Record{Key: "b", State: Succeeded, UpdatedAt: now.Add(12 * time.Hour)}))
+
+	// Once "a" is older than the TTL it is no longer found, but stays in the
+	// file until the next compaction.
+	now = now.Add(25 * time.Hour)
+	_, found, _ := store.Get("a")
+	assert.False(t, found)
+	_, found, _ = store.Get("b")
+	assert.True(t, found)
+
+	// A Put more than compactInterval after the last compaction drops the
+	// expired records and rewrites the file with one line per key.
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, countLines(contents))
+
+	assert.NoError(t, store.Put(Record{Key: "c", State: Submitted, UpdatedAt: now}))
+	assert.NotContains(t, store.records, "a")
+
+	contents, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, countLines(contents))
+
+	// Records are still appended to the compacted file.
+	assert.NoError(t, store.Put(Record{Key: "c", State: Succeeded, UpdatedAt: now}))
+	assert.NoError(t, store.Close())
+
+	store, err = NewFileStore(path, 24*time.Hour)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	record, found, _ := store.Get("c")
+	assert.True(t, found)
+	assert.Equal(t, Succeeded, record.State)
+	_, found, _ = store.Get("a")
+	assert.False(t, found)
+}
+
+func TestUnitFileStore_NoPath(t *testing.T) {
+	_, err := NewFileStore("", 0)
+	assert.Error(t, err)
+}
+
+func countLines(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c == '\n' {
+			n++
+		}
+	}
+	return n
+}
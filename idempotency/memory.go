@@ -0,0 +1,73 @@
+package idempotency
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultMemoryStoreSize is the number of records held by a memory store when
+// no size is given.
+const DefaultMemoryStoreSize = 10000
+
+// MemoryStore is an in-memory Store that evicts the least recently used
+// record once it holds size records.
+type MemoryStore struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	records map[string]*list.Element
+}
+
+// NewMemoryStore returns a MemoryStore holding up to size records.
+func NewMemoryStore(size int) *MemoryStore {
+	if size <= 0 {
+		size = DefaultMemoryStoreSize
+	}
+
+	return &MemoryStore{
+		size:    size,
+		order:   list.New(),
+		records: make(map[string]*list.Element),
+	}
+}
+
+// Get implements Store.Get.
+func (s *MemoryStore) Get(key string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.records[key]
+	if !ok {
+		return Record{}, false, nil
+	}
+
+	s.order.MoveToFront(e)
+	return e.Value.(Record), true, nil
+}
+
+// Put implements Store.Put.
+func (s *MemoryStore) Put(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.records[record.Key]; ok {
+		e.Value = record
+		s.order.MoveToFront(e)
+		return nil
+	}
+
+	s.records[record.Key] = s.order.PushFront(record)
+
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.records, oldest.Value.(Record).Key)
+	}
+
+	return nil
+}
+
+// Close implements Store.Close.
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,180 @@
+// Package ladder routes refund requests that failed with a retryable error
+// through a ladder of retry topics, each consumed after a longer delay, and on
+// to the error topic once the ladder is exhausted. Invalid refund requests go
+// straight to the error topic.
+package ladder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+)
+
+// Tier is a retry topic whose messages are processed Delay after they were
+// published.
+type Tier struct {
+	Name  string
+	Topic string
+	Delay time.Duration
+}
+
+// ParseTiers parses tier specs, such as "1m", "10m" and "1h", into tiers of
+// the base topic. Each spec is a duration, which also names the tier and its
+// topic, for example refund-request-retry-1m.
+func ParseTiers(baseTopic string, specs []string) ([]Tier, error) {
+	var tiers []Tier
+	seen := make(map[string]bool)
+	for _, value := range specs {
+		for _, spec := range strings.Split(value, ",") {
+			spec = strings.TrimSpace(spec)
+			if spec == "" {
+				continue
+			}
+
+			delay, err := time.ParseDuration(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retry tier [%s]: %w", spec, err)
+			}
+			if delay <= 0 {
+				return nil, fmt.Errorf("invalid retry tier [%s]: delay must be positive", spec)
+			}
+			if seen[spec] {
+				return nil, fmt.Errorf("duplicate retry tier [%s]", spec)
+			}
+			seen[spec] = true
+
+			tiers = append(tiers, Tier{Name: spec, Topic: baseTopic + "-retry-" + spec, Delay: delay})
+		}
+	}
+	return tiers, nil
+}
+
+// Ladder is the sequence of retry tiers a failed refund request is passed
+// through, and the topic it is routed to after the last.
+type Ladder struct {
+	Tiers      []Tier
+	ErrorTopic string
+}
+
+// Next returns the topic a refund request goes to when the given attempt at
+// it fails, and whether that topic is a retry tier. Attempts are counted from
+// one, the attempt made when it was first consumed, so a failed attempt n goes
+// to the nth tier.
+func (l Ladder) Next(attempt int32) (topic string, retry bool) {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if int(attempt) <= len(l.Tiers) {
+		return l.Tiers[attempt-1].Topic, true
+	}
+	return l.ErrorTopic, false
+}
+
+// Sender sends a message to kafka. It is satisfied by producer.Producer.
+type Sender interface {
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// Encoder encodes a message with an avro schema. It is satisfied by
+// avro.Schema.
+type Encoder interface {
+	Marshal(v interface{}) ([]byte, error)
+}
+
+// Publisher republishes failed refund requests to the next topic on a ladder.
+type Publisher struct {
+	Ladder Ladder
+	Sender Sender
+	Schema Encoder
+}
+
+// NewPublisher returns a Publisher which encodes refund requests with schema.
+func NewPublisher(ladder Ladder, sender Sender, schema Encoder) *Publisher {
+	return &Publisher{
+		Ladder: ladder,
+		Sender: sender,
+		Schema: schema,
+	}
+}
+
+// Result is where a refund request was republished.
+type Result struct {
+	Topic   string
+	Retry   bool
+	Attempt int32
+}
+
+// ErrNoErrorTopic is returned when a refund request has exhausted the ladder
+// and there is no error topic to route it to.
+var ErrNoErrorTopic = errors.New("retry ladder exhausted and no error topic configured")
+
+// Publish sends a refund request which failed with a retryable error to the
+// next topic on the ladder, with its attempt incremented. The request is keyed
+// by payment ID so that retries of a payment stay in order.
+func (p *Publisher) Publish(rr data.RefundRequest) (Result, error) {
+	attempt := rr.Attempt
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	topic, retry := p.Ladder.Next(attempt)
+	if topic == "" {
+		return Result{}, ErrNoErrorTopic
+	}
+
+	rr.Attempt = attempt + 1
+	if err := p.send(topic, rr, nil); err != nil {
+		return Result{}, err
+	}
+	return Result{Topic: topic, Retry: retry, Attempt: rr.Attempt}, nil
+}
+
+// HeaderInvalidFields is the header of a refund request sent to the error
+// topic because it is invalid. It holds the reason each field is invalid, as
+// a JSON object keyed by field.
+const HeaderInvalidFields = "invalid-fields"
+
+// PublishInvalid sends an invalid refund request straight to the error topic,
+// with its attempt unchanged and the reason each field is invalid in its
+// HeaderInvalidFields header.
+func (p *Publisher) PublishInvalid(rr data.RefundRequest, reasons map[string]string) (Result, error) {
+	topic := p.Ladder.ErrorTopic
+	if topic == "" {
+		return Result{}, ErrNoErrorTopic
+	}
+
+	value, err := json.Marshal(reasons)
+	if err != nil {
+		return Result{}, fmt.Errorf("error encoding invalid fields: %w", err)
+	}
+	headers := []sarama.RecordHeader{{Key: []byte(HeaderInvalidFields), Value: value}}
+	if err := p.send(topic, rr, headers); err != nil {
+		return Result{}, err
+	}
+	return Result{Topic: topic, Attempt: rr.Attempt}, nil
+}
+
+// send publishes a refund request to the topic, keyed by payment ID so that
+// the requests of a payment stay in order.
+func (p *Publisher) send(topic string, rr data.RefundRequest, headers []sarama.RecordHeader) error {
+	value, err := p.Schema.Marshal(rr)
+	if err != nil {
+		return fmt.Errorf("error encoding refund request for topic [%s]: %w", topic, err)
+	}
+
+	_, _, err = p.Sender.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.StringEncoder(rr.PaymentID),
+		Value:   sarama.ByteEncoder(value),
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing refund request to topic [%s]: %w", topic, err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package ladder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/avro"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const schema = `{"type":"record","name":"refund_request","namespace":"payments","fields":[{"name":"attempt","type":"int"},{"name":"payment_id","type":"string"},{"name":"refund_amount","type":"string"},{"name":"refund_reference","type":"string"}]}`
+
+type mockSender struct {
+	sent []*sarama.ProducerMessage
+	err  error
+}
+
+func (m *mockSender) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	m.sent = append(m.sent, msg)
+	return 0, 0, m.err
+}
+
+func TestUnitParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("refund-request", []string{"1m, 10m", "1h"})
+	require.NoError(t, err)
+	assert.Equal(t, []Tier{
+		{Name: "1m", Topic: "refund-request-retry-1m", Delay: time.Minute},
+		{Name: "10m", Topic: "refund-request-retry-10m", Delay: 10 * time.Minute},
+		{Name: "1h", Topic: "refund-request-retry-1h", Delay: time.Hour},
+	}, tiers)
+
+	tiers, err = ParseTiers("refund-request", nil)
+	require.NoError(t, err)
+	assert.Empty(t, tiers)
+
+	for _, specs := range [][]string{{"soon"}, {"0s"}, {"-1m"}, {"1m,1m"}} {
+		_, err := ParseTiers("refund-request", specs)
+		assert.Error(t, err, "%v", specs)
+	}
+}
+
+func TestUnitNext(t *testing.T) {
+	tiers, _ := ParseTiers("refund-request", []string{"1m,10m"})
+	l := Ladder{Tiers: tiers, ErrorTopic: "refund-request-error"}
+
+	cases := []struct {
+		attempt int32
+		topic   string
+		retry   bool
+	}{
+		{0, "refund-request-retry-1m", true},
+		{1, "refund-request-retry-1m", true},
+		{2, "refund-request-retry-10m", true},
+		{3, "refund-request-error", false},
+		{10, "refund-request-error", false},
+	}
+	for _, c := range cases {
+		topic, retry := l.Next(c.attempt)
+		assert.Equal(t, c.topic, topic, "attempt %d", c.attempt)
+		assert.Equal(t, c.retry, retry, "attempt %d", c.attempt)
+	}
+}
+
+func TestUnitPublish(t *testing.T) {
+	tiers, _ := ParseTiers("refund-request", []string{"1m"})
+	sender := &mockSender{}
+	s := &avro.Schema{Definition: schema}
+	p := NewPublisher(Ladder{Tiers: tiers, ErrorTopic: "refund-request-error"}, sender, s)
+
+	result, err := p.Publish(data.RefundRequest{Attempt: 1, PaymentID: "payment-1", RefundAmount: "1.00", RefundReference: "ref"})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Topic: "refund-request-retry-1m", Retry: true, Attempt: 2}, result)
+
+	require.Len(t, sender.sent, 1)
+	assert.Equal(t, "refund-request-retry-1m", sender.sent[0].Topic)
+	key, _ := sender.sent[0].Key.Encode()
+	assert.Equal(t, "payment-1", string(key))
+
+	value, _ := sender.sent[0].Value.Encode()
+	var rr data.RefundRequest
+	require.NoError(t, s.Unmarshal(value, &rr))
+	assert.Equal(t, int32(2), rr.Attempt)
+	assert.Equal(t, "payment-1", rr.PaymentID)
+
+	result, err = p.Publish(rr)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Topic: "refund-request-error", Retry: false, Attempt: 3}, result)
+}
+
+func TestUnitPublishErrors(t *testing.T) {
+	sender := &mockSender{err: errors.New("broker down")}
+	p := NewPublisher(Ladder{ErrorTopic: "refund-request-error"}, sender, &avro.Schema{Definition: schema})
+
+	_, err := p.Publish(data.RefundRequest{PaymentID: "payment-1"})
+	assert.ErrorIs(t, err, sender.err)
+
+	p.Ladder.ErrorTopic = ""
+	_, err = p.Publish(data.RefundRequest{PaymentID: "payment-1"})
+	assert.ErrorIs(t, err, ErrNoErrorTopic)
+}
+
+func TestUnitPublishInvalid(t *testing.T) {
+	sender := &mockSender{}
+	s := &avro.Schema{Definition: schema}
+	tiers, _ := ParseTiers("refund-request", []string{"1m"})
+	p := NewPublisher(Ladder{Tiers: tiers, ErrorTopic: "refund-request-error"}, sender, s)
+
+	result, err := p.PublishInvalid(data.RefundRequest{Attempt: 1, PaymentID: "payment-1", RefundAmount: "1.00", RefundReference: "ref"}, map[string]string{"currency": "must be GBP"})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Topic: "refund-request-error", Retry: false, Attempt: 1}, result)
+
+	require.Len(t, sender.sent, 1)
+	assert.Equal(t, "refund-request-error", sender.sent[0].Topic)
+	require.Len(t, sender.sent[0].Headers, 1)
+	assert.Equal(t, HeaderInvalidFields, string(sender.sent[0].Headers[0].Key))
+	assert.JSONEq(t, `{"currency":"must be GBP"}`, string(sender.sent[0].Headers[0].Value))
+
+	value, _ := sender.sent[0].Value.Encode()
+	var rr data.RefundRequest
+	require.NoError(t, s.Unmarshal(value, &rr))
+	assert.Equal(t, int32(1), rr.Attempt, "the attempt is unchanged")
+
+	p.Ladder.ErrorTopic = ""
+	_, err = p.PublishInvalid(rr, nil)
+	assert.ErrorIs(t, err, ErrNoErrorTopic)
+}
@@ -2,6 +2,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	goLog "log"
 	"net/http"
@@ -12,10 +14,19 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/kafka/producer"
 	"github.com/companieshouse/chs.go/kafka/resilience"
 	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/audit"
 	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/data"
 	"github.com/companieshouse/refund-request-consumer/handlers"
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/hold"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/ladder"
+	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/companieshouse/refund-request-consumer/policy"
 	"github.com/companieshouse/refund-request-consumer/service"
 	"github.com/gorilla/pat"
 )
@@ -26,40 +37,155 @@ func main() {
 	// Push the Sarama logs into our custom writer
 	sarama.Logger = goLog.New(&log.Writer{}, "[Sarama] ", goLog.LstdFlags)
 
+	// Configuration flags come before the command, which defaults to serve,
+	// and its own flags.
 	cfg, err := config.Get()
 	if err != nil {
 		log.Error(fmt.Errorf("error configuring service: %w. Exiting", err), nil)
+		os.Exit(1)
+	}
+
+	name, args := commandServe, flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage(os.Stdout)
 		return
 	}
+	cmd, ok := commandFor(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command [%s]\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if err := cmd.run(cfg, args); err != nil {
+		log.Error(fmt.Errorf("error running %s command: %w. Exiting", name, err), nil)
+		os.Exit(1)
+	}
+}
+
+// runServe runs the consumers until a close signal is received.
+func runServe(cfg *config.Config, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %v", args)
+	}
+
+	if err := service.ValidateConfig(cfg); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
 
 	log.Info("initialising refund-request-consumer service...")
 
-	mainChannel := make(chan os.Signal, 1)
-	retryChannel := make(chan os.Signal, 1)
+	dedupe, err := idempotency.NewStore(cfg.IdempotencyStore, cfg.IdempotencyStorePath, cfg.IdempotencyStoreSize, time.Duration(cfg.IdempotencyStoreTTL)*time.Hour)
+	if err != nil {
+		return fmt.Errorf("error initialising idempotency store: %w", err)
+	}
+	defer dedupe.Close()
 
-	svc, err := service.New(cfg.ConsumerTopic, cfg.ConsumerGroupName, cfg.ConsumerTopicOffset, cfg, nil)
+	tiers, err := ladder.ParseTiers(cfg.ConsumerTopic, cfg.RetryTiers)
 	if err != nil {
-		log.Error(fmt.Errorf("error initialising main consumer service: '%w'. Exiting", err), nil)
-		return
+		return fmt.Errorf("error configuring retry ladder: %w", err)
 	}
 
-	var wg sync.WaitGroup
+	// Each consumer role has its own group, so that roles never share offsets
+	// or have their partitions rebalanced onto one another.
+	assignments := service.Assignments(cfg, tiers)
+	if err := service.ValidateAssignments(assignments); err != nil {
+		return fmt.Errorf("invalid consumer group configuration: %w", err)
+	}
+	for _, a := range assignments {
+		log.Info(fmt.Sprintf("consumer role [%s] uses group [%s] for topic [%s]", a.Role, a.Group, a.Topic), log.Data{"active": a.Active})
+	}
+
+	role := service.RoleMain
+	if cfg.IsErrorConsumer {
+		role = service.RoleError
+	}
+
+	// Every consumer role reads and writes the same schemas, so they share one
+	// provider of each, which keeps serving the last known schema while the
+	// schema registry is unavailable.
+	schemas, err := service.NewSchemas(cfg)
+	if err != nil {
+		return fmt.Errorf("error loading schemas: %w", err)
+	}
+	if cfg.SchemaRefresh > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		schemas.Run(ctx, time.Duration(cfg.SchemaRefresh)*time.Second)
+	}
+
+	svc, err := service.New(cfg.ConsumerTopic, service.GroupFor(assignments, role), cfg.ConsumerTopicOffset, cfg, nil, dedupe, nil, schemas)
+	if err != nil {
+		return fmt.Errorf("error initialising main consumer service: %w", err)
+	}
+
+	registry := health.NewRegistry()
+	schemas.Register(registry)
+	registry.Register("payments_api", health.HTTPCheck(&http.Client{Timeout: paymentsHealthTimeout}, paymentsHealthURL(cfg), paymentsHealthCacheDuration))
+
+	services := []*service.Service{svc}
 	if !cfg.IsErrorConsumer {
-		retrySvc, err := getRetryService(cfg)
+		retrySvcs, err := getRetryServices(cfg, assignments, tiers, dedupe, schemas)
 		if err != nil {
-			log.Error(fmt.Errorf("error initialising retry consumer service: '%w'. Exiting", err), nil)
 			svc.Shutdown()
-			return
+			return fmt.Errorf("error initialising retry consumer service: %w", err)
 		}
-		wg.Add(1)
-		go retrySvc.Start(&wg, retryChannel)
+		services = append(services, retrySvcs...)
 	}
 
-	wg.Add(1)
-	go svc.Start(&wg, mainChannel)
+	// Every consumer role calls the same payments api, so they share one
+	// circuit breaker and one rate limiter.
+	breaker := payment.NewCircuitBreaker(cfg.CircuitFailures, time.Duration(cfg.CircuitOpenTimeout)*time.Second, cfg.CircuitProbes)
+	registry.Register("payments_api.circuit", breaker.Check)
+	limiter := payment.NewRateLimiter(cfg.RateLimit, cfg.RateLimitBurst)
+
+	// The refund policy limits refunds across every consumer role, so they
+	// share one engine. Refunds it holds are released through the admin
+	// endpoints.
+	var engine *policy.Engine
+	var holds *service.Holds
+	if service.PolicyLimits(cfg).Enabled() {
+		// Holds are decided through the admin endpoints after a consumer may
+		// have shut down, so they are published with their own producer,
+		// which is closed once every consumer has.
+		p, err := producer.New(&producer.Config{Acks: &producer.WaitForAll, BrokerAddrs: cfg.BrokerAddr})
+		if err != nil {
+			for _, s := range services {
+				s.Shutdown()
+			}
+			return fmt.Errorf("error creating kafka producer: %w", err)
+		}
+		defer p.Close()
+
+		var stopPolicy func()
+		engine, holds, stopPolicy, err = startPolicy(context.Background(), cfg, p, dedupe, schemas)
+		if err != nil {
+			for _, s := range services {
+				s.Shutdown()
+			}
+			return err
+		}
+		defer stopPolicy()
+		holds.Submitter.Payments = payment.WithCircuitBreaker(payment.WithRateLimit(holds.Submitter.Payments, limiter), breaker)
+		log.Info(fmt.Sprintf("%d refund requests are held for approval", len(holds.Pending())), log.Data{"topic": cfg.HeldTopic})
+	}
+
+	var wg sync.WaitGroup
+	channels := make([]chan os.Signal, len(services))
+	for i, s := range services {
+		s.Payments = payment.WithCircuitBreaker(payment.WithRateLimit(s.Payments, limiter), breaker)
+		s.Policy, s.Holds = engine, holds
+		s.Health.Register(registry, s.Role)
+		channels[i] = make(chan os.Signal, 1)
+		wg.Add(1)
+		go s.Start(&wg, channels[i])
+	}
 
 	router := pat.New()
-	handlers.Init(router)
+	handlers.Init(router, registry, assignments, services, holds, cfg.AdminAPIKey)
 	go func() {
 		log.Info("Starting HTTP server on :" + "8080")
 		if err := http.ListenAndServe(":8080", router); err != nil {
@@ -67,30 +193,131 @@ func main() {
 		}
 	}()
 
-	waitForServiceClose(&wg, mainChannel, retryChannel)
+	waitForServiceClose(&wg, channels)
 
 	log.Info("Application successfully shutdown")
-
+	return nil
 }
 
-func getRetryService(cfg *config.Config) (*service.Service, error) {
-	retry := &resilience.ServiceRetry{
-		ThrottleRate: time.Duration(cfg.RetryThrottleRate),
-		MaxRetries:   cfg.MaxRetryAttempts,
+// policyLoadTimeout limits how long reading the held and outcome topics at
+// startup takes.
+const policyLoadTimeout = 2 * time.Minute
+
+// startPolicy returns the refund policy engine and the holds of refund
+// requests awaiting approval, which publish to the held and outcome topics
+// with sender. The held requests and the totals are rebuilt from the
+// approvals on the held topic and, with an outcome topic, from the refunds
+// which succeeded in the last day, then both topics are followed so that
+// refunds made by other consumers are counted. Following stops when the
+// returned func is called.
+func startPolicy(ctx context.Context, cfg *config.Config, sender hold.Sender, dedupe idempotency.Store, schemas service.Schemas) (*policy.Engine, *service.Holds, func(), error) {
+	submitter, err := service.NewSubmitter(cfg, dedupe)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if cfg.OutcomeTopic != "" {
+		submitter.Audit = audit.New(cfg.OutcomeTopic, sender, schemas.Outcome)
 	}
+	engine := policy.New(service.PolicyLimits(cfg))
+	holds := service.NewHolds(hold.NewPublisher(cfg.HeldTopic, sender), hold.NewLedger(), engine, submitter)
 
-	retrySvc, err := service.New(cfg.ConsumerTopic, cfg.ConsumerGroupName, cfg.RetryTopicOffset, cfg, retry)
+	client, err := newKafkaClient(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("error initialising retry consumer service: %w", err)
+		return nil, nil, nil, err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, nil, fmt.Errorf("error creating kafka consumer: %w", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stop := func() {
+		cancel()
+		consumer.Close()
+		client.Close()
+	}
+	logError := func(err error) { log.Error(err, nil) }
+
+	loadCtx, cancelLoad := context.WithTimeout(ctx, policyLoadTimeout)
+	defer cancelLoad()
+
+	held, err := hold.Load(loadCtx, client, consumer, cfg.HeldTopic, holds.Apply)
+	if err == nil {
+		err = hold.Follow(ctx, consumer, cfg.HeldTopic, held, holds.Apply, logError)
+	}
+	if err != nil {
+		stop()
+		return nil, nil, nil, fmt.Errorf("error loading held refund requests: %w", err)
+	}
+
+	if cfg.OutcomeTopic != "" {
+		record := func(e data.RefundRequestOutcome) { service.RecordOutcome(engine, e) }
+		outcomes, err := audit.Load(loadCtx, client, consumer, cfg.OutcomeTopic, time.Now().Add(-policy.PaymentWindow), schemas.Outcome, record)
+		if err == nil {
+			err = audit.Follow(ctx, consumer, cfg.OutcomeTopic, outcomes, schemas.Outcome, record, logError)
+		}
+		if err != nil {
+			stop()
+			return nil, nil, nil, fmt.Errorf("error loading refund request outcomes: %w", err)
+		}
+	}
+
+	return engine, holds, stop, nil
+}
+
+// Readiness probes of the payments api time out after paymentsHealthTimeout
+// and their result is reused for paymentsHealthCacheDuration.
+const (
+	paymentsHealthTimeout       = 5 * time.Second
+	paymentsHealthCacheDuration = 10 * time.Second
+)
+
+// paymentsHealthURL returns the URL probed to check the payments api is
+// reachable, defaulting to its healthcheck endpoint.
+func paymentsHealthURL(cfg *config.Config) string {
+	if cfg.PaymentsHealthURL != "" {
+		return cfg.PaymentsHealthURL
 	}
+	return cfg.PaymentsAPIURL + "/healthcheck"
+}
+
+// getRetryServices returns the retry consumer service, or with a retry ladder
+// configured a service for each of its tiers. If any service fails to
+// initialise, those already initialised are shut down.
+func getRetryServices(cfg *config.Config, assignments []service.Assignment, tiers []ladder.Tier, dedupe idempotency.Store, schemas service.Schemas) ([]*service.Service, error) {
+	if len(tiers) == 0 {
+		retry := &resilience.ServiceRetry{
+			ThrottleRate: time.Duration(cfg.RetryThrottleRate),
+			MaxRetries:   cfg.MaxRetryAttempts,
+		}
 
-	return retrySvc, nil
+		retrySvc, err := service.New(cfg.ConsumerTopic, service.GroupFor(assignments, service.RoleRetry), cfg.RetryTopicOffset, cfg, retry, dedupe, nil, schemas)
+		if err != nil {
+			return nil, fmt.Errorf("error initialising retry consumer service: %w", err)
+		}
+
+		return []*service.Service{retrySvc}, nil
+	}
+
+	var retrySvcs []*service.Service
+	for i := range tiers {
+		tierSvc, err := service.New(cfg.ConsumerTopic, service.GroupFor(assignments, service.TierRole(tiers[i])), cfg.RetryTopicOffset, cfg, nil, dedupe, &tiers[i], schemas)
+		if err != nil {
+			for _, s := range retrySvcs {
+				s.Shutdown()
+			}
+			return nil, fmt.Errorf("error initialising retry tier [%s] consumer service: %w", tiers[i].Name, err)
+		}
+		retrySvcs = append(retrySvcs, tierSvc)
+	}
+
+	return retrySvcs, nil
 }
 
 // waitForServiceClose will receive the close signal and forward a notification
-// to all services (go routines) to ensure that they clean up (for example their
-// consumers and producers) and exit gracefully.
-func waitForServiceClose(wg *sync.WaitGroup, mainChannel, retryChannel chan os.Signal) {
+// to all services (go routines) to ensure that they drain in-flight work, clean
+// up (for example their consumers and producers) and exit gracefully.
+func waitForServiceClose(wg *sync.WaitGroup, channels []chan os.Signal) {
 
 	// Channel to fan-out interrupt/kill notifications
 	notificationChannel := make(chan os.Signal, 1)
@@ -100,11 +327,10 @@ func waitForServiceClose(wg *sync.WaitGroup, mainChannel, retryChannel chan os.S
 	case notification := <-notificationChannel:
 		// Falls into this block to successfully close consumer after service shutdown
 		log.Info("Close signal received, fanning out...")
-		log.Debug("Sending notification to main consumer channel")
-		mainChannel <- notification
-
-		log.Debug("Sending notification to retry consumer channel")
-		retryChannel <- notification
+		for i, c := range channels {
+			log.Debug(fmt.Sprintf("Sending notification to consumer channel %d", i))
+			c <- notification
+		}
 
 		log.Info("Fan out completed")
 	}
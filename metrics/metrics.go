@@ -0,0 +1,126 @@
+// Package metrics defines the prometheus metrics exposed by the service.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "refund_request_consumer"
+
+// Destinations a message can be redirected to when it is not processed.
+const (
+	DestinationRetry      = "retry"
+	DestinationError      = "error"
+	DestinationDeadLetter = "dead_letter"
+	DestinationHeld       = "held"
+)
+
+var (
+	// MessagesConsumed counts messages received from kafka.
+	MessagesConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Messages received from kafka.",
+	}, []string{"role", "topic"})
+
+	// RefundsSubmitted counts refunds accepted by the payments api.
+	RefundsSubmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refunds_submitted_total",
+		Help:      "Refunds accepted by the payments api.",
+	}, []string{"role"})
+
+	// RefundsFailed counts refunds that could not be submitted, by the class
+	// of failure.
+	RefundsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refunds_failed_total",
+		Help:      "Refunds that could not be submitted, by class of failure.",
+	}, []string{"role", "class"})
+
+	// MessagesRedirected counts messages sent to another topic instead of
+	// being processed. Messages redirected to retry from the retry role go to
+	// the error topic once their retries are exhausted.
+	MessagesRedirected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_redirected_total",
+		Help:      "Messages sent to a retry, error or dead-letter topic.",
+	}, []string{"role", "destination"})
+
+	// PaymentsAPILatency observes the duration of refund requests to the
+	// payments api.
+	PaymentsAPILatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "payments_api_request_duration_seconds",
+		Help:      "Duration of refund requests to the payments api.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	// ProcessingDuration observes the time from receiving a message to
+	// finishing with it.
+	ProcessingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "message_processing_duration_seconds",
+		Help:      "Time from receiving a message to finishing with it.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"role"})
+
+	// PaymentsCircuitState is the state of the payments api circuit breaker.
+	PaymentsCircuitState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payments_api_circuit_state",
+		Help:      "State of the payments api circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+
+	// PaymentsRateLimit is the rate at which refunds may currently be
+	// submitted to the payments api.
+	PaymentsRateLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payments_api_rate_limit",
+		Help:      "Refund submissions per second currently allowed by the payments api rate limiter.",
+	})
+
+	// ConsumerPaused is 1 while a consumer role is paused by an operator.
+	ConsumerPaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_paused",
+		Help:      "Whether the consumer role is paused: 1 paused, 0 running.",
+	}, []string{"role"})
+
+	// ConsumerLag is the number of messages in a partition not yet consumed.
+	ConsumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_lag",
+		Help:      "Messages in a partition not yet consumed.",
+	}, []string{"role", "topic", "partition"})
+)
+
+// Registry holds every metric exposed by the service.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		MessagesConsumed,
+		RefundsSubmitted,
+		RefundsFailed,
+		MessagesRedirected,
+		PaymentsAPILatency,
+		ProcessingDuration,
+		PaymentsCircuitState,
+		PaymentsRateLimit,
+		ConsumerPaused,
+		ConsumerLag,
+	)
+}
+
+// Handler returns an http handler exposing the metrics in the prometheus
+// text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitHandler(t *testing.T) {
+	MessagesConsumed.WithLabelValues("main", "refund-request").Inc()
+	RefundsFailed.WithLabelValues("main", "server").Inc()
+	PaymentsAPILatency.WithLabelValues("success").Observe(0.2)
+	ConsumerLag.WithLabelValues("main", "refund-request", "0").Set(3)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `refund_request_consumer_messages_consumed_total{role="main",topic="refund-request"} 1`)
+	assert.Contains(t, body, `refund_request_consumer_refunds_failed_total{class="server",role="main"} 1`)
+	assert.Contains(t, body, `refund_request_consumer_payments_api_request_duration_seconds_count{outcome="success"} 1`)
+	assert.Contains(t, body, `refund_request_consumer_consumer_lag{partition="0",role="main",topic="refund-request"} 3`)
+	assert.Contains(t, body, "go_goroutines")
+}
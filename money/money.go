@@ -0,0 +1,202 @@
+// Package money parses decimal monetary amounts, as sent by upstream
+// producers on the refund-request topic, into integer minor units.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// DefaultCurrency is the ISO 4217 code of the currency refunds are made in.
+const DefaultCurrency = "GBP"
+
+// DefaultMaxMinorUnits is the largest amount, in minor units, accepted by
+// the default parser. It matches the range of the payments API amount field.
+const DefaultMaxMinorUnits = math.MaxInt32
+
+// Reasons an amount can be rejected. They are wrapped by InvalidAmountError
+// so callers can use errors.Is to classify a failure.
+var (
+	ErrEmpty              = errors.New("amount is empty")
+	ErrFormat             = errors.New("amount is not a decimal number")
+	ErrSign               = errors.New("amount must not be signed")
+	ErrScale              = errors.New("amount has too many decimal places")
+	ErrThousandsSeparator = errors.New("amount has misplaced thousands separators")
+	ErrTooLarge           = errors.New("amount exceeds maximum value")
+	ErrCurrency           = errors.New("amount is in an unsupported currency")
+)
+
+// InvalidAmountError is returned when an amount cannot be parsed.
+type InvalidAmountError struct {
+	Amount string
+	Reason error
+}
+
+func (e *InvalidAmountError) Error() string {
+	return fmt.Sprintf("invalid amount [%s]: %s", e.Amount, e.Reason)
+}
+
+// Unwrap returns the reason the amount was rejected.
+func (e *InvalidAmountError) Unwrap() error {
+	return e.Reason
+}
+
+// currency describes the symbol and number of minor unit digits of a
+// supported currency.
+type currency struct {
+	symbol string
+	scale  int
+}
+
+var currencies = map[string]currency{
+	"GBP": {symbol: "£", scale: 2},
+	"EUR": {symbol: "€", scale: 2},
+	"USD": {symbol: "$", scale: 2},
+}
+
+// Parser converts decimal amounts into minor units of a single currency.
+type Parser struct {
+	// Currency is the ISO 4217 code amounts are expected in. An amount may
+	// be prefixed with this code or its symbol; any other prefix is rejected.
+	Currency string
+	// MaxMinorUnits is the largest amount, in minor units, that is accepted.
+	MaxMinorUnits int64
+}
+
+// NewParser returns a Parser for the default currency and maximum value.
+func NewParser() *Parser {
+	return &Parser{
+		Currency:      DefaultCurrency,
+		MaxMinorUnits: DefaultMaxMinorUnits,
+	}
+}
+
+// ToPence converts a decimal amount in pounds, such as "1,116.32", into pence
+// using the default parser.
+func ToPence(amount string) (int, error) {
+	minor, err := NewParser().Parse(amount)
+	if err != nil {
+		return 0, err
+	}
+	return int(minor), nil
+}
+
+// Parse converts a decimal amount into minor units. The integer part may use
+// comma thousands separators and the fractional part may have up to the
+// currency's number of minor unit digits; missing digits are zero filled so
+// "12.5" is 1250 pence.
+func (p *Parser) Parse(amount string) (int64, error) {
+	invalid := func(reason error) (int64, error) {
+		return 0, &InvalidAmountError{Amount: amount, Reason: reason}
+	}
+
+	cur, ok := currencies[p.Currency]
+	if !ok {
+		return invalid(ErrCurrency)
+	}
+
+	s := strings.TrimSpace(amount)
+	if s == "" {
+		return invalid(ErrEmpty)
+	}
+
+	s, err := p.stripCurrency(s, cur)
+	if err != nil {
+		return invalid(err)
+	}
+	if s == "" {
+		return invalid(ErrEmpty)
+	}
+
+	if s[0] == '-' || s[0] == '+' {
+		return invalid(ErrSign)
+	}
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && fraction == "") {
+		return invalid(ErrFormat)
+	}
+	if !isDigits(fraction) {
+		return invalid(ErrFormat)
+	}
+	if len(fraction) > cur.scale {
+		return invalid(ErrScale)
+	}
+
+	whole, err = stripThousandsSeparators(whole)
+	if err != nil {
+		return invalid(err)
+	}
+	if len(whole) > 1 && whole[0] == '0' {
+		return invalid(ErrFormat)
+	}
+
+	fraction += strings.Repeat("0", cur.scale-len(fraction))
+
+	var minor int64
+	for _, r := range whole + fraction {
+		d := int64(r - '0')
+		if minor > (math.MaxInt64-d)/10 {
+			return invalid(ErrTooLarge)
+		}
+		minor = minor*10 + d
+	}
+
+	if minor > p.MaxMinorUnits {
+		return invalid(ErrTooLarge)
+	}
+
+	return minor, nil
+}
+
+// stripCurrency removes a leading currency symbol or ISO code, returning
+// ErrCurrency if the amount is denominated in a different currency.
+func (p *Parser) stripCurrency(s string, cur currency) (string, error) {
+	if rest, ok := strings.CutPrefix(s, cur.symbol); ok {
+		return rest, nil
+	}
+	if rest, ok := strings.CutPrefix(s, p.Currency); ok {
+		return strings.TrimLeft(rest, " "), nil
+	}
+
+	if r := []rune(s)[0]; unicode.IsLetter(r) || unicode.Is(unicode.Sc, r) {
+		return "", ErrCurrency
+	}
+
+	return s, nil
+}
+
+// stripThousandsSeparators removes comma separators from the integer part of
+// an amount, checking that every group after the first has three digits.
+func stripThousandsSeparators(whole string) (string, error) {
+	if !strings.Contains(whole, ",") {
+		if !isDigits(whole) {
+			return "", ErrFormat
+		}
+		return whole, nil
+	}
+
+	groups := strings.Split(whole, ",")
+	for i, g := range groups {
+		if !isDigits(g) {
+			return "", ErrFormat
+		}
+		if (i == 0 && (len(g) == 0 || len(g) > 3)) || (i > 0 && len(g) != 3) {
+			return "", ErrThousandsSeparator
+		}
+	}
+
+	return strings.Join(groups, ""), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
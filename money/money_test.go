@@ -0,0 +1,178 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitParse(t *testing.T) {
+	tests := []struct {
+		amount   string
+		expected int64
+		reason   error
+	}{
+		{amount: "116.32", expected: 11632},
+		{amount: "100", expected: 10000},
+		{amount: "100.00", expected: 10000},
+		{amount: "12.5", expected: 1250},
+		{amount: "0.01", expected: 1},
+		{amount: "0", expected: 0},
+		{amount: "0.00", expected: 0},
+		{amount: " 7.20 ", expected: 720},
+		{amount: "1,000.00", expected: 100000},
+		{amount: "12,345,678.90", expected: 1234567890},
+		{amount: "£1,000.00", expected: 100000},
+		{amount: "GBP 15.00", expected: 1500},
+		{amount: "GBP15", expected: 1500},
+		{amount: "21474836.47", expected: 2147483647},
+		{amount: "", reason: ErrEmpty},
+		{amount: "   ", reason: ErrEmpty},
+		{amount: "£", reason: ErrEmpty},
+		{amount: "-1.00", reason: ErrSign},
+		{amount: "+1.00", reason: ErrSign},
+		{amount: "£-1.00", reason: ErrSign},
+		{amount: "12.505", reason: ErrScale},
+		{amount: "0.001", reason: ErrScale},
+		{amount: "12.", reason: ErrFormat},
+		{amount: ".50", reason: ErrFormat},
+		{amount: "1.2.3", reason: ErrFormat},
+		{amount: "12a.00", reason: ErrFormat},
+		{amount: "12.0a", reason: ErrFormat},
+		{amount: "1 000.00", reason: ErrFormat},
+		{amount: "007.00", reason: ErrFormat},
+		{amount: "1e3", reason: ErrFormat},
+		{amount: "1000,00", reason: ErrThousandsSeparator},
+		{amount: "1,00.00", reason: ErrThousandsSeparator},
+		{amount: ",100.00", reason: ErrThousandsSeparator},
+		{amount: "1,000,", reason: ErrThousandsSeparator},
+		{amount: "1000,000.00", reason: ErrThousandsSeparator},
+		{amount: "21474836.48", reason: ErrTooLarge},
+		{amount: "99999999999999999999.00", reason: ErrTooLarge},
+		{amount: "€10.00", reason: ErrCurrency},
+		{amount: "EUR 10.00", reason: ErrCurrency},
+		{amount: "$10.00", reason: ErrCurrency},
+	}
+
+	p := NewParser()
+	for _, tt := range tests {
+		t.Run(tt.amount, func(t *testing.T) {
+			actual, err := p.Parse(tt.amount)
+			if tt.reason != nil {
+				assert.ErrorIs(t, err, tt.reason)
+				var invalid *InvalidAmountError
+				assert.True(t, errors.As(err, &invalid))
+				assert.Equal(t, tt.amount, invalid.Amount)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestUnitParseCurrency(t *testing.T) {
+	p := &Parser{Currency: "EUR", MaxMinorUnits: DefaultMaxMinorUnits}
+
+	amount, err := p.Parse("€10.50")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1050), amount)
+
+	_, err = p.Parse("£10.50")
+	assert.ErrorIs(t, err, ErrCurrency)
+
+	_, err = (&Parser{Currency: "XXX"}).Parse("10.50")
+	assert.ErrorIs(t, err, ErrCurrency)
+}
+
+func TestUnitParseMaxMinorUnits(t *testing.T) {
+	p := &Parser{Currency: DefaultCurrency, MaxMinorUnits: 10000}
+
+	amount, err := p.Parse("100.00")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10000), amount)
+
+	_, err = p.Parse("100.01")
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestUnitToPence(t *testing.T) {
+	amount, err := ToPence("116.32")
+	assert.NoError(t, err)
+	assert.Equal(t, 11632, amount)
+
+	_, err = ToPence("12.345")
+	assert.ErrorIs(t, err, ErrScale)
+}
+
+func TestUnitInvalidAmountError_Error(t *testing.T) {
+	err := &InvalidAmountError{Amount: "12.345", Reason: ErrScale}
+	assert.Equal(t, "invalid amount [12.345]: amount has too many decimal places", err.Error())
+}
+
+// TestUnitParseRoundTrip checks that any amount within range, formatted the
+// ways upstream producers format it, parses back to the same minor units.
+func TestUnitParseRoundTrip(t *testing.T) {
+	p := NewParser()
+
+	property := func(n uint32) bool {
+		minor := int64(n) % (DefaultMaxMinorUnits + 1)
+		pounds, pence := minor/100, minor%100
+
+		formats := []string{
+			fmt.Sprintf("%d.%02d", pounds, pence),
+			"£" + groupThousands(pounds) + fmt.Sprintf(".%02d", pence),
+			"GBP " + fmt.Sprintf("%d.%02d", pounds, pence),
+		}
+		if pence%10 == 0 {
+			formats = append(formats, fmt.Sprintf("%d.%d", pounds, pence/10))
+		}
+		if pence == 0 {
+			formats = append(formats, fmt.Sprintf("%d", pounds))
+		}
+
+		for _, f := range formats {
+			actual, err := p.Parse(f)
+			if err != nil || actual != minor {
+				t.Logf("%q parsed as %d, %v; expected %d", f, actual, err, minor)
+				return false
+			}
+		}
+		return true
+	}
+
+	assert.NoError(t, quick.Check(property, &quick.Config{MaxCount: 5000}))
+}
+
+// TestUnitParseNeverPanics checks that arbitrary input is either parsed or
+// rejected with an InvalidAmountError.
+func TestUnitParseNeverPanics(t *testing.T) {
+	p := NewParser()
+
+	property := func(s string) bool {
+		amount, err := p.Parse(s)
+		if err != nil {
+			var invalid *InvalidAmountError
+			return errors.As(err, &invalid)
+		}
+		return amount >= 0 && amount <= DefaultMaxMinorUnits
+	}
+
+	assert.NoError(t, quick.Check(property, &quick.Config{MaxCount: 5000}))
+}
+
+func groupThousands(n int64) string {
+	s := fmt.Sprintf("%d", n)
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
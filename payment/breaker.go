@@ -0,0 +1,260 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/metrics"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+// Circuit breaker states. While closed, requests are made as normal. While
+// open, requests wait rather than being made. Once the open timeout has
+// passed the circuit is half-open, and probe requests are made one at a time
+// to find out whether the payments api has recovered.
+const (
+	CircuitClosed CircuitState = iota
+	CircuitHalfOpen
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitHalfOpen:
+		return "half_open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops requests to the payments api after consecutive
+// failures indicating that it is unavailable. Failures are transport errors,
+// timeouts and server errors; a request the payments api rejects shows that it
+// is available.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	threshold   int
+	openTimeout time.Duration
+	probes      int
+	state       CircuitState
+	failures    int
+	successes   int
+	probing     bool
+	openedAt    time.Time
+	changed     chan struct{}
+	now         func() time.Time
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker which opens after
+// threshold consecutive failures, stays open for openTimeout, then closes once
+// probes consecutive probe requests have succeeded.
+func NewCircuitBreaker(threshold int, openTimeout time.Duration, probes int) *CircuitBreaker {
+	if probes < 1 {
+		probes = 1
+	}
+	return &CircuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		probes:      probes,
+		changed:     make(chan struct{}),
+		now:         time.Now,
+	}
+}
+
+// State returns the current state of the circuit.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.halfOpenIfDue()
+	return b.state
+}
+
+// Wait blocks until a request may be made, or ctx is done. It returns whether
+// the request is a probe, which must be passed to Record with its outcome.
+func (b *CircuitBreaker) Wait(ctx context.Context) (probe bool, err error) {
+	for {
+		b.mu.Lock()
+		b.halfOpenIfDue()
+
+		switch {
+		case b.state == CircuitClosed:
+			b.mu.Unlock()
+			return false, nil
+		case b.state == CircuitHalfOpen && !b.probing:
+			b.probing = true
+			b.mu.Unlock()
+			return true, nil
+		}
+
+		changed := b.changed
+		timer := time.NewTimer(b.openedAt.Add(b.openTimeout).Sub(b.now()))
+		if b.state != CircuitOpen {
+			// Half-open with a probe in flight: wait for its outcome.
+			timer.Stop()
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Record records the outcome of a request made after Wait.
+func (b *CircuitBreaker) Record(probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// A cancelled request says nothing about the payments api.
+	if errors.Is(err, context.Canceled) {
+		if probe {
+			b.probing = false
+			b.broadcast()
+		}
+		return
+	}
+
+	failed := isUnavailable(err)
+	if probe {
+		b.probing = false
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.probes {
+			log.Info("payments api circuit closed, resuming refund submissions")
+			b.setState(CircuitClosed)
+		}
+		b.broadcast()
+		return
+	}
+
+	if b.state != CircuitClosed {
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+// Check reports the circuit as failing while it is not closed.
+func (b *CircuitBreaker) Check() health.Check {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.halfOpenIfDue()
+	data := map[string]interface{}{"state": b.state.String(), "consecutive_failures": b.failures}
+	if b.state == CircuitClosed {
+		return health.Check{Status: health.StatusOK, Data: data}
+	}
+
+	data["opened_at"] = b.openedAt
+	return health.Check{Status: health.StatusFail, Detail: "payments api circuit is " + b.state.String(), Data: data}
+}
+
+func (b *CircuitBreaker) open() {
+	log.Info(fmt.Sprintf("payments api circuit opened, pausing refund submissions for %s", b.openTimeout), log.Data{"consecutive_failures": b.failures})
+	b.openedAt = b.now()
+	b.setState(CircuitOpen)
+	b.broadcast()
+}
+
+func (b *CircuitBreaker) halfOpenIfDue() {
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		log.Info("payments api circuit half-open, probing")
+		b.setState(CircuitHalfOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state CircuitState) {
+	b.state = state
+	b.failures = 0
+	b.successes = 0
+	metrics.PaymentsCircuitState.Set(float64(state))
+}
+
+// broadcast wakes every request waiting for the circuit to change.
+func (b *CircuitBreaker) broadcast() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// isUnavailable reports whether err shows that the payments api is
+// unavailable, rather than that it rejected the request.
+func isUnavailable(err error) bool {
+	class, ok := ClassOf(err)
+	if !ok {
+		return false
+	}
+	return class == ClassTransport || class == ClassTimeout || class == ClassServer
+}
+
+// circuitBreakingPayments is a Payments whose requests are made through a
+// circuit breaker.
+type circuitBreakingPayments struct {
+	payments Payments
+	breaker  *CircuitBreaker
+}
+
+// WithCircuitBreaker returns payments whose refund requests wait while the
+// circuit is open, rather than failing, so that consumers stop consuming until
+// the payments api recovers. A request which fails once the circuit has opened
+// is also held, and made again when the circuit allows.
+func WithCircuitBreaker(payments Payments, breaker *CircuitBreaker) Payments {
+	return &circuitBreakingPayments{payments: payments, breaker: breaker}
+}
+
+// RefundRequestPost implements Payments.RefundRequestPost.
+func (p *circuitBreakingPayments) RefundRequestPost(ctx context.Context, refundRequestURL string, patchBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+	for {
+		probe, err := p.breaker.Wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = p.payments.RefundRequestPost(ctx, refundRequestURL, patchBody, idempotencyKey, HTTPClient, apiKey)
+		p.breaker.Record(probe, err)
+		if !isUnavailable(err) || p.breaker.State() == CircuitClosed {
+			return err
+		}
+	}
+}
+
+// GetPayment implements Payments.GetPayment.
+func (p *circuitBreakingPayments) GetPayment(ctx context.Context, paymentURL string, HTTPClient *http.Client, apiKey string) (*data.PaymentResource, error) {
+	for {
+		probe, err := p.breaker.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resource, err := p.payments.GetPayment(ctx, paymentURL, HTTPClient, apiKey)
+		p.breaker.Record(probe, err)
+		if !isUnavailable(err) || p.breaker.State() == CircuitClosed {
+			return resource, err
+		}
+	}
+}
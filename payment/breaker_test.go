@@ -0,0 +1,198 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errUnavailable = &TransportError{Err: errors.New("connection refused")}
+	errRejected    = &InvalidPaymentAPIResponse{status: http.StatusBadRequest}
+)
+
+func TestUnitCircuitBreakerOpens(t *testing.T) {
+	b := NewCircuitBreaker(3, time.Minute, 1)
+
+	b.Record(false, errUnavailable)
+	b.Record(false, errUnavailable)
+	b.Record(false, nil)
+	b.Record(false, errUnavailable)
+	b.Record(false, errUnavailable)
+	b.Record(false, errRejected)
+	b.Record(false, errUnavailable)
+	b.Record(false, errUnavailable)
+	assert.Equal(t, CircuitClosed, b.State(), "a success or rejection shows the payments api is available")
+	assert.True(t, b.Check().OK())
+
+	b.Record(false, errUnavailable)
+	assert.Equal(t, CircuitOpen, b.State())
+	check := b.Check()
+	assert.False(t, check.OK())
+	assert.Equal(t, "open", check.Data["state"])
+}
+
+func TestUnitCircuitBreakerDisabled(t *testing.T) {
+	b := NewCircuitBreaker(0, time.Minute, 1)
+	for i := 0; i < 100; i++ {
+		b.Record(false, errUnavailable)
+	}
+	assert.Equal(t, CircuitClosed, b.State())
+}
+
+func TestUnitCircuitBreakerWaitsWhileOpen(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Hour, 1)
+	b.Record(false, errUnavailable)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := b.Wait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestUnitCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(1, time.Minute, 2)
+	b.now = func() time.Time { return now }
+	b.Record(false, errUnavailable)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.False(t, b.Check().OK())
+
+	probe, err := b.Wait(context.Background())
+	require.NoError(t, err)
+	assert.True(t, probe)
+
+	// Only one probe is made at a time.
+	waited := make(chan bool)
+	go func() {
+		probe, _ := b.Wait(context.Background())
+		waited <- probe
+	}()
+	select {
+	case <-waited:
+		t.Fatal("second probe allowed while the first is in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	b.Record(true, nil)
+	assert.True(t, <-waited)
+	assert.Equal(t, CircuitHalfOpen, b.State())
+
+	b.Record(true, nil)
+	assert.Equal(t, CircuitClosed, b.State())
+
+	probe, err = b.Wait(context.Background())
+	require.NoError(t, err)
+	assert.False(t, probe)
+}
+
+func TestUnitCircuitBreakerFailedProbeReopens(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(1, time.Minute, 1)
+	b.now = func() time.Time { return now }
+	b.Record(false, errUnavailable)
+
+	now = now.Add(time.Minute)
+	probe, err := b.Wait(context.Background())
+	require.NoError(t, err)
+	require.True(t, probe)
+
+	b.Record(true, errUnavailable)
+	assert.Equal(t, CircuitOpen, b.State())
+}
+
+func TestUnitCircuitBreakerCancelledProbe(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(1, time.Minute, 1)
+	b.now = func() time.Time { return now }
+	b.Record(false, errUnavailable)
+
+	now = now.Add(time.Minute)
+	probe, _ := b.Wait(context.Background())
+	require.True(t, probe)
+
+	b.Record(true, context.Canceled)
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	probe, err := b.Wait(context.Background())
+	require.NoError(t, err)
+	assert.True(t, probe, "a cancelled probe lets another probe be made")
+}
+
+// fakePayments returns each of errs in turn, then nil.
+type fakePayments struct {
+	mu    sync.Mutex
+	errs  []error
+	calls int
+}
+
+func (f *fakePayments) RefundRequestPost(ctx context.Context, refundRequestURL string, patchBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+	return f.next()
+}
+
+func (f *fakePayments) GetPayment(ctx context.Context, paymentURL string, HTTPClient *http.Client, apiKey string) (*data.PaymentResource, error) {
+	if err := f.next(); err != nil {
+		return nil, err
+	}
+	return &data.PaymentResource{Status: "paid"}, nil
+}
+
+func (f *fakePayments) next() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func TestUnitWithCircuitBreaker(t *testing.T) {
+	fake := &fakePayments{errs: []error{errUnavailable, errUnavailable}}
+	payments := WithCircuitBreaker(fake, NewCircuitBreaker(2, 10*time.Millisecond, 1))
+
+	// Below the threshold a failure is returned as normal.
+	err := payments.RefundRequestPost(context.Background(), "url", data.RefundPostRequest{}, "key", http.DefaultClient, "apiKey")
+	assert.ErrorIs(t, err, errUnavailable)
+
+	// The failure which opens the circuit is held and the request made again
+	// once the payments api recovers.
+	err = payments.RefundRequestPost(context.Background(), "url", data.RefundPostRequest{}, "key", http.DefaultClient, "apiKey")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, fake.calls)
+}
+
+func TestUnitWithCircuitBreakerGetPayment(t *testing.T) {
+	fake := &fakePayments{errs: []error{errUnavailable}}
+	payments := WithCircuitBreaker(fake, NewCircuitBreaker(1, 10*time.Millisecond, 1))
+
+	resource, err := payments.GetPayment(context.Background(), "url", http.DefaultClient, "apiKey")
+	assert.NoError(t, err)
+	assert.Equal(t, "paid", resource.Status)
+	assert.Equal(t, 2, fake.calls)
+}
+
+func TestUnitWithCircuitBreakerCancelled(t *testing.T) {
+	fake := &fakePayments{errs: []error{errUnavailable, errUnavailable}}
+	payments := WithCircuitBreaker(fake, NewCircuitBreaker(1, time.Hour, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	err := payments.RefundRequestPost(ctx, "url", data.RefundPostRequest{}, "key", http.DefaultClient, "apiKey")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, fake.calls)
+}
@@ -0,0 +1,118 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrorClass categorises a failed request to the payments api.
+type ErrorClass string
+
+// Classes of payments api failure.
+const (
+	ClassTransport      ErrorClass = "transport"
+	ClassTimeout        ErrorClass = "timeout"
+	ClassValidation     ErrorClass = "validation"
+	ClassUnknownPayment ErrorClass = "unknown_payment"
+	ClassDuplicate      ErrorClass = "duplicate"
+	ClassThrottled      ErrorClass = "throttled"
+	ClassServer         ErrorClass = "server"
+	ClassNotRefundable  ErrorClass = "not_refundable"
+)
+
+// maxErrorBodySize is the most of an error response body that is captured.
+const maxErrorBodySize = 4096
+
+// ClassifiedError is implemented by every error describing a failed request
+// to the payments api.
+type ClassifiedError interface {
+	error
+	Class() ErrorClass
+	Retryable() bool
+}
+
+// IsPermanent reports whether err is a payments api failure that will fail
+// again if retried. Errors that are not from the payments api, and so cannot
+// be classified, are not permanent.
+func IsPermanent(err error) bool {
+	var classified ClassifiedError
+	return errors.As(err, &classified) && !classified.Retryable()
+}
+
+// ClassOf returns the class of a payments api failure, and false if err does
+// not describe one.
+func ClassOf(err error) (ErrorClass, bool) {
+	var classified ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.Class(), true
+	}
+	return "", false
+}
+
+// Class returns the class of the response status.
+func (e *InvalidPaymentAPIResponse) Class() ErrorClass {
+	switch {
+	case e.status == http.StatusNotFound:
+		return ClassUnknownPayment
+	case e.status == http.StatusConflict:
+		return ClassDuplicate
+	case e.status == http.StatusTooManyRequests:
+		return ClassThrottled
+	case e.status == http.StatusRequestTimeout:
+		return ClassTimeout
+	case e.status >= 500:
+		return ClassServer
+	default:
+		return ClassValidation
+	}
+}
+
+// Status returns the http status returned by the payments api.
+func (e *InvalidPaymentAPIResponse) Status() int {
+	return e.status
+}
+
+// RetryAfter returns the delay the payments api asked for in a Retry-After
+// header, or zero if it gave none.
+func (e *InvalidPaymentAPIResponse) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// Body returns the start of the response body returned by the payments api.
+func (e *InvalidPaymentAPIResponse) Body() string {
+	return e.body
+}
+
+// TransportError is returned when no response is received from the payments
+// api. It is always retryable.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("error calling payments api: %s", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Class returns ClassTimeout if the request timed out and ClassTransport
+// otherwise.
+func (e *TransportError) Class() ErrorClass {
+	var netErr net.Error
+	if errors.Is(e.Err, context.DeadlineExceeded) || (errors.As(e.Err, &netErr) && netErr.Timeout()) {
+		return ClassTimeout
+	}
+	return ClassTransport
+}
+
+// Retryable implements ClassifiedError.Retryable.
+func (e *TransportError) Retryable() bool {
+	return true
+}
@@ -0,0 +1,102 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitInvalidPaymentAPIResponse_Class(t *testing.T) {
+	tests := []struct {
+		status int
+		class  ErrorClass
+	}{
+		{status: http.StatusBadRequest, class: ClassValidation},
+		{status: http.StatusUnprocessableEntity, class: ClassValidation},
+		{status: http.StatusNotFound, class: ClassUnknownPayment},
+		{status: http.StatusConflict, class: ClassDuplicate},
+		{status: http.StatusTooManyRequests, class: ClassThrottled},
+		{status: http.StatusRequestTimeout, class: ClassTimeout},
+		{status: http.StatusInternalServerError, class: ClassServer},
+		{status: http.StatusServiceUnavailable, class: ClassServer},
+	}
+
+	for _, tt := range tests {
+		err := &InvalidPaymentAPIResponse{status: tt.status}
+		assert.Equal(t, tt.class, err.Class(), "status %d", tt.status)
+	}
+}
+
+func TestUnitTransportError_Class(t *testing.T) {
+	assert.Equal(t, ClassTransport, (&TransportError{Err: errors.New("connection refused")}).Class())
+	assert.Equal(t, ClassTimeout, (&TransportError{Err: fmt.Errorf("post: %w", context.DeadlineExceeded)}).Class())
+	assert.True(t, (&TransportError{}).Retryable())
+}
+
+func TestUnitIsPermanent(t *testing.T) {
+	assert.True(t, IsPermanent(&InvalidPaymentAPIResponse{status: 400, outcome: OutcomePermanent}))
+	assert.True(t, IsPermanent(fmt.Errorf("wrapped: %w", &InvalidPaymentAPIResponse{status: 404})))
+	assert.False(t, IsPermanent(&InvalidPaymentAPIResponse{status: 503, outcome: OutcomeRetryable}))
+	assert.False(t, IsPermanent(&TransportError{Err: errors.New("connection refused")}))
+	assert.False(t, IsPermanent(errors.New("unclassified")))
+}
+
+func TestUnitClassOf(t *testing.T) {
+	class, ok := ClassOf(&InvalidPaymentAPIResponse{status: 429})
+	assert.True(t, ok)
+	assert.Equal(t, ClassThrottled, class)
+
+	_, ok = ClassOf(errors.New("unclassified"))
+	assert.False(t, ok)
+}
+
+func TestUnitRefundRequestPost_ErrorBody(t *testing.T) {
+	payment := New(nil)
+	mockClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			recorder := httptest.NewRecorder()
+			recorder.WriteHeader(http.StatusNotFound)
+			recorder.WriteString(`{"error":"payment not found"}` + strings.Repeat(" ", maxErrorBodySize))
+			return recorder.Result()
+		}),
+	}
+
+	err := payment.RefundRequestPost(context.Background(), "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key")
+
+	var invalid *InvalidPaymentAPIResponse
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, http.StatusNotFound, invalid.Status())
+	assert.Equal(t, `{"error":"payment not found"}`, invalid.Body())
+	assert.Equal(t, ClassUnknownPayment, invalid.Class())
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, `unexpected status returned from payments api: [404]: {"error":"payment not found"}`, err.Error())
+}
+
+func TestUnitRefundRequestPost_TransportError(t *testing.T) {
+	payment := New(nil)
+	mockClient := &http.Client{
+		Transport: roundTripErrFunc(func(req *http.Request) error {
+			return errors.New("connection refused")
+		}),
+	}
+
+	err := payment.RefundRequestPost(context.Background(), "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key")
+
+	var transport *TransportError
+	assert.ErrorAs(t, err, &transport)
+	assert.Equal(t, ClassTransport, transport.Class())
+	assert.False(t, IsPermanent(err))
+}
+
+// roundTripErrFunc is a helper to mock an http.Client that receives no response.
+type roundTripErrFunc func(req *http.Request) error
+
+func (f roundTripErrFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, f(req)
+}
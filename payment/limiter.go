@@ -0,0 +1,183 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/metrics"
+)
+
+// Adaptation of the rate after the payments api throttles requests. The rate
+// is halved on each throttled response, down to minRateFraction of the
+// configured limit, and recovers by recoveryFraction of the limit with each
+// successful request.
+const (
+	minRateFraction  = 0.1
+	recoveryFraction = 0.05
+)
+
+// maxRetryAfter is the longest a Retry-After header may pause requests for.
+const maxRetryAfter = 5 * time.Minute
+
+// RateLimiter is a token bucket limiting the rate of requests to the payments
+// api. When the payments api throttles a request, the rate is reduced and
+// requests are paused for as long as it asked.
+type RateLimiter struct {
+	mu          sync.Mutex
+	limit       float64
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing limit requests per second,
+// with bursts of up to burst requests. A limit of 0 disables rate limiting,
+// though requests are still paused when the payments api throttles them.
+func NewRateLimiter(limit float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &RateLimiter{
+		limit:  limit,
+		rate:   limit,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+	l.last = l.now()
+	metrics.PaymentsRateLimit.Set(limit)
+	return l
+}
+
+// Rate returns the number of requests per second currently allowed.
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate
+}
+
+// Wait blocks until a request may be made, or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := l.now()
+		l.refill(now)
+
+		var wait time.Duration
+		switch {
+		case now.Before(l.pausedUntil):
+			wait = l.pausedUntil.Sub(now)
+		case l.limit <= 0:
+			l.mu.Unlock()
+			return nil
+		case l.tokens >= 1:
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		default:
+			wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Record adapts the rate to the outcome of a request made after Wait. A
+// throttled request reduces the rate, and pauses requests for the delay given
+// by its Retry-After header. A successful request lets the rate recover.
+func (l *RateLimiter) Record(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var invalid *InvalidPaymentAPIResponse
+	if errors.As(err, &invalid) && invalid.Status() == http.StatusTooManyRequests {
+		l.throttled(invalid.RetryAfter())
+		return
+	}
+	if err == nil && l.rate < l.limit {
+		l.setRate(math.Min(l.limit, l.rate+l.limit*recoveryFraction))
+	}
+}
+
+func (l *RateLimiter) throttled(retryAfter time.Duration) {
+	if retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+	now := l.now()
+	if until := now.Add(retryAfter); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+
+	if l.limit > 0 {
+		l.setRate(math.Max(l.rate/2, l.limit*minRateFraction))
+		l.tokens = 0
+		l.last = now
+	}
+	log.Info(fmt.Sprintf("payments api throttled refund submissions, pausing for %s", retryAfter), log.Data{"rate_limit": l.rate})
+}
+
+// refill adds the tokens accrued since the last refill, up to the burst.
+func (l *RateLimiter) refill(now time.Time) {
+	if l.limit > 0 && now.After(l.last) {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+func (l *RateLimiter) setRate(rate float64) {
+	l.rate = rate
+	metrics.PaymentsRateLimit.Set(rate)
+}
+
+// rateLimitedPayments is a Payments whose requests are rate limited.
+type rateLimitedPayments struct {
+	payments Payments
+	limiter  *RateLimiter
+}
+
+// WithRateLimit returns payments whose refund requests wait for the limiter.
+// Sharing a limiter between every consumer role keeps the whole process within
+// the rate agreed with the payments api.
+func WithRateLimit(payments Payments, limiter *RateLimiter) Payments {
+	return &rateLimitedPayments{payments: payments, limiter: limiter}
+}
+
+// RefundRequestPost implements Payments.RefundRequestPost.
+func (p *rateLimitedPayments) RefundRequestPost(ctx context.Context, refundRequestURL string, patchBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+	if err := p.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	err := p.payments.RefundRequestPost(ctx, refundRequestURL, patchBody, idempotencyKey, HTTPClient, apiKey)
+	p.limiter.Record(err)
+	return err
+}
+
+// GetPayment implements Payments.GetPayment.
+func (p *rateLimitedPayments) GetPayment(ctx context.Context, paymentURL string, HTTPClient *http.Client, apiKey string) (*data.PaymentResource, error) {
+	if err := p.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	resource, err := p.payments.GetPayment(ctx, paymentURL, HTTPClient, apiKey)
+	p.limiter.Record(err)
+	return resource, err
+}
@@ -0,0 +1,106 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitRateLimiterBurst(t *testing.T) {
+	l := NewRateLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(context.Background()), "request %d is within the burst", i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded, "the burst is used up")
+}
+
+func TestUnitRateLimiterRefills(t *testing.T) {
+	now := time.Now()
+	l := NewRateLimiter(2, 2)
+	l.now = func() time.Time { return now }
+
+	require.NoError(t, l.Wait(context.Background()))
+	require.NoError(t, l.Wait(context.Background()))
+
+	now = now.Add(500 * time.Millisecond)
+	require.NoError(t, l.Wait(context.Background()), "one token accrues in half a second at 2 per second")
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+	assert.InDelta(t, 0, l.tokens, 0.001, "tokens accrue no further than the burst")
+}
+
+func TestUnitRateLimiterDisabled(t *testing.T) {
+	l := NewRateLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+}
+
+func TestUnitRateLimiterThrottled(t *testing.T) {
+	now := time.Now()
+	l := NewRateLimiter(10, 1)
+	l.now = func() time.Time { return now }
+
+	l.Record(&InvalidPaymentAPIResponse{status: http.StatusTooManyRequests, retryAfter: time.Minute})
+	assert.Equal(t, 5.0, l.Rate(), "the rate is halved")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded, "requests are paused for the Retry-After delay")
+
+	for i := 0; i < 10; i++ {
+		l.Record(&InvalidPaymentAPIResponse{status: http.StatusTooManyRequests})
+	}
+	assert.Equal(t, 1.0, l.Rate(), "the rate is reduced no further than a tenth of the limit")
+
+	// Other failures leave the rate alone, and successes let it recover.
+	l.Record(errUnavailable)
+	l.Record(errRejected)
+	assert.Equal(t, 1.0, l.Rate())
+	for i := 0; i < 100; i++ {
+		l.Record(nil)
+	}
+	assert.Equal(t, 10.0, l.Rate(), "the rate recovers no further than the limit")
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, l.Wait(context.Background()), "requests resume after the Retry-After delay")
+}
+
+func TestUnitRateLimiterRetryAfterCapped(t *testing.T) {
+	now := time.Now()
+	l := NewRateLimiter(0, 1)
+	l.now = func() time.Time { return now }
+
+	l.Record(&InvalidPaymentAPIResponse{status: http.StatusTooManyRequests, retryAfter: 24 * time.Hour})
+	assert.Equal(t, now.Add(maxRetryAfter), l.pausedUntil)
+	assert.Equal(t, 0.0, l.Rate(), "a disabled limiter has no rate to reduce")
+}
+
+func TestUnitWithRateLimit(t *testing.T) {
+	fake := &fakePayments{errs: []error{&InvalidPaymentAPIResponse{status: http.StatusTooManyRequests, retryAfter: time.Hour}}}
+	limiter := NewRateLimiter(100, 1)
+	payments := WithRateLimit(fake, limiter)
+
+	err := payments.RefundRequestPost(context.Background(), "url", data.RefundPostRequest{}, "key", http.DefaultClient, "apiKey")
+	var invalid *InvalidPaymentAPIResponse
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, 50.0, limiter.Rate())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = payments.RefundRequestPost(ctx, "url", data.RefundPostRequest{}, "key", http.DefaultClient, "apiKey")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, fake.calls, "no request is made while paused")
+}
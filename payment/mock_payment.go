@@ -5,6 +5,7 @@
 package payment
 
 import (
+	context "context"
 	http "net/http"
 	reflect "reflect"
 
@@ -35,16 +36,31 @@ func (m *MockPayments) EXPECT() *MockPaymentsMockRecorder {
 	return m.recorder
 }
 
+// GetPayment mocks base method.
+func (m *MockPayments) GetPayment(ctx context.Context, paymentURL string, HTTPClient *http.Client, apiKey string) (*data.PaymentResource, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPayment", ctx, paymentURL, HTTPClient, apiKey)
+	ret0, _ := ret[0].(*data.PaymentResource)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPayment indicates an expected call of GetPayment.
+func (mr *MockPaymentsMockRecorder) GetPayment(ctx, paymentURL, HTTPClient, apiKey interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPayment", reflect.TypeOf((*MockPayments)(nil).GetPayment), ctx, paymentURL, HTTPClient, apiKey)
+}
+
 // RefundRequestPost mocks base method.
-func (m *MockPayments) RefundRequestPost(refundRequestURL string, patchBody data.RefundPostRequest, HTTPClient *http.Client, apiKey string) error {
+func (m *MockPayments) RefundRequestPost(ctx context.Context, refundRequestURL string, patchBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RefundRequestPost", refundRequestURL, patchBody, HTTPClient, apiKey)
+	ret := m.ctrl.Call(m, "RefundRequestPost", ctx, refundRequestURL, patchBody, idempotencyKey, HTTPClient, apiKey)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RefundRequestPost indicates an expected call of RefundRequestPost.
-func (mr *MockPaymentsMockRecorder) RefundRequestPost(refundRequestURL, patchBody, HTTPClient, apiKey interface{}) *gomock.Call {
+func (mr *MockPaymentsMockRecorder) RefundRequestPost(ctx, refundRequestURL, patchBody, idempotencyKey, HTTPClient, apiKey interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefundRequestPost", reflect.TypeOf((*MockPayments)(nil).RefundRequestPost), refundRequestURL, patchBody, HTTPClient, apiKey)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefundRequestPost", reflect.TypeOf((*MockPayments)(nil).RefundRequestPost), ctx, refundRequestURL, patchBody, idempotencyKey, HTTPClient, apiKey)
 }
@@ -2,61 +2,189 @@ package payment
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/refund-request-consumer/data"
 )
 
+// IdempotencyKeyHeader is the header carrying the idempotency key of a refund
+// request, allowing the payments api to recognise a resubmitted refund.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 // InvalidPaymentAPIResponse is returned when an invalid status is returned
 // from the payments api.
 type InvalidPaymentAPIResponse struct {
-	status int
+	status     int
+	outcome    Outcome
+	body       string
+	retryAfter time.Duration
 }
 
 func (e *InvalidPaymentAPIResponse) Error() string {
+	if e.body != "" {
+		return fmt.Sprintf("unexpected status returned from payments api: [%d]: %s", e.status, e.body)
+	}
 	return fmt.Sprintf("unexpected status returned from payments api: [%d]", e.status)
 }
 
+// Retryable reports whether the status was classified as retryable.
+func (e *InvalidPaymentAPIResponse) Retryable() bool {
+	return e.outcome == OutcomeRetryable
+}
+
 // Payments implements the payments endpoints.
 type Payments interface {
-	RefundRequestPost(refundRequestURL string, patchBody data.RefundPostRequest, HTTPClient *http.Client, apiKey string) error
+	RefundRequestPost(ctx context.Context, refundRequestURL string, patchBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error
+	GetPayment(ctx context.Context, paymentURL string, HTTPClient *http.Client, apiKey string) (*data.PaymentResource, error)
 }
 
 // Payment implements the Payment Interface.
-type Payment struct{}
+type Payment struct {
+	Statuses *StatusClassifier
+}
+
+// New returns a new implementation of the Payment Interface which classifies
+// response statuses with the given classifier, or the default classifier if
+// it is nil.
+func New(statuses *StatusClassifier) *Payment {
+	if statuses == nil {
+		statuses = DefaultStatusClassifier()
+	}
+	return &Payment{Statuses: statuses}
+}
+
+// IdempotencyKey derives the idempotency key of a refund from the payment ID
+// and the refund amount and reference. The attempt number is deliberately not
+// part of the key, so every attempt at the same refund has the same key.
+func IdempotencyKey(paymentID string, body data.RefundPostRequest) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s|%d|%s", len(paymentID), paymentID, body.Amount, body.RefundReference)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewHTTPClient returns an http client for the payments api which gives up
+// connecting after connectTimeout, waiting for response headers after
+// readTimeout and on the whole request after timeout. A zero timeout means no
+// timeout.
+func NewHTTPClient(connectTimeout, readTimeout, timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	transport.ResponseHeaderTimeout = readTimeout
 
-// New returns a new implementation of the Payment Interface.
-func New() *Payment {
-	return &Payment{}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
 }
 
-// RefundRequestPost executes a POST request to the specified URL.
-func (impl *Payment) RefundRequestPost(patchURL string, patchBody data.RefundPostRequest, httpClient *http.Client, apiKey string) error {
+// RefundRequestPost executes a POST request to the specified URL. The request
+// is abandoned if ctx is cancelled. Statuses classified as a duplicate are
+// treated as success, as the refund has already been made.
+func (impl *Payment) RefundRequestPost(ctx context.Context, patchURL string, patchBody data.RefundPostRequest, idempotencyKey string, httpClient *http.Client, apiKey string) error {
 	jsonValue, err := json.Marshal(patchBody)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", patchURL, bytes.NewBuffer(jsonValue))
+	req, err := http.NewRequestWithContext(ctx, "POST", patchURL, bytes.NewBuffer(jsonValue))
 	if err != nil {
 		return err
 	}
 	req.SetBasicAuth(apiKey, "")
-	log.Trace("POST request to the refund request endpoint of the resource", log.Data{"Request": patchURL, "Body": patchBody})
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	log.Trace("POST request to the refund request endpoint of the resource", log.Data{"Request": patchURL, "Body": patchBody, "IdempotencyKey": idempotencyKey})
 
 	res, err := httpClient.Do(req)
 	if err != nil {
-		return err
+		return &TransportError{Err: err}
 	}
 
 	defer res.Body.Close()
+	recordStatus(ctx, res.StatusCode)
 
-	if res.StatusCode != http.StatusCreated {
-		return &InvalidPaymentAPIResponse{res.StatusCode}
+	switch outcome := impl.Statuses.Classify(res.StatusCode); outcome {
+	case OutcomeSuccess:
+		return nil
+	case OutcomeDuplicate:
+		log.Info("refund request already processed by payments api", log.Data{"Request": patchURL, "IdempotencyKey": idempotencyKey, "Status": res.StatusCode})
+		return nil
+	default:
+		return responseError(res, outcome)
 	}
+}
+
+// GetPayment executes a GET request for the payment resource at the specified
+// URL. The request is abandoned if ctx is cancelled. Any status other than 200
+// is a failure, retryable if classified as retryable.
+func (impl *Payment) GetPayment(ctx context.Context, paymentURL string, httpClient *http.Client, apiKey string) (*data.PaymentResource, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", paymentURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(apiKey, "")
+	log.Trace("GET request to the payment resource", log.Data{"Request": paymentURL})
 
-	return nil
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, &TransportError{Err: err}
+	}
+
+	defer res.Body.Close()
+	recordStatus(ctx, res.StatusCode)
+
+	if res.StatusCode != http.StatusOK {
+		outcome := impl.Statuses.Classify(res.StatusCode)
+		if outcome != OutcomeRetryable {
+			outcome = OutcomePermanent
+		}
+		return nil, responseError(res, outcome)
+	}
+
+	var resource data.PaymentResource
+	if err := json.NewDecoder(res.Body).Decode(&resource); err != nil {
+		return nil, fmt.Errorf("error decoding payment resource: %w", err)
+	}
+	return &resource, nil
+}
+
+// responseError returns the error describing a failed response.
+func responseError(res *http.Response, outcome Outcome) *InvalidPaymentAPIResponse {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	return &InvalidPaymentAPIResponse{
+		status:     res.StatusCode,
+		outcome:    outcome,
+		body:       strings.TrimSpace(string(body)),
+		retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter returns the delay given by a Retry-After header, either in
+// seconds or as an http date, or zero if there is none.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
 }
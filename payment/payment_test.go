@@ -1,9 +1,11 @@
 package payment
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/companieshouse/refund-request-consumer/data"
 	"github.com/stretchr/testify/assert"
@@ -22,12 +24,12 @@ func TestUnitInvalidPaymentAPIResponse_Error(t *testing.T) {
 }
 
 func TestUnitNew(t *testing.T) {
-	payment := New()
+	payment := New(nil)
 	assert.NotNil(t, payment)
 }
 
 func TestUnitRefundRequestPost_Success(t *testing.T) {
-	payment := New()
+	payment := New(nil)
 	mockClient := &http.Client{
 		Transport: roundTripFunc(func(req *http.Request) *http.Response {
 			recorder := httptest.NewRecorder()
@@ -36,12 +38,120 @@ func TestUnitRefundRequestPost_Success(t *testing.T) {
 		}),
 	}
 
-	err := payment.RefundRequestPost("http://example.com", mockRefundPostRequest, mockClient, "test-api-key")
+	err := payment.RefundRequestPost(context.Background(), "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key")
 	assert.NoError(t, err)
 }
 
+func TestUnitRefundRequestPost_Headers(t *testing.T) {
+	payment := New(nil)
+	var received *http.Request
+	mockClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			received = req
+			recorder := httptest.NewRecorder()
+			recorder.WriteHeader(http.StatusCreated)
+			return recorder.Result()
+		}),
+	}
+
+	err := payment.RefundRequestPost(context.Background(), "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "key", received.Header.Get(IdempotencyKeyHeader))
+	assert.Equal(t, "application/json", received.Header.Get("Content-Type"))
+}
+
+func TestUnitRefundRequestPost_Duplicate(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusConflict} {
+		payment := New(nil)
+		mockClient := &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				recorder := httptest.NewRecorder()
+				recorder.WriteHeader(status)
+				return recorder.Result()
+			}),
+		}
+
+		ctx, recorded := RecordStatus(context.Background())
+		err := payment.RefundRequestPost(ctx, "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key")
+		assert.NoError(t, err, "status %d", status)
+		assert.Equal(t, status, recorded())
+	}
+}
+
+func TestUnitRecordStatus(t *testing.T) {
+	ctx, recorded := RecordStatus(context.Background())
+	assert.Zero(t, recorded())
+
+	payment := New(nil)
+	mockClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			recorder := httptest.NewRecorder()
+			recorder.WriteHeader(http.StatusBadGateway)
+			return recorder.Result()
+		}),
+	}
+	assert.Error(t, payment.RefundRequestPost(ctx, "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key"))
+	assert.Equal(t, http.StatusBadGateway, recorded())
+
+	// A request without a recording context records nothing.
+	assert.Error(t, payment.RefundRequestPost(context.Background(), "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key"))
+}
+
+func TestUnitRefundRequestPost_Retryable(t *testing.T) {
+	payment := New(nil)
+	mockClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			recorder := httptest.NewRecorder()
+			recorder.WriteHeader(http.StatusServiceUnavailable)
+			return recorder.Result()
+		}),
+	}
+
+	err := payment.RefundRequestPost(context.Background(), "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key")
+	var invalid *InvalidPaymentAPIResponse
+	assert.ErrorAs(t, err, &invalid)
+	assert.True(t, invalid.Retryable())
+}
+
+func TestUnitRefundRequestPost_RetryAfter(t *testing.T) {
+	payment := New(nil)
+	mockClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			recorder := httptest.NewRecorder()
+			recorder.Header().Set("Retry-After", "7")
+			recorder.WriteHeader(http.StatusTooManyRequests)
+			return recorder.Result()
+		}),
+	}
+
+	err := payment.RefundRequestPost(context.Background(), "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key")
+	var invalid *InvalidPaymentAPIResponse
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, 7*time.Second, invalid.RetryAfter())
+}
+
+func TestUnitParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestUnitIdempotencyKey(t *testing.T) {
+	key := IdempotencyKey("pay1", mockRefundPostRequest)
+	assert.Len(t, key, 64)
+	assert.Equal(t, key, IdempotencyKey("pay1", mockRefundPostRequest))
+	assert.NotEqual(t, key, IdempotencyKey("pay2", mockRefundPostRequest))
+	assert.NotEqual(t, key, IdempotencyKey("pay1", data.RefundPostRequest{Amount: 101, RefundReference: "Test refund"}))
+	assert.NotEqual(t, key, IdempotencyKey("pay1", data.RefundPostRequest{Amount: 100, RefundReference: "Other refund"}))
+}
+
 func TestUnitRefundRequestPost_Failure(t *testing.T) {
-	payment := New()
+	payment := New(nil)
 	mockClient := &http.Client{
 		Transport: roundTripFunc(func(req *http.Request) *http.Response {
 			recorder := httptest.NewRecorder()
@@ -50,9 +160,55 @@ func TestUnitRefundRequestPost_Failure(t *testing.T) {
 		}),
 	}
 
-	err := payment.RefundRequestPost("http://example.com", mockRefundPostRequest, mockClient, "test-api-key")
+	err := payment.RefundRequestPost(context.Background(), "http://example.com", mockRefundPostRequest, "key", mockClient, "test-api-key")
 	assert.Error(t, err)
 	assert.IsType(t, &InvalidPaymentAPIResponse{}, err)
+	assert.False(t, err.(*InvalidPaymentAPIResponse).Retryable())
+}
+
+func TestUnitNewHTTPClient(t *testing.T) {
+	client := NewHTTPClient(time.Second, 2*time.Second, 3*time.Second)
+	assert.Equal(t, 3*time.Second, client.Timeout)
+
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 2*time.Second, transport.ResponseHeaderTimeout)
+}
+
+func TestUnitRefundRequestPost_Cancelled(t *testing.T) {
+	server := newHangingServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	err := New(nil).RefundRequestPost(ctx, server.URL, mockRefundPostRequest, "key", NewHTTPClient(0, 0, 0), "test-api-key")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, IsPermanent(err))
+}
+
+func TestUnitRefundRequestPost_Timeout(t *testing.T) {
+	server := newHangingServer(t)
+
+	err := New(nil).RefundRequestPost(context.Background(), server.URL, mockRefundPostRequest, "key", NewHTTPClient(0, 10*time.Millisecond, 0), "test-api-key")
+	class, ok := ClassOf(err)
+	assert.True(t, ok)
+	assert.Equal(t, ClassTimeout, class)
+}
+
+// newHangingServer returns a server which never responds to requests.
+func newHangingServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+	return server
 }
 
 // roundTripFunc is a helper function to mock http.Client
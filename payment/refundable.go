@@ -0,0 +1,70 @@
+package payment
+
+import (
+	"fmt"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/money"
+)
+
+// Statuses of payment and refund resources which are checked before a refund.
+const (
+	PaymentStatusPaid  = "paid"
+	RefundStatusFailed = "failed"
+)
+
+// NotRefundableError is returned when a refund cannot be made from a payment,
+// such as one which is not paid or has too little left to refund. It is never
+// retryable.
+type NotRefundableError struct {
+	PaymentID string
+	Reason    string
+}
+
+func (e *NotRefundableError) Error() string {
+	return fmt.Sprintf("payment [%s] cannot be refunded: %s", e.PaymentID, e.Reason)
+}
+
+// Class implements ClassifiedError.Class.
+func (e *NotRefundableError) Class() ErrorClass {
+	return ClassNotRefundable
+}
+
+// Retryable implements ClassifiedError.Retryable.
+func (e *NotRefundableError) Retryable() bool {
+	return false
+}
+
+// Refunded returns the pence refunded from a payment. Failed refunds are not
+// counted.
+func Refunded(p *data.PaymentResource) int64 {
+	var total int64
+	for _, r := range p.Refunds {
+		if r.Status != RefundStatusFailed {
+			total += int64(r.Amount)
+		}
+	}
+	return total
+}
+
+// CheckRefundable returns a NotRefundableError if a refund of amount pence
+// cannot be made from the payment. The remaining balance is only checked if
+// checkBalance is set.
+func CheckRefundable(paymentID string, p *data.PaymentResource, amount int64, checkBalance bool) error {
+	if p.Status != PaymentStatusPaid {
+		return &NotRefundableError{PaymentID: paymentID, Reason: fmt.Sprintf("payment status is [%s], not [%s]", p.Status, PaymentStatusPaid)}
+	}
+	if !checkBalance {
+		return nil
+	}
+
+	paid, err := money.NewParser().Parse(p.Amount)
+	if err != nil {
+		return &NotRefundableError{PaymentID: paymentID, Reason: fmt.Sprintf("payment amount cannot be read: %s", err)}
+	}
+	refunded := Refunded(p)
+	if remaining := paid - refunded; amount > remaining {
+		return &NotRefundableError{PaymentID: paymentID, Reason: fmt.Sprintf("refund of %d pence exceeds the %d pence remaining of %d pence paid", amount, max(remaining, 0), paid)}
+	}
+	return nil
+}
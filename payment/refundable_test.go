@@ -0,0 +1,92 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitGetPayment(t *testing.T) {
+	var received *http.Request
+	mockClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			received = req
+			recorder := httptest.NewRecorder()
+			recorder.WriteString(`{"amount":"12.50","status":"paid","refunds":[{"refund_id":"r1","amount":250,"status":"success"}]}`)
+			return recorder.Result()
+		}),
+	}
+
+	resource, err := New(nil).GetPayment(context.Background(), "http://example.com/payments/pay1", mockClient, "test-api-key")
+	require.NoError(t, err)
+	assert.Equal(t, &data.PaymentResource{
+		Amount:  "12.50",
+		Status:  "paid",
+		Refunds: []data.RefundResource{{RefundID: "r1", Amount: 250, Status: "success"}},
+	}, resource)
+	assert.Equal(t, "GET", received.Method)
+	user, _, _ := received.BasicAuth()
+	assert.Equal(t, "test-api-key", user)
+}
+
+func TestUnitGetPayment_Failure(t *testing.T) {
+	tests := []struct {
+		status    int
+		class     ErrorClass
+		retryable bool
+	}{
+		{http.StatusNotFound, ClassUnknownPayment, false},
+		{http.StatusConflict, ClassDuplicate, false},
+		{http.StatusServiceUnavailable, ClassServer, true},
+	}
+	for _, tt := range tests {
+		mockClient := &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				recorder := httptest.NewRecorder()
+				recorder.WriteHeader(tt.status)
+				return recorder.Result()
+			}),
+		}
+
+		ctx, recorded := RecordStatus(context.Background())
+		_, err := New(nil).GetPayment(ctx, "http://example.com/payments/pay1", mockClient, "test-api-key")
+		class, ok := ClassOf(err)
+		assert.True(t, ok, "status %d", tt.status)
+		assert.Equal(t, tt.class, class, "status %d", tt.status)
+		assert.Equal(t, !tt.retryable, IsPermanent(err), "status %d", tt.status)
+		assert.Equal(t, tt.status, recorded())
+	}
+}
+
+func TestUnitCheckRefundable(t *testing.T) {
+	paid := &data.PaymentResource{
+		Amount: "100.00",
+		Status: PaymentStatusPaid,
+		Refunds: []data.RefundResource{
+			{Amount: 6000, Status: "success"},
+			{Amount: 5000, Status: RefundStatusFailed},
+		},
+	}
+	assert.Equal(t, int64(6000), Refunded(paid))
+
+	assert.NoError(t, CheckRefundable("pay1", paid, 4000, true))
+
+	err := CheckRefundable("pay1", paid, 4001, true)
+	assert.EqualError(t, err, "payment [pay1] cannot be refunded: refund of 4001 pence exceeds the 4000 pence remaining of 10000 pence paid")
+	assert.True(t, IsPermanent(err))
+	class, _ := ClassOf(err)
+	assert.Equal(t, ClassNotRefundable, class)
+
+	assert.NoError(t, CheckRefundable("pay1", paid, 4001, false), "the balance is not checked")
+
+	pending := &data.PaymentResource{Amount: "100.00", Status: "pending"}
+	assert.EqualError(t, CheckRefundable("pay1", pending, 100, false), "payment [pay1] cannot be refunded: payment status is [pending], not [paid]")
+
+	unreadable := &data.PaymentResource{Amount: "lots", Status: PaymentStatusPaid}
+	assert.Error(t, CheckRefundable("pay1", unreadable, 100, true))
+}
@@ -0,0 +1,117 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Outcome is the meaning of a status code returned by the payments api.
+type Outcome int
+
+// Outcomes of a refund request. A duplicate is a request the payments api
+// has already processed under the same idempotency key, so it is treated as
+// a success.
+const (
+	OutcomePermanent Outcome = iota
+	OutcomeSuccess
+	OutcomeDuplicate
+	OutcomeRetryable
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeSuccess:
+		return "success"
+	case OutcomeDuplicate:
+		return "duplicate"
+	case OutcomeRetryable:
+		return "retryable"
+	default:
+		return "permanent"
+	}
+}
+
+// Default status codes for each outcome.
+var (
+	DefaultSuccessStatuses   = []int{201}
+	DefaultDuplicateStatuses = []int{200, 409}
+	DefaultRetryableStatuses = []int{408, 429, 500, 502, 503, 504}
+)
+
+// StatusClassifier maps payments api status codes to outcomes. Any status
+// not listed is a permanent failure.
+type StatusClassifier struct {
+	outcomes map[int]Outcome
+}
+
+// NewStatusClassifier returns a StatusClassifier for the given status codes.
+// A status may only be given one outcome.
+func NewStatusClassifier(success, duplicate, retryable []int) (*StatusClassifier, error) {
+	c := &StatusClassifier{outcomes: make(map[int]Outcome)}
+
+	for outcome, statuses := range map[Outcome][]int{
+		OutcomeSuccess:   success,
+		OutcomeDuplicate: duplicate,
+		OutcomeRetryable: retryable,
+	} {
+		for _, status := range statuses {
+			if existing, ok := c.outcomes[status]; ok {
+				return nil, fmt.Errorf("status [%d] classified as both %s and %s", status, existing, outcome)
+			}
+			c.outcomes[status] = outcome
+		}
+	}
+
+	return c, nil
+}
+
+// DefaultStatusClassifier returns a StatusClassifier for the default status
+// codes.
+func DefaultStatusClassifier() *StatusClassifier {
+	c, _ := NewStatusClassifier(DefaultSuccessStatuses, DefaultDuplicateStatuses, DefaultRetryableStatuses)
+	return c
+}
+
+// Classify returns the outcome of a status code.
+func (c *StatusClassifier) Classify(status int) Outcome {
+	return c.outcomes[status]
+}
+
+// ParseStatuses parses status codes given as configuration values, each of
+// which may itself be a comma separated list, such as ["200,409"].
+func ParseStatuses(values []string) ([]int, error) {
+	var statuses []int
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			status, err := strconv.Atoi(s)
+			if err != nil || status < 100 || status > 599 {
+				return nil, fmt.Errorf("invalid http status [%s]", s)
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+type statusKey struct{}
+
+// RecordStatus returns a context in which the status of a payments api
+// response is recorded, and a func returning the status, or zero if no
+// response has been received.
+func RecordStatus(ctx context.Context) (context.Context, func() int) {
+	status := new(atomic.Int32)
+	return context.WithValue(ctx, statusKey{}, status), func() int { return int(status.Load()) }
+}
+
+func recordStatus(ctx context.Context, code int) {
+	if status, ok := ctx.Value(statusKey{}).(*atomic.Int32); ok {
+		status.Store(int32(code))
+	}
+}
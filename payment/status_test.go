@@ -0,0 +1,42 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitStatusClassifier(t *testing.T) {
+	c := DefaultStatusClassifier()
+
+	assert.Equal(t, OutcomeSuccess, c.Classify(201))
+	assert.Equal(t, OutcomeDuplicate, c.Classify(200))
+	assert.Equal(t, OutcomeDuplicate, c.Classify(409))
+	assert.Equal(t, OutcomeRetryable, c.Classify(503))
+	assert.Equal(t, OutcomePermanent, c.Classify(400))
+	assert.Equal(t, OutcomePermanent, c.Classify(404))
+}
+
+func TestUnitNewStatusClassifier_Overlap(t *testing.T) {
+	_, err := NewStatusClassifier([]int{201}, []int{409}, []int{409})
+	assert.Error(t, err)
+}
+
+func TestUnitParseStatuses(t *testing.T) {
+	statuses, err := ParseStatuses([]string{"200, 409", "", "201"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{200, 409, 201}, statuses)
+
+	_, err = ParseStatuses([]string{"20x"})
+	assert.Error(t, err)
+
+	_, err = ParseStatuses([]string{"600"})
+	assert.Error(t, err)
+}
+
+func TestUnitOutcome_String(t *testing.T) {
+	assert.Equal(t, "success", OutcomeSuccess.String())
+	assert.Equal(t, "duplicate", OutcomeDuplicate.String())
+	assert.Equal(t, "retryable", OutcomeRetryable.String())
+	assert.Equal(t, "permanent", OutcomePermanent.String())
+}
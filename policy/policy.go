@@ -0,0 +1,167 @@
+// Package policy decides whether refunds may be submitted to the payments
+// api. It limits the size of a single refund and the totals refunded for one
+// payment in a day and across all payments in an hour, so that an upstream bug
+// cannot refund large amounts unnoticed.
+package policy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Action is what is decided for a refund.
+type Action string
+
+// Actions decided for a refund. A held refund is only submitted once approved
+// by an operator.
+const (
+	Allow Action = "allow"
+	Hold  Action = "hold"
+)
+
+// Windows of the totals.
+const (
+	PaymentWindow   = 24 * time.Hour
+	AggregateWindow = time.Hour
+)
+
+// Limits are the policy rules, in pence. A limit of zero is not applied. The
+// largest refund which can be submitted at all is a validation rule, not a
+// policy limit.
+type Limits struct {
+	// HoldAbove is the largest refund submitted without approval.
+	HoldAbove int64
+	// DailyPerPayment is the most refunded for one payment in a day without
+	// approval.
+	DailyPerPayment int64
+	// Hourly is the most refunded across all payments in an hour without
+	// approval.
+	Hourly int64
+}
+
+// Enabled reports whether any limit is applied.
+func (l Limits) Enabled() bool {
+	return l.HoldAbove > 0 || l.DailyPerPayment > 0 || l.Hourly > 0
+}
+
+// Totals reports whether a limit on the totals refunded is applied, which
+// needs the refunds made by every consumer to be counted.
+func (l Limits) Totals() bool {
+	return l.DailyPerPayment > 0 || l.Hourly > 0
+}
+
+// Decision is the action decided for a refund and the reason for it.
+type Decision struct {
+	Action Action
+	Reason string
+
+	entry entry
+}
+
+// entry is a refund counted towards the totals.
+type entry struct {
+	key       string
+	paymentID string
+	at        time.Time
+	amount    int64
+}
+
+// Engine applies the limits, keeping the refunds made in the last day to work
+// out the totals. Refunds are counted by their idempotency key, so that a
+// refund recorded both when it is admitted and when it is read back from kafka
+// is only counted once.
+type Engine struct {
+	Limits Limits
+
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// New returns an engine applying the limits.
+func New(limits Limits) *Engine {
+	return &Engine{
+		Limits:  limits,
+		now:     time.Now,
+		entries: map[string]entry{},
+	}
+}
+
+// Admit decides whether a refund of amount pence with the idempotency key may
+// be submitted for the payment. An allowed refund is counted towards the
+// totals at once, so that refunds processed concurrently cannot together
+// exceed them; Cancel stops it being counted if it is not submitted. A refund
+// already counted is allowed, as it has already been submitted.
+func (e *Engine) Admit(key, paymentID string, amount int64) Decision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	e.prune(now)
+
+	if _, ok := e.entries[key]; ok {
+		return Decision{Action: Allow}
+	}
+
+	l := e.Limits
+	switch {
+	case l.HoldAbove > 0 && amount > l.HoldAbove:
+		return Decision{Action: Hold, Reason: fmt.Sprintf("refund of %d pence exceeds the approval threshold of %d pence", amount, l.HoldAbove)}
+	case l.DailyPerPayment > 0 && e.total(paymentID, now.Add(-PaymentWindow))+amount > l.DailyPerPayment:
+		return Decision{Action: Hold, Reason: fmt.Sprintf("refunds of payment [%s] would exceed %d pence in %s", paymentID, l.DailyPerPayment, PaymentWindow)}
+	case l.Hourly > 0 && e.total("", now.Add(-AggregateWindow))+amount > l.Hourly:
+		return Decision{Action: Hold, Reason: fmt.Sprintf("refunds would exceed %d pence in %s", l.Hourly, AggregateWindow)}
+	}
+
+	d := Decision{Action: Allow, entry: entry{key: key, paymentID: paymentID, at: now, amount: amount}}
+	e.entries[key] = d.entry
+	return d
+}
+
+// Record counts a refund submitted at the given time without being admitted,
+// such as an approved hold or one submitted by another consumer, towards the
+// totals. A refund already counted is not counted again.
+func (e *Engine) Record(key, paymentID string, amount int64, at time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.entries[key]; ok || !at.After(e.now().Add(-PaymentWindow)) {
+		return
+	}
+	e.entries[key] = entry{key: key, paymentID: paymentID, at: at, amount: amount}
+}
+
+// Cancel stops counting an allowed refund which was not submitted.
+func (e *Engine) Cancel(d Decision) {
+	if d.Action != Allow || d.entry.key == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.entries[d.entry.key] == d.entry {
+		delete(e.entries, d.entry.key)
+	}
+}
+
+// total returns the amount refunded for the payment, or for every payment if
+// paymentID is empty, after start.
+func (e *Engine) total(paymentID string, start time.Time) int64 {
+	var t int64
+	for _, en := range e.entries {
+		if (paymentID == "" || en.paymentID == paymentID) && en.at.After(start) {
+			t += en.amount
+		}
+	}
+	return t
+}
+
+// prune forgets the refunds outside the longest window.
+func (e *Engine) prune(now time.Time) {
+	for key, en := range e.entries {
+		if !en.at.After(now.Add(-PaymentWindow)) {
+			delete(e.entries, key)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEngine(limits Limits) (*Engine, *time.Time) {
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	e := New(limits)
+	e.now = func() time.Time { return now }
+	return e, &now
+}
+
+func TestUnitLimits(t *testing.T) {
+	assert.False(t, Limits{}.Enabled())
+	assert.True(t, Limits{HoldAbove: 1}.Enabled())
+	assert.True(t, Limits{Hourly: 1}.Enabled())
+
+	assert.False(t, Limits{HoldAbove: 1}.Totals())
+	assert.True(t, Limits{DailyPerPayment: 1}.Totals())
+	assert.True(t, Limits{Hourly: 1}.Totals())
+}
+
+func TestUnitThreshold(t *testing.T) {
+	e, _ := newTestEngine(Limits{HoldAbove: 50000})
+
+	assert.Equal(t, Allow, e.Admit("K1", "P1", 50000).Action)
+
+	d := e.Admit("K2", "P1", 50001)
+	assert.Equal(t, Hold, d.Action)
+	assert.Equal(t, "refund of 50001 pence exceeds the approval threshold of 50000 pence", d.Reason)
+}
+
+func TestUnitDailyPerPayment(t *testing.T) {
+	e, now := newTestEngine(Limits{DailyPerPayment: 1000})
+
+	assert.Equal(t, Allow, e.Admit("K1", "P1", 600).Action)
+	assert.Equal(t, Allow, e.Admit("K2", "P2", 600).Action, "other payments have their own total")
+	d := e.Admit("K3", "P1", 500)
+	assert.Equal(t, Hold, d.Action)
+	assert.Contains(t, d.Reason, "payment [P1]")
+	assert.Equal(t, Allow, e.Admit("K1", "P1", 600).Action, "a refund already counted is not counted again")
+
+	*now = now.Add(PaymentWindow)
+	assert.Equal(t, Allow, e.Admit("K4", "P1", 1000).Action, "refunds leave the total after a day")
+}
+
+func TestUnitHourly(t *testing.T) {
+	e, now := newTestEngine(Limits{Hourly: 1000})
+
+	allowed := e.Admit("K1", "P1", 600)
+	assert.Equal(t, Allow, allowed.Action)
+	assert.Equal(t, Hold, e.Admit("K2", "P2", 500).Action)
+
+	e.Cancel(allowed)
+	assert.Equal(t, Allow, e.Admit("K2", "P2", 500).Action, "a cancelled refund is not counted")
+
+	e.Record("K3", "P3", 500, *now)
+	assert.Equal(t, Hold, e.Admit("K4", "P4", 1).Action, "recorded refunds are counted")
+
+	*now = now.Add(AggregateWindow)
+	assert.Equal(t, Allow, e.Admit("K4", "P4", 1000).Action)
+}
+
+func TestUnitRecord(t *testing.T) {
+	e, now := newTestEngine(Limits{DailyPerPayment: 1000})
+
+	assert.Equal(t, Allow, e.Admit("K1", "P1", 600).Action)
+	e.Record("K1", "P1", 600, *now)
+	assert.Equal(t, Allow, e.Admit("K2", "P1", 400).Action, "a refund recorded after being admitted is counted once")
+
+	e.Record("K3", "P1", 1, now.Add(-2*AggregateWindow))
+	d := e.Admit("K4", "P1", 1)
+	assert.Equal(t, Hold, d.Action, "refunds recorded earlier in the day count towards the payment's total")
+	assert.Contains(t, d.Reason, "payment [P1]")
+
+	e.Record("K5", "P2", 1000, now.Add(-PaymentWindow))
+	assert.Equal(t, Allow, e.Admit("K6", "P2", 1000).Action, "refunds older than a day are not recorded")
+}
@@ -0,0 +1,256 @@
+package registry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var errShortBuffer = errors.New("avro data is truncated")
+
+// decoder reads avro binary data written with one schema as values of another.
+// Records are read as map[string]interface{}, keyed by field name, and the
+// other types as their natural go types.
+type decoder struct {
+	buf []byte
+}
+
+// read reads a value written with the writer type, returning it as the reader
+// type, which must be readable from the writer's.
+func (d *decoder) read(writer, reader *schemaType) (interface{}, error) {
+	if writer.Type == "union" {
+		index, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || int(index) >= len(writer.Branches) {
+			return nil, fmt.Errorf("union branch %d out of range", index)
+		}
+		return d.read(writer.Branches[index], reader)
+	}
+	if reader.Type == "union" {
+		branch := readerBranch(writer, reader)
+		if branch == nil {
+			return nil, fmt.Errorf("no branch of the union can read a written %s", writer.Type)
+		}
+		return d.read(writer, branch)
+	}
+
+	switch writer.Type {
+	case "null":
+		return nil, nil
+	case "boolean":
+		if len(d.buf) < 1 {
+			return nil, errShortBuffer
+		}
+		b := d.buf[0] != 0
+		d.buf = d.buf[1:]
+		return b, nil
+	case "int", "long":
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		return promote(n, reader.Type), nil
+	case "float":
+		if len(d.buf) < 4 {
+			return nil, errShortBuffer
+		}
+		f := math.Float32frombits(binary.LittleEndian.Uint32(d.buf))
+		d.buf = d.buf[4:]
+		if reader.Type == "double" {
+			return float64(f), nil
+		}
+		return f, nil
+	case "double":
+		if len(d.buf) < 8 {
+			return nil, errShortBuffer
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+		return f, nil
+	case "bytes", "string":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if reader.Type == "string" {
+			return string(b), nil
+		}
+		return b, nil
+	case "fixed":
+		if len(d.buf) < writer.Size {
+			return nil, errShortBuffer
+		}
+		b := append([]byte(nil), d.buf[:writer.Size]...)
+		d.buf = d.buf[writer.Size:]
+		return b, nil
+	case "enum":
+		return d.enum(writer, reader)
+	case "array":
+		var items []interface{}
+		err := d.blocks(func() error {
+			item, err := d.read(writer.Items, reader.Items)
+			items = append(items, item)
+			return err
+		})
+		return items, err
+	case "map":
+		values := map[string]interface{}{}
+		err := d.blocks(func() error {
+			key, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			values[string(key)], err = d.read(writer.Values, reader.Values)
+			return err
+		})
+		return values, err
+	case "record":
+		return d.record(writer, reader)
+	}
+	return nil, fmt.Errorf("unsupported avro type [%s]", writer.Type)
+}
+
+// record reads the writer's fields in order, keeping those in the reader and
+// filling the reader's other fields with their defaults.
+func (d *decoder) record(writer, reader *schemaType) (interface{}, error) {
+	readerFields := make(map[string]field, len(reader.Fields))
+	for _, f := range reader.Fields {
+		readerFields[f.Name] = f
+	}
+
+	values := make(map[string]interface{}, len(reader.Fields))
+	for _, w := range writer.Fields {
+		r, ok := readerFields[w.Name]
+		if !ok {
+			// Skip a field the reader does not know by reading it as written.
+			r.Type = w.Type
+		}
+		value, err := d.read(w.Type, r.Type)
+		if err != nil {
+			return nil, fmt.Errorf("error reading field [%s]: %w", w.Name, err)
+		}
+		if ok {
+			values[w.Name] = value
+		}
+	}
+
+	for _, r := range reader.Fields {
+		if _, ok := values[r.Name]; ok || !r.HasDefault {
+			continue
+		}
+		value, err := defaultValue(r)
+		if err != nil {
+			return nil, err
+		}
+		values[r.Name] = value
+	}
+	return values, nil
+}
+
+func (d *decoder) enum(writer, reader *schemaType) (interface{}, error) {
+	index, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || int(index) >= len(writer.Symbols) {
+		return nil, fmt.Errorf("enum symbol %d out of range", index)
+	}
+	symbol := writer.Symbols[index]
+	for _, s := range reader.Symbols {
+		if s == symbol {
+			return symbol, nil
+		}
+	}
+	return nil, fmt.Errorf("enum symbol [%s] is not in the reader's enum [%s]", symbol, reader.Name)
+}
+
+// blocks reads the blocks of an array or map, calling item for each item.
+func (d *decoder) blocks(item func() error) error {
+	for {
+		count, err := d.long()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			// A negative count is followed by the block's size in bytes.
+			count = -count
+			if _, err := d.long(); err != nil {
+				return err
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			if err := item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *decoder) long() (int64, error) {
+	n, size := binary.Varint(d.buf)
+	if size <= 0 {
+		return 0, errShortBuffer
+	}
+	d.buf = d.buf[size:]
+	return n, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	length, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || int64(len(d.buf)) < length {
+		return nil, errShortBuffer
+	}
+	b := d.buf[:length]
+	d.buf = d.buf[length:]
+	return b, nil
+}
+
+// promote returns an int or long as the reader's numeric type.
+func promote(n int64, reader string) interface{} {
+	switch reader {
+	case "int":
+		return int32(n)
+	case "float":
+		return float32(n)
+	case "double":
+		return float64(n)
+	}
+	return n
+}
+
+// defaultValue returns the default of a field missing from the writer. The
+// default of a union is of its first branch.
+func defaultValue(f field) (interface{}, error) {
+	t := f.Type
+	if t.Type == "union" && len(t.Branches) > 0 {
+		t = t.Branches[0]
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(f.Default, &value); err != nil {
+		return nil, fmt.Errorf("invalid default of field [%s]: %w", f.Name, err)
+	}
+	number, isNumber := value.(float64)
+	switch {
+	case t.Type == "int" && isNumber:
+		return int32(number), nil
+	case t.Type == "long" && isNumber:
+		return int64(number), nil
+	case t.Type == "float" && isNumber:
+		return float32(number), nil
+	case (t.Type == "bytes" || t.Type == "fixed") && value != nil:
+		s, _ := value.(string)
+		return []byte(s), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,169 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// SchemaSource returns schemas by their schema registry ID. It is satisfied by
+// Client.
+type SchemaSource interface {
+	SchemaByID(id int32) (string, error)
+}
+
+// Unmarshaler decodes a message into a struct. It is satisfied by
+// avro.Schema.
+type Unmarshaler interface {
+	Unmarshal(message []byte, v interface{}) error
+}
+
+// Decoder decodes messages written with any schema compatible with its reader
+// schema. Messages not in the wire format are passed to the fallback, which
+// decodes messages published without a schema ID. As such a message can also
+// start with a zero byte, such as an attempt of zero, a message that cannot be
+// decoded in the wire format is passed to the fallback too.
+type Decoder struct {
+	Schemas  SchemaSource
+	Fallback Unmarshaler
+
+	reader  *schemaType
+	mu      sync.Mutex
+	writers map[int32]*schemaType
+}
+
+// NewDecoder returns a decoder reading messages as the reader schema.
+func NewDecoder(schemas SchemaSource, reader string, fallback Unmarshaler) (*Decoder, error) {
+	readerSchema, err := parseSchema(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing reader schema: %w", err)
+	}
+	if readerSchema.Type != "record" {
+		return nil, fmt.Errorf("reader schema must be a record, not %s", readerSchema.Type)
+	}
+	return &Decoder{Schemas: schemas, Fallback: fallback, reader: readerSchema, writers: map[int32]*schemaType{}}, nil
+}
+
+// Unmarshal decodes a message into v, a pointer to a struct whose fields are
+// tagged with the avro names of the reader schema's fields. Fields the writer
+// schema does not have are set to their defaults, and those the reader schema
+// does not have are ignored.
+func (d *Decoder) Unmarshal(message []byte, v interface{}) error {
+	id, data, err := Split(message)
+	if err != nil {
+		return d.fallback(message, v, err)
+	}
+
+	writer, err := d.writer(id)
+	if err != nil {
+		return d.fallback(message, v, err)
+	}
+
+	value, err := (&decoder{buf: data}).read(writer, d.reader)
+	if err != nil {
+		return d.fallback(message, v, fmt.Errorf("error decoding message written with schema [%d]: %w", id, err))
+	}
+	return assign(value.(map[string]interface{}), v)
+}
+
+// fallback decodes a message as one published without a schema ID, returning
+// cause if there is no fallback. If the fallback cannot decode it either, both
+// errors are returned.
+func (d *Decoder) fallback(message []byte, v interface{}, cause error) error {
+	if d.Fallback == nil {
+		return cause
+	}
+	if errors.Is(cause, ErrNotWireFormat) {
+		return d.Fallback.Unmarshal(message, v)
+	}
+	if err := d.Fallback.Unmarshal(message, v); err != nil {
+		return fmt.Errorf("%w, and as a message without a schema ID: %v", cause, err)
+	}
+	return nil
+}
+
+// writer returns the writer schema with the given ID, checking once that it
+// can be read as the reader schema.
+func (d *Decoder) writer(id int32) (*schemaType, error) {
+	d.mu.Lock()
+	writer, ok := d.writers[id]
+	d.mu.Unlock()
+	if ok {
+		return writer, nil
+	}
+
+	definition, err := d.Schemas.SchemaByID(id)
+	if err != nil {
+		return nil, err
+	}
+	writer, err = parseSchema(definition)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing writer schema [%d]: %w", id, err)
+	}
+	if writer.Type != "record" {
+		return nil, fmt.Errorf("writer schema [%d] must be a record, not %s", id, writer.Type)
+	}
+	if err := resolvable(writer, d.reader); err != nil {
+		return nil, fmt.Errorf("writer schema [%d] cannot be read as the reader schema: %w", id, err)
+	}
+
+	d.mu.Lock()
+	d.writers[id] = writer
+	d.mu.Unlock()
+	return writer, nil
+}
+
+// assign sets the fields of the struct v points to from the record values,
+// matching them by avro tag. Null values leave fields unset.
+func assign(values map[string]interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot decode into %T, expected a pointer to a struct", v)
+	}
+	rv = rv.Elem()
+
+	for i := 0; i < rv.NumField(); i++ {
+		name := strings.Split(rv.Type().Field(i).Tag.Get("avro"), ",")[0]
+		value, ok := values[name]
+		if name == "" || !ok || value == nil {
+			continue
+		}
+		if err := set(rv.Field(i), reflect.ValueOf(value)); err != nil {
+			return fmt.Errorf("error decoding field [%s]: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func set(dest, value reflect.Value) error {
+	if dest.Kind() == reflect.Ptr {
+		elem := reflect.New(dest.Type().Elem())
+		if err := set(elem.Elem(), value); err != nil {
+			return err
+		}
+		dest.Set(elem)
+		return nil
+	}
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8 && dest.Kind() == reflect.String {
+		value = reflect.ValueOf(string(value.Bytes()))
+	}
+	if kindOf(value.Kind()) != kindOf(dest.Kind()) || !value.Type().ConvertibleTo(dest.Type()) {
+		return fmt.Errorf("cannot decode %s into %s", value.Type(), dest.Type())
+	}
+	dest.Set(value.Convert(dest.Type()))
+	return nil
+}
+
+// kindOf groups kinds that values can be converted between without changing
+// their meaning.
+func kindOf(k reflect.Kind) reflect.Kind {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int64
+	case reflect.Float32:
+		return reflect.Float64
+	}
+	return k
+}
@@ -0,0 +1,233 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/companieshouse/chs.go/avro"
+	"github.com/companieshouse/chs.go/avro/schema"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/health"
+)
+
+// Sources of a provider's schema.
+const (
+	SourceRegistry = "registry"
+	SourceCache    = "cache"
+	SourceFallback = "fallback"
+)
+
+// Provider provides the latest schema of a subject in the schema registry.
+// The last schema fetched is cached on disk, so that if the registry is
+// unavailable at startup the cached schema, or failing that the embedded
+// fallback, is used until the registry can be reached again.
+type Provider struct {
+	Subject string
+	URL     string
+	// CacheDir is the directory of the on-disk cache, which is not used if
+	// empty.
+	CacheDir string
+	// Fallback is the schema used if neither the registry nor the cache can
+	// provide one, if not empty.
+	Fallback string
+	// MaxAge is how long the schema can go without being fetched from the
+	// registry before its check fails. It is not limited if zero.
+	MaxAge time.Duration
+	// Fetch returns the latest schema of a subject from the registry.
+	Fetch func(url, subject string) (string, error)
+
+	now        func() time.Time
+	mu         sync.RWMutex
+	definition string
+	source     string
+	fetched    time.Time
+	lastErr    error
+}
+
+// NewProvider returns a provider of the subject's schema from the registry at
+// url.
+func NewProvider(url, subject, cacheDir, fallback string, maxAge time.Duration) *Provider {
+	return &Provider{
+		Subject:  subject,
+		URL:      url,
+		CacheDir: cacheDir,
+		Fallback: fallback,
+		MaxAge:   maxAge,
+		Fetch:    schema.Get,
+		now:      time.Now,
+	}
+}
+
+// Load fetches the schema from the registry, falling back to the on-disk
+// cache and then the embedded fallback. It returns an error only if none of
+// them provide a schema.
+func (p *Provider) Load() error {
+	err := p.Refresh()
+	if err == nil {
+		return nil
+	}
+	logData := log.Data{"subject": p.Subject}
+
+	if definition, modified, cacheErr := p.readCache(); cacheErr == nil {
+		log.Error(fmt.Errorf("error fetching %s schema, using the cached schema: %w", p.Subject, err), logData)
+		p.set(definition, SourceCache, modified)
+		return nil
+	} else if p.CacheDir != "" && !errors.Is(cacheErr, os.ErrNotExist) {
+		log.Error(cacheErr, logData)
+	}
+
+	if p.Fallback != "" {
+		log.Error(fmt.Errorf("error fetching %s schema, using the embedded schema: %w", p.Subject, err), logData)
+		p.set(p.Fallback, SourceFallback, time.Time{})
+		return nil
+	}
+	return err
+}
+
+// Refresh fetches the schema from the registry and caches it on disk. The
+// schema is left unchanged if the fetch fails.
+func (p *Provider) Refresh() error {
+	definition, err := p.Fetch(p.URL, p.Subject)
+	if err == nil && !json.Valid([]byte(definition)) {
+		err = errors.New("schema is not valid json")
+	}
+	if err != nil {
+		err = fmt.Errorf("error receiving %s schema: %w", p.Subject, err)
+		p.mu.Lock()
+		p.lastErr = err
+		p.mu.Unlock()
+		return err
+	}
+
+	p.set(definition, SourceRegistry, p.now())
+	if err := p.writeCache(definition); err != nil {
+		log.Error(err, log.Data{"subject": p.Subject})
+	}
+	return nil
+}
+
+// Run refreshes the schema every interval until ctx is done.
+func (p *Provider) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Refresh(); err != nil {
+				log.Error(err, log.Data{"subject": p.Subject})
+			}
+		}
+	}
+}
+
+// Definition returns the current schema.
+func (p *Provider) Definition() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.definition
+}
+
+// Marshal encodes v with the current schema.
+func (p *Provider) Marshal(v interface{}) ([]byte, error) {
+	return (&avro.Schema{Definition: p.Definition()}).Marshal(v)
+}
+
+// Unmarshal decodes a message with the current schema.
+func (p *Provider) Unmarshal(message []byte, v interface{}) error {
+	return (&avro.Schema{Definition: p.Definition()}).Unmarshal(message, v)
+}
+
+// Check reports where the schema came from and how long ago it was fetched
+// from the registry, failing if there is no schema or it is older than the
+// maximum age.
+func (p *Provider) Check() health.Check {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	data := map[string]interface{}{"source": p.source}
+	if !p.fetched.IsZero() {
+		data["age_seconds"] = int64(p.now().Sub(p.fetched).Seconds())
+	}
+	if p.lastErr != nil {
+		data["last_error"] = p.lastErr.Error()
+	}
+
+	switch {
+	case p.definition == "":
+		return health.Check{Status: health.StatusFail, Detail: "no " + p.Subject + " schema loaded", Data: data}
+	case p.MaxAge > 0 && (p.fetched.IsZero() || p.now().Sub(p.fetched) > p.MaxAge):
+		return health.Check{Status: health.StatusFail, Detail: fmt.Sprintf("%s schema not fetched from the registry for over %s", p.Subject, p.MaxAge), Data: data}
+	}
+	return health.Check{Status: health.StatusOK, Data: data}
+}
+
+func (p *Provider) set(definition, source string, fetched time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.definition, p.source, p.fetched = definition, source, fetched
+	if source == SourceRegistry {
+		p.lastErr = nil
+	}
+}
+
+func (p *Provider) cachePath() string {
+	return filepath.Join(p.CacheDir, p.Subject+".avsc")
+}
+
+// readCache returns the cached schema and when it was fetched.
+func (p *Provider) readCache() (string, time.Time, error) {
+	if p.CacheDir == "" {
+		return "", time.Time{}, os.ErrNotExist
+	}
+	b, err := os.ReadFile(p.cachePath())
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error reading cached %s schema: %w", p.Subject, err)
+	}
+	if !json.Valid(b) {
+		return "", time.Time{}, fmt.Errorf("cached %s schema is not valid json", p.Subject)
+	}
+	info, err := os.Stat(p.cachePath())
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error reading cached %s schema: %w", p.Subject, err)
+	}
+	return string(b), info.ModTime(), nil
+}
+
+// writeCache replaces the cached schema, writing to a temporary file first so
+// that a partly written schema is never read.
+func (p *Provider) writeCache(definition string) error {
+	if p.CacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(p.CacheDir, 0o755); err != nil {
+		return fmt.Errorf("error creating schema cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(p.CacheDir, p.Subject+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error caching %s schema: %w", p.Subject, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(definition); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error caching %s schema: %w", p.Subject, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error caching %s schema: %w", p.Subject, err)
+	}
+	if err := os.Rename(tmp.Name(), p.cachePath()); err != nil {
+		return fmt.Errorf("error caching %s schema: %w", p.Subject, err)
+	}
+	return nil
+}
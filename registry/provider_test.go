@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRegistry struct {
+	mu         sync.Mutex
+	definition string
+	err        error
+}
+
+func (f *fakeRegistry) fetch(url, subject string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.definition, f.err
+}
+
+func (f *fakeRegistry) set(definition string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.definition, f.err = definition, err
+}
+
+func newTestProvider(registry *fakeRegistry, cacheDir, fallback string) *Provider {
+	p := NewProvider("http://registry", "refund-request", cacheDir, fallback, time.Hour)
+	p.Fetch = registry.fetch
+	return p
+}
+
+func TestUnitProviderLoad(t *testing.T) {
+	unavailable := errors.New("registry unavailable")
+
+	t.Run("the schema is fetched from the registry and cached", func(t *testing.T) {
+		dir := t.TempDir()
+		p := newTestProvider(&fakeRegistry{definition: v1}, dir, "")
+
+		require.NoError(t, p.Load())
+		assert.Equal(t, v1, p.Definition())
+		assert.Equal(t, SourceRegistry, p.Check().Data["source"])
+
+		cached, err := os.ReadFile(filepath.Join(dir, "refund-request.avsc"))
+		require.NoError(t, err)
+		assert.Equal(t, v1, string(cached))
+	})
+
+	t.Run("the cached schema is used when the registry is unavailable", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "refund-request.avsc"), []byte(v3), 0o644))
+		p := newTestProvider(&fakeRegistry{err: unavailable}, dir, embedded)
+
+		require.NoError(t, p.Load())
+		assert.Equal(t, v3, p.Definition())
+		check := p.Check()
+		assert.Equal(t, SourceCache, check.Data["source"])
+		assert.Contains(t, check.Data, "age_seconds")
+		assert.Contains(t, check.Data["last_error"], "registry unavailable")
+	})
+
+	t.Run("the fallback is used when neither the registry nor a valid cache is available", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "refund-request.avsc"), []byte("{truncated"), 0o644))
+		p := newTestProvider(&fakeRegistry{err: unavailable}, dir, embedded)
+
+		require.NoError(t, p.Load())
+		assert.Equal(t, embedded, p.Definition())
+		assert.Equal(t, SourceFallback, p.Check().Data["source"])
+	})
+
+	t.Run("loading fails when nothing provides a schema", func(t *testing.T) {
+		p := newTestProvider(&fakeRegistry{err: unavailable}, "", "")
+
+		assert.ErrorIs(t, p.Load(), unavailable)
+		assert.False(t, p.Check().OK())
+	})
+
+	t.Run("an invalid schema from the registry is not used", func(t *testing.T) {
+		p := newTestProvider(&fakeRegistry{definition: "not json"}, "", embedded)
+
+		require.NoError(t, p.Load())
+		assert.Equal(t, embedded, p.Definition())
+	})
+}
+
+// embedded is an embedded fallback schema.
+const embedded = `{"type":"record","name":"refund_request","fields":[]}`
+
+func TestUnitProviderRefresh(t *testing.T) {
+	registry := &fakeRegistry{definition: v1}
+	p := newTestProvider(registry, t.TempDir(), "")
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	require.NoError(t, p.Load())
+
+	registry.set("", errors.New("registry unavailable"))
+	now = now.Add(30 * time.Minute)
+	assert.Error(t, p.Refresh())
+	assert.Equal(t, v1, p.Definition(), "the last known schema is kept")
+	check := p.Check()
+	assert.True(t, check.OK())
+	assert.Equal(t, int64(1800), check.Data["age_seconds"])
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, health.StatusFail, p.Check().Status, "the schema is older than the maximum age")
+
+	registry.set(v3, nil)
+	require.NoError(t, p.Refresh())
+	assert.Equal(t, v3, p.Definition())
+	check = p.Check()
+	assert.True(t, check.OK())
+	assert.NotContains(t, check.Data, "last_error")
+}
+
+func TestUnitProviderRun(t *testing.T) {
+	registry := &fakeRegistry{definition: v1}
+	p := newTestProvider(registry, "", "")
+	require.NoError(t, p.Load())
+
+	registry.set(v3, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return p.Definition() == v3 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
@@ -0,0 +1,130 @@
+// Package registry decodes messages in the confluent wire format: a magic
+// byte and the schema registry ID of the schema they were written with,
+// followed by the avro data. Writer schemas are fetched from the schema
+// registry by ID, cached, and resolved against the reader schema, so that
+// producers can add fields without the consumer being restarted.
+package registry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// magicByte starts every message in the confluent wire format.
+const magicByte = 0
+
+// headerSize is the size of the magic byte and schema ID.
+const headerSize = 5
+
+// ErrNotWireFormat is returned when a message is not in the wire format.
+var ErrNotWireFormat = errors.New("message is not in the confluent wire format")
+
+// ErrSchemaNotFound is returned when the schema registry has no schema with
+// the requested ID.
+var ErrSchemaNotFound = errors.New("schema not found in schema registry")
+
+// Split returns the schema ID and avro data of a message in the wire format.
+func Split(message []byte) (int32, []byte, error) {
+	if len(message) < headerSize || message[0] != magicByte {
+		return 0, nil, ErrNotWireFormat
+	}
+	return int32(binary.BigEndian.Uint32(message[1:headerSize])), message[headerSize:], nil
+}
+
+// Frame returns avro data written with the schema of the given ID in the wire
+// format.
+func Frame(id int32, data []byte) []byte {
+	message := make([]byte, headerSize, headerSize+len(data))
+	binary.BigEndian.PutUint32(message[1:], uint32(id))
+	return append(message, data...)
+}
+
+// Client fetches schemas from the schema registry by ID. A schema's ID never
+// changes, so each is fetched only once. A failure to fetch a schema is
+// remembered for FailureTTL, so that messages which only look like they are in
+// the wire format do not each cost a request to the registry.
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+	FailureTTL time.Duration
+
+	mu       sync.Mutex
+	schemas  map[int32]string
+	failures map[int32]failedFetch
+}
+
+// failedFetch is a failure to fetch a schema and when it expires.
+type failedFetch struct {
+	err     error
+	expires time.Time
+}
+
+// defaultTimeout limits requests to the schema registry when no http client
+// is given.
+const defaultTimeout = 10 * time.Second
+
+// defaultFailureTTL is how long a failure to fetch a schema is remembered.
+const defaultFailureTTL = time.Minute
+
+// NewClient returns a client of the schema registry at url, using a default
+// http client if httpClient is nil.
+func NewClient(url string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Client{URL: url, HTTPClient: httpClient, FailureTTL: defaultFailureTTL, schemas: map[int32]string{}, failures: map[int32]failedFetch{}}
+}
+
+// SchemaByID returns the definition of the schema with the given ID.
+func (c *Client) SchemaByID(id int32) (string, error) {
+	c.mu.Lock()
+	definition, ok := c.schemas[id]
+	failed, failedBefore := c.failures[id]
+	c.mu.Unlock()
+	if ok {
+		return definition, nil
+	}
+	if failedBefore && time.Now().Before(failed.expires) {
+		return "", failed.err
+	}
+
+	definition, err := c.fetch(id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.failures[id] = failedFetch{err: err, expires: time.Now().Add(c.FailureTTL)}
+		return "", err
+	}
+	delete(c.failures, id)
+	c.schemas[id] = definition
+	return definition, nil
+}
+
+func (c *Client) fetch(id int32) (string, error) {
+	res, err := c.HTTPClient.Get(fmt.Sprintf("%s/schemas/ids/%d", c.URL, id))
+	if err != nil {
+		return "", fmt.Errorf("error fetching schema [%d]: %w", id, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("error fetching schema [%d]: %w", id, ErrSchemaNotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching schema [%d]: unexpected status [%d]", id, res.StatusCode)
+	}
+
+	var body struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("error decoding schema [%d]: %w", id, err)
+	}
+	return body.Schema, nil
+}
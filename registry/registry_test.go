@@ -0,0 +1,229 @@
+package registry
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/companieshouse/chs.go/avro"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v1 is the refund request schema before the optional fields were added.
+const v1 = `{"type":"record","name":"refund_request","namespace":"payments","fields":[{"name":"attempt","type":"int"},{"name":"payment_id","type":"string"},{"name":"refund_amount","type":"string"},{"name":"refund_reference","type":"string"}]}`
+
+// v3 has the optional fields, written in a different order, and a field the
+// reader does not know.
+const v3 = `{"type":"record","name":"refund_request","namespace":"payments","fields":[{"name":"attempt","type":"int"},{"name":"payment_id","type":"string"},{"name":"refund_amount","type":"string"},{"name":"refund_reference","type":"string"},{"name":"reason","type":["null","string"],"default":null},{"name":"currency","type":["null","string"],"default":null},{"name":"requested_by","type":["null","string"],"default":null},{"name":"channel","type":{"type":"enum","name":"channel","symbols":["web","api"]}}]}`
+
+// encoder writes avro binary data.
+type encoder []byte
+
+func (e encoder) long(n int64) encoder {
+	return binary.AppendVarint(e, n)
+}
+
+func (e encoder) str(s string) encoder {
+	return append(e.long(int64(len(s))), s...)
+}
+
+type fakeSchemas struct {
+	schemas map[int32]string
+	calls   atomic.Int32
+}
+
+func (f *fakeSchemas) SchemaByID(id int32) (string, error) {
+	f.calls.Add(1)
+	definition, ok := f.schemas[id]
+	if !ok {
+		return "", ErrSchemaNotFound
+	}
+	return definition, nil
+}
+
+type fakeFallback struct {
+	called []byte
+}
+
+func (f *fakeFallback) Unmarshal(message []byte, v interface{}) error {
+	f.called = message
+	v.(*data.RefundRequest).PaymentID = "fallback"
+	return nil
+}
+
+func TestUnitSplitFrame(t *testing.T) {
+	id, payload, err := Split(Frame(258, []byte{1, 2}))
+	require.NoError(t, err)
+	assert.Equal(t, int32(258), id)
+	assert.Equal(t, []byte{1, 2}, payload)
+
+	for _, message := range [][]byte{nil, {0, 0, 1}, {1, 0, 0, 0, 1}} {
+		_, _, err := Split(message)
+		assert.ErrorIs(t, err, ErrNotWireFormat, "%v", message)
+	}
+}
+
+func TestUnitDecoderUnmarshal(t *testing.T) {
+	schemas := &fakeSchemas{schemas: map[int32]string{1: v1, 3: v3}}
+	fallback := &fakeFallback{}
+	decoder, err := NewDecoder(schemas, data.RefundRequestSchema, fallback)
+	require.NoError(t, err)
+
+	t.Run("an older writer schema is read with the reader's defaults", func(t *testing.T) {
+		message := Frame(1, encoder{}.long(2).str("payment-1").str("10.00").str("ref"))
+
+		var rr data.RefundRequest
+		require.NoError(t, decoder.Unmarshal(message, &rr))
+		assert.Equal(t, data.RefundRequest{Attempt: 2, PaymentID: "payment-1", RefundAmount: "10.00", RefundReference: "ref"}, rr)
+	})
+
+	t.Run("a newer writer schema is read, ignoring fields the reader does not know", func(t *testing.T) {
+		value := encoder{}.long(1).str("payment-2").str("5.50").str("ref").
+			long(1).str("duplicate payment"). // reason
+			long(1).str("GBP").               // currency
+			long(0).                          // requested_by null
+			long(1)                           // channel api
+		message := Frame(3, value)
+
+		var rr data.RefundRequest
+		require.NoError(t, decoder.Unmarshal(message, &rr))
+		assert.Equal(t, data.RefundRequest{Attempt: 1, PaymentID: "payment-2", RefundAmount: "5.50", RefundReference: "ref", Currency: "GBP", Reason: "duplicate payment"}, rr)
+	})
+
+	t.Run("writer schemas are fetched once", func(t *testing.T) {
+		calls := schemas.calls.Load()
+		var rr data.RefundRequest
+		require.NoError(t, decoder.Unmarshal(Frame(1, encoder{}.long(2).str("p").str("1.00").str("r")), &rr))
+		assert.Equal(t, calls, schemas.calls.Load())
+	})
+
+	t.Run("messages without a known schema ID are decoded by the fallback", func(t *testing.T) {
+		for _, message := range [][]byte{{2, 1, 2}, Frame(99, []byte{1})} {
+			var rr data.RefundRequest
+			require.NoError(t, decoder.Unmarshal(message, &rr))
+			assert.Equal(t, "fallback", rr.PaymentID)
+			assert.Equal(t, message, fallback.called)
+		}
+	})
+
+	t.Run("messages that cannot be decoded in the wire format are decoded by the fallback", func(t *testing.T) {
+		message := Frame(1, encoder{}.long(2).str("payment-1"))
+		var rr data.RefundRequest
+		require.NoError(t, decoder.Unmarshal(message, &rr))
+		assert.Equal(t, "fallback", rr.PaymentID)
+		assert.Equal(t, message, fallback.called)
+	})
+}
+
+func TestUnitDecoderIncompatibleWriter(t *testing.T) {
+	schemas := &fakeSchemas{schemas: map[int32]string{
+		// payment_id is missing and has no default in the reader.
+		4: `{"type":"record","name":"refund_request","fields":[{"name":"attempt","type":"int"},{"name":"refund_amount","type":"string"},{"name":"refund_reference","type":"string"}]}`,
+		// attempt cannot be read as an int.
+		5: `{"type":"record","name":"refund_request","fields":[{"name":"attempt","type":"string"},{"name":"payment_id","type":"string"},{"name":"refund_amount","type":"string"},{"name":"refund_reference","type":"string"}]}`,
+	}}
+	decoder, err := NewDecoder(schemas, data.RefundRequestSchema, nil)
+	require.NoError(t, err)
+
+	var rr data.RefundRequest
+	assert.ErrorContains(t, decoder.Unmarshal(Frame(4, []byte{2}), &rr), "[payment_id] is missing")
+	assert.ErrorContains(t, decoder.Unmarshal(Frame(5, []byte{2}), &rr), "[attempt] cannot be read")
+	assert.ErrorIs(t, decoder.Unmarshal([]byte{2}, &rr), ErrNotWireFormat)
+
+	schemas.schemas[1] = v1
+	assert.Error(t, decoder.Unmarshal(Frame(1, encoder{}.long(2).str("payment-1")), &rr), "truncated data")
+}
+
+func TestUnitDecoderUnframedAttemptZeroWithRegistryDown(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	decoder, err := NewDecoder(NewClient(server.URL, server.Client()), data.RefundRequestSchema, &avro.Schema{Definition: v1})
+	require.NoError(t, err)
+
+	// An attempt of zero is written as a zero byte, so the message looks like
+	// it is in the wire format.
+	message := encoder{}.long(0).str("payment-1").str("10.00").str("ref")
+	for i := 0; i < 2; i++ {
+		var rr data.RefundRequest
+		require.NoError(t, decoder.Unmarshal(message, &rr))
+		assert.Equal(t, data.RefundRequest{PaymentID: "payment-1", RefundAmount: "10.00", RefundReference: "ref"}, rr)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestUnitReadable(t *testing.T) {
+	parse := func(definition string) *schemaType {
+		s, err := parseSchema(definition)
+		require.NoError(t, err)
+		return s
+	}
+
+	cases := []struct {
+		writer, reader string
+		readable       bool
+	}{
+		{`"int"`, `"long"`, true},
+		{`"long"`, `"int"`, false},
+		{`"float"`, `"double"`, true},
+		{`"string"`, `"bytes"`, true},
+		{`"string"`, `["null","string"]`, true},
+		{`["null","string"]`, `"string"`, true},
+		{`"int"`, `["null","string"]`, false},
+		{`{"type":"array","items":"int"}`, `{"type":"array","items":"long"}`, true},
+		{`{"type":"map","values":"string"}`, `{"type":"map","values":"int"}`, false},
+		{`{"type":"enum","name":"e","symbols":["a"]}`, `{"type":"enum","name":"other","symbols":["a"]}`, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.readable, readable(parse(c.writer), parse(c.reader)), "%s as %s", c.writer, c.reader)
+	}
+}
+
+func TestUnitClientSchemaByID(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			fmt.Fprintf(w, `{"schema":%q}`, v1)
+		case "/schemas/ids/2":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, server.Client())
+
+	for i := 0; i < 2; i++ {
+		definition, err := client.SchemaByID(1)
+		require.NoError(t, err)
+		assert.Equal(t, v1, definition)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	for i := 0; i < 2; i++ {
+		_, err := client.SchemaByID(2)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, ErrSchemaNotFound))
+	}
+	assert.Equal(t, int32(2), requests.Load())
+
+	client.FailureTTL = 0
+	for i := 0; i < 2; i++ {
+		_, err := client.SchemaByID(3)
+		assert.ErrorIs(t, err, ErrSchemaNotFound)
+	}
+	assert.Equal(t, int32(4), requests.Load())
+}
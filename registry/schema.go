@@ -0,0 +1,235 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// schemaType is a parsed avro schema. Logical types are read as their
+// underlying type.
+type schemaType struct {
+	Type     string
+	Name     string
+	Fields   []field
+	Symbols  []string
+	Items    *schemaType
+	Values   *schemaType
+	Size     int
+	Branches []*schemaType
+}
+
+type field struct {
+	Name       string
+	Type       *schemaType
+	Default    json.RawMessage
+	HasDefault bool
+}
+
+var primitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// parseSchema parses an avro schema definition.
+func parseSchema(definition string) (*schemaType, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(definition), &raw); err != nil {
+		return nil, fmt.Errorf("error parsing avro schema: %w", err)
+	}
+	return (&parser{named: map[string]*schemaType{}}).parse(raw, "")
+}
+
+type parser struct {
+	named map[string]*schemaType
+}
+
+func (p *parser) parse(raw interface{}, namespace string) (*schemaType, error) {
+	switch s := raw.(type) {
+	case string:
+		if primitives[s] {
+			return &schemaType{Type: s}, nil
+		}
+		if t, ok := p.named[fullName(s, namespace)]; ok {
+			return t, nil
+		}
+		if t, ok := p.named[s]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown avro type [%s]", s)
+	case []interface{}:
+		union := &schemaType{Type: "union"}
+		for _, branch := range s {
+			t, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			union.Branches = append(union.Branches, t)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return p.parseComplex(s, namespace)
+	default:
+		return nil, fmt.Errorf("invalid avro schema [%v]", raw)
+	}
+}
+
+func (p *parser) parseComplex(s map[string]interface{}, namespace string) (*schemaType, error) {
+	typeName, _ := s["type"].(string)
+	if ns, ok := s["namespace"].(string); ok {
+		namespace = ns
+	}
+	name, _ := s["name"].(string)
+	t := &schemaType{Type: typeName, Name: fullName(name, namespace)}
+
+	switch typeName {
+	case "record", "error":
+		t.Type = "record"
+		p.named[t.Name] = t
+		fields, _ := s["fields"].([]interface{})
+		for _, rawField := range fields {
+			f, _ := rawField.(map[string]interface{})
+			fieldName, _ := f["name"].(string)
+			fieldType, err := p.parse(f["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("invalid type of field [%s]: %w", fieldName, err)
+			}
+			parsed := field{Name: fieldName, Type: fieldType}
+			if def, ok := f["default"]; ok {
+				parsed.HasDefault = true
+				parsed.Default, _ = json.Marshal(def)
+			}
+			t.Fields = append(t.Fields, parsed)
+		}
+	case "enum":
+		p.named[t.Name] = t
+		symbols, _ := s["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			str, _ := symbol.(string)
+			t.Symbols = append(t.Symbols, str)
+		}
+	case "fixed":
+		p.named[t.Name] = t
+		size, _ := s["size"].(float64)
+		t.Size = int(size)
+	case "array":
+		items, err := p.parse(s["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		t.Items = items
+	case "map":
+		values, err := p.parse(s["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		t.Values = values
+	default:
+		if primitives[typeName] {
+			return &schemaType{Type: typeName}, nil
+		}
+		return p.parse(typeName, namespace)
+	}
+	return t, nil
+}
+
+func fullName(name, namespace string) string {
+	if name == "" || namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// unqualified returns a name without its namespace.
+func unqualified(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// readable reports whether data written with the writer type can be read with
+// the reader type, following the avro schema resolution rules.
+func readable(writer, reader *schemaType) bool {
+	if writer.Type == "union" {
+		for _, branch := range writer.Branches {
+			if readable(branch, reader) {
+				return true
+			}
+		}
+		return false
+	}
+	if reader.Type == "union" {
+		return readerBranch(writer, reader) != nil
+	}
+	if promotable(writer.Type, reader.Type) {
+		return true
+	}
+	if writer.Type != reader.Type {
+		return false
+	}
+
+	switch writer.Type {
+	case "record":
+		return unqualified(writer.Name) == unqualified(reader.Name) && resolvable(writer, reader) == nil
+	case "enum", "fixed":
+		return unqualified(writer.Name) == unqualified(reader.Name) && writer.Size == reader.Size
+	case "array":
+		return readable(writer.Items, reader.Items)
+	case "map":
+		return readable(writer.Values, reader.Values)
+	}
+	return true
+}
+
+// readerBranch returns the first branch of a reader union that can read the
+// writer type.
+func readerBranch(writer, reader *schemaType) *schemaType {
+	for _, branch := range reader.Branches {
+		if readable(writer, branch) {
+			return branch
+		}
+	}
+	return nil
+}
+
+// promotable reports whether a value of the writer primitive type can be
+// promoted to the reader primitive type.
+func promotable(writer, reader string) bool {
+	switch writer {
+	case "int":
+		return reader == "long" || reader == "float" || reader == "double"
+	case "long":
+		return reader == "float" || reader == "double"
+	case "float":
+		return reader == "double"
+	case "string":
+		return reader == "bytes"
+	case "bytes":
+		return reader == "string"
+	}
+	return false
+}
+
+// resolvable returns an error describing why records written with the writer
+// record cannot be read with the reader record: a reader field has no default
+// and is missing from the writer, or has a type the writer's cannot be read as.
+// Fields written but not in the reader are ignored.
+func resolvable(writer, reader *schemaType) error {
+	written := make(map[string]field, len(writer.Fields))
+	for _, f := range writer.Fields {
+		written[f.Name] = f
+	}
+
+	var problems []string
+	for _, f := range reader.Fields {
+		w, ok := written[f.Name]
+		switch {
+		case !ok && !f.HasDefault:
+			problems = append(problems, fmt.Sprintf("field [%s] is missing and has no default", f.Name))
+		case ok && !readable(w.Type, f.Type):
+			problems = append(problems, fmt.Sprintf("field [%s] cannot be read from the written type", f.Name))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("record [%s] is incompatible: %s", reader.Name, strings.Join(problems, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/chs.go/kafka/resilience"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/dlq"
+	"github.com/companieshouse/refund-request-consumer/replay"
+)
+
+// runReplay reads failed refund requests from the error topic, or from the
+// dead-letter topic, and republishes those selected by its arguments to the
+// main topic. It is a dry run unless -dry-run=false is given.
+func runReplay(cfg *config.Config, args []string) error {
+	flags := newFlagSet("replay")
+	deadLetter := flags.Bool("dead-letter", false, "Replay from the dead-letter topic rather than the error topic")
+	dryRun := flags.Bool("dry-run", true, "Show what would be replayed without republishing it")
+	paymentID := flags.String("payment-id", "", "Only replay refunds of this payment ID")
+	reference := flags.String("refund-reference", "", "Only replay refunds with this refund reference")
+	fromOffset := flags.Int64("from-offset", 0, "First offset of each partition to replay")
+	toOffset := flags.Int64("to-offset", 0, "Offset of each partition to stop before, 0 for the tail")
+	after := flags.String("after", "", "Only replay messages published at or after this RFC 3339 time")
+	before := flags.String("before", "", "Only replay messages published before this RFC 3339 time")
+	class := flags.String("class", "", "Only replay dead-lettered messages of this failure class")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := replay.Filter{
+		PaymentID:       *paymentID,
+		RefundReference: *reference,
+		FromOffset:      *fromOffset,
+		ToOffset:        *toOffset,
+		Class:           dlq.FailureClass(*class),
+	}
+	var err error
+	if filter.After, err = parseTime(*after); err != nil {
+		return fmt.Errorf("invalid -after: %w", err)
+	}
+	if filter.Before, err = parseTime(*before); err != nil {
+		return fmt.Errorf("invalid -before: %w", err)
+	}
+
+	source, topic := replay.SourceError, resilience.NewHandler(cfg.ConsumerTopic, cfg.Namespace(), nil, nil, nil).GetErrorTopicName()
+	if *deadLetter {
+		source, topic = replay.SourceDeadLetter, cfg.DeadLetterTopic
+	}
+	if err := filter.Validate(source); err != nil {
+		return err
+	}
+
+	avroSchema, err := refundRequestSchema(cfg)
+	if err != nil {
+		return err
+	}
+	decoder, err := refundRequestDecoder(cfg, avroSchema)
+	if err != nil {
+		return err
+	}
+
+	client, err := newKafkaClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("error creating kafka consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	var publisher *replay.Publisher
+	if !*dryRun {
+		p, err := producer.New(&producer.Config{Acks: &producer.WaitForAll, BrokerAddrs: cfg.BrokerAddr})
+		if err != nil {
+			return fmt.Errorf("error creating kafka producer: %w", err)
+		}
+		defer p.Close()
+		publisher = &replay.Publisher{Topic: cfg.ConsumerTopic, Sender: p, Schema: avroSchema}
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	log.Info(fmt.Sprintf("replaying from topic [%s] to topic [%s]", topic, cfg.ConsumerTopic), log.Data{"dry_run": *dryRun, "filter": filter})
+	reader := &replay.Reader{Topic: topic, Source: source, Client: client, Consumer: consumer, Decoder: decoder}
+	summary, err := replay.Run(ctx, reader, filter, publisher, *dryRun, os.Stdout)
+	log.Info("replay finished", log.Data{"selected": summary.Selected, "replayed": summary.Replayed, "skipped": summary.Skipped, "dry_run": *dryRun})
+	return err
+}
+
+// parseTime parses an RFC 3339 time, where empty means the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
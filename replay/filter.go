@@ -0,0 +1,63 @@
+package replay
+
+import (
+	"errors"
+	"time"
+
+	"github.com/companieshouse/refund-request-consumer/dlq"
+)
+
+// Filter selects the records to replay. Zero fields match every record.
+type Filter struct {
+	PaymentID       string
+	RefundReference string
+	// FromOffset is the first offset of each partition to replay, and ToOffset
+	// the offset to stop before.
+	FromOffset int64
+	ToOffset   int64
+	// After is the earliest timestamp to replay, and Before the timestamp to
+	// stop before.
+	After  time.Time
+	Before time.Time
+	Class  dlq.FailureClass
+	// Last limits the read to the last records of each partition, before
+	// the other fields are applied.
+	Last int64
+}
+
+// ErrNoFailureClass is returned when filtering the error topic by failure
+// class, as only the dead-letter topic records why a message failed.
+var ErrNoFailureClass = errors.New("messages on the error topic do not record a failure class, filter the dead-letter topic instead")
+
+// Validate returns an error if the filter cannot be applied to the source.
+func (f Filter) Validate(source Source) error {
+	if f.Class != "" && source != SourceDeadLetter {
+		return ErrNoFailureClass
+	}
+	if f.ToOffset > 0 && f.ToOffset <= f.FromOffset {
+		return errors.New("offset range is empty")
+	}
+	if !f.Before.IsZero() && !f.Before.After(f.After) {
+		return errors.New("time range is empty")
+	}
+	return nil
+}
+
+// Match reports whether a record is selected by the filter. A record which
+// could not be decoded has no payment ID or refund reference, so does not
+// match a filter on either.
+func (f Filter) Match(r Record) bool {
+	if f.PaymentID != "" && (r.DecodeErr != nil || r.Request.PaymentID != f.PaymentID) {
+		return false
+	}
+	if f.RefundReference != "" && (r.DecodeErr != nil || r.Request.RefundReference != f.RefundReference) {
+		return false
+	}
+	if r.Offset < f.FromOffset || (f.ToOffset > 0 && r.Offset >= f.ToOffset) {
+		return false
+	}
+	if r.Timestamp.Before(f.After) || (!f.Before.IsZero() && !r.Timestamp.Before(f.Before)) {
+		return false
+	}
+	return f.Class == "" || r.Class == f.Class
+}
@@ -0,0 +1,302 @@
+// Package replay reads failed refund requests back from the error or
+// dead-letter topic and republishes those selected by a filter to the main
+// topic, so that operators can replay some failures without replaying them
+// all.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/dlq"
+)
+
+// Source is the kind of topic records are read from.
+type Source string
+
+// Topics records may be replayed from. The error topic holds refund requests
+// which exhausted their retries; the dead-letter topic holds envelopes around
+// the messages which failed permanently, recording why.
+const (
+	SourceError      Source = "error"
+	SourceDeadLetter Source = "dead-letter"
+)
+
+// Record is a failed refund request read from the error or dead-letter topic.
+type Record struct {
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+	Request   data.RefundRequest
+	Class     dlq.FailureClass
+	Error     string
+	DecodeErr error
+	payload   []byte
+}
+
+// OffsetClient looks up partition offsets. It is satisfied by sarama.Client.
+type OffsetClient interface {
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+// Decoder decodes refund requests in any format. It is satisfied by
+// codec.Selector.
+type Decoder interface {
+	Decode(msg *sarama.ConsumerMessage) (data.RefundRequest, error)
+	DecodeFormat(format string, value []byte) (data.RefundRequest, error)
+}
+
+// Reader reads the records on a topic up to the offsets at its tail when the
+// read starts, so that a replay finishes even if records keep arriving.
+type Reader struct {
+	Topic    string
+	Source   Source
+	Client   OffsetClient
+	Consumer sarama.Consumer
+	Decoder  Decoder
+}
+
+// Read calls fn with each record on the topic selected by filter, in offset
+// order within each partition, stopping at the first error.
+func (r *Reader) Read(ctx context.Context, filter Filter, fn func(Record) error) error {
+	partitions, err := r.Consumer.Partitions(r.Topic)
+	if err != nil {
+		return fmt.Errorf("error listing partitions of topic [%s]: %w", r.Topic, err)
+	}
+
+	// Capture the tail of every partition before reading any, so that records
+	// republished during the replay are not read back.
+	tails := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		tails[partition], err = r.Client.GetOffset(r.Topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return fmt.Errorf("error getting tail offset of topic [%s] partition %d: %w", r.Topic, partition, err)
+		}
+	}
+
+	for _, partition := range partitions {
+		if err := r.readPartition(ctx, partition, tails[partition], filter, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reader) readPartition(ctx context.Context, partition int32, end int64, filter Filter, fn func(Record) error) error {
+	start, err := r.Client.GetOffset(r.Topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return fmt.Errorf("error getting oldest offset of topic [%s] partition %d: %w", r.Topic, partition, err)
+	}
+	if filter.FromOffset > start {
+		start = filter.FromOffset
+	}
+	if filter.ToOffset > 0 && filter.ToOffset < end {
+		end = filter.ToOffset
+	}
+	if filter.Last > 0 && end-filter.Last > start {
+		start = end - filter.Last
+	}
+	if start >= end {
+		return nil
+	}
+
+	pc, err := r.Consumer.ConsumePartition(r.Topic, partition, start)
+	if err != nil {
+		return fmt.Errorf("error consuming topic [%s] partition %d: %w", r.Topic, partition, err)
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-pc.Errors():
+			return fmt.Errorf("error reading topic [%s] partition %d: %w", r.Topic, partition, err)
+		case msg := <-pc.Messages():
+			if record := r.decode(msg); filter.Match(record) {
+				if err := fn(record); err != nil {
+					return err
+				}
+			}
+			if msg.Offset+1 >= end {
+				return nil
+			}
+		}
+	}
+}
+
+// decode returns the record held by a message. A dead-letter envelope is
+// unwrapped to the original message, which is decoded in the format recorded
+// on the envelope, or that of its original topic, and may itself not decode.
+func (r *Reader) decode(msg *sarama.ConsumerMessage) Record {
+	record := Record{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		payload:   msg.Value,
+	}
+
+	var err error
+	if r.Source == SourceDeadLetter {
+		var envelope dlq.Envelope
+		if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+			record.DecodeErr = fmt.Errorf("error decoding dead-letter envelope: %w", err)
+			return record
+		}
+		record.payload = envelope.Payload
+		record.Class = envelope.FailureClass
+		record.Error = envelope.Error
+		if record.Timestamp.IsZero() {
+			record.Timestamp = envelope.Timestamp
+		}
+
+		if envelope.Format != "" {
+			record.Request, err = r.Decoder.DecodeFormat(envelope.Format, envelope.Payload)
+		} else {
+			record.Request, err = r.Decoder.Decode(&sarama.ConsumerMessage{Topic: envelope.Topic, Value: envelope.Payload})
+		}
+	} else {
+		record.Request, err = r.Decoder.Decode(msg)
+	}
+
+	if err != nil {
+		record.DecodeErr = fmt.Errorf("error decoding refund request: %w", err)
+	}
+	return record
+}
+
+// Sender sends a message to kafka. It is satisfied by producer.Producer.
+type Sender interface {
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// ErrNotReplayable is returned when replaying a record which could not be
+// decoded, as it would only fail again.
+var ErrNotReplayable = errors.New("record cannot be decoded, so cannot be replayed")
+
+// Encoder encodes a message with an avro schema. It is satisfied by
+// avro.Schema.
+type Encoder interface {
+	Marshal(v interface{}) ([]byte, error)
+}
+
+// Publisher republishes records to the main topic.
+type Publisher struct {
+	Topic  string
+	Sender Sender
+	Schema Encoder
+}
+
+// Publish republishes the refund request held by a record as a first attempt,
+// so that it is given the full set of retries again. It is always republished
+// as avro, whatever format it was read in.
+func (p *Publisher) Publish(record Record) error {
+	if record.DecodeErr != nil {
+		return ErrNotReplayable
+	}
+
+	rr := record.Request
+	rr.Attempt = 1
+	value, err := p.Schema.Marshal(rr)
+	if err != nil {
+		return fmt.Errorf("error encoding refund request: %w", err)
+	}
+
+	_, _, err = p.Sender.SendMessage(&sarama.ProducerMessage{
+		Topic: p.Topic,
+		Key:   sarama.StringEncoder(rr.PaymentID),
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing refund request to topic [%s]: %w", p.Topic, err)
+	}
+	return nil
+}
+
+// Actions taken on a selected record.
+const (
+	ActionWouldReplay = "would_replay"
+	ActionReplayed    = "replayed"
+	ActionSkipped     = "skipped"
+)
+
+// Summary counts the records selected and replayed.
+type Summary struct {
+	Selected int `json:"selected"`
+	Replayed int `json:"replayed"`
+	Skipped  int `json:"skipped"`
+}
+
+// Line describes a record, as written to the output of a replay.
+type Line struct {
+	Partition       int32            `json:"partition"`
+	Offset          int64            `json:"offset"`
+	Timestamp       time.Time        `json:"timestamp"`
+	PaymentID       string           `json:"payment_id,omitempty"`
+	RefundReference string           `json:"refund_reference,omitempty"`
+	RefundAmount    string           `json:"refund_amount,omitempty"`
+	Attempt         int32            `json:"attempt,omitempty"`
+	FailureClass    dlq.FailureClass `json:"failure_class,omitempty"`
+	Error           string           `json:"error,omitempty"`
+	Action          string           `json:"action,omitempty"`
+	Reason          string           `json:"reason,omitempty"`
+}
+
+// LineOf returns the description of a record. A record which could not be
+// decoded gives the decoding error as its reason.
+func LineOf(record Record) Line {
+	l := Line{
+		Partition:       record.Partition,
+		Offset:          record.Offset,
+		Timestamp:       record.Timestamp,
+		PaymentID:       record.Request.PaymentID,
+		RefundReference: record.Request.RefundReference,
+		RefundAmount:    record.Request.RefundAmount,
+		Attempt:         record.Request.Attempt,
+		FailureClass:    record.Class,
+		Error:           record.Error,
+	}
+	if record.DecodeErr != nil {
+		l.Reason = record.DecodeErr.Error()
+	}
+	return l
+}
+
+// Run reads the records selected by filter and writes a line of JSON to out
+// describing each. Unless dryRun is set, each is also republished with
+// publisher. Records which cannot be decoded are skipped. The run stops at
+// the first failure to publish, so that it can be resumed from that offset.
+func Run(ctx context.Context, reader *Reader, filter Filter, publisher *Publisher, dryRun bool, out io.Writer) (Summary, error) {
+	var summary Summary
+	if err := filter.Validate(reader.Source); err != nil {
+		return summary, err
+	}
+
+	encoder := json.NewEncoder(out)
+	err := reader.Read(ctx, filter, func(record Record) error {
+		summary.Selected++
+		l := LineOf(record)
+
+		switch {
+		case record.DecodeErr != nil:
+			summary.Skipped++
+			l.Action = ActionSkipped
+		case dryRun:
+			l.Action = ActionWouldReplay
+		default:
+			if err := publisher.Publish(record); err != nil {
+				return fmt.Errorf("error replaying partition %d offset %d: %w", record.Partition, record.Offset, err)
+			}
+			summary.Replayed++
+			l.Action = ActionReplayed
+		}
+		return encoder.Encode(l)
+	})
+	return summary, err
+}
@@ -0,0 +1,305 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/avro"
+	"github.com/companieshouse/refund-request-consumer/codec"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/dlq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const schema = `{"type":"record","name":"refund_request","namespace":"payments","fields":[{"name":"attempt","type":"int"},{"name":"payment_id","type":"string"},{"name":"refund_amount","type":"string"},{"name":"refund_reference","type":"string"}]}`
+
+var (
+	refundSchema  = &avro.Schema{Definition: schema}
+	refundDecoder = newDecoder(map[string]string{"refund-request-ui": codec.FormatJSON})
+	published     = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+)
+
+func newDecoder(topics map[string]string) *codec.Selector {
+	s, err := codec.NewSelector(codec.Avro{Schema: refundSchema}, topics)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// fakeKafka holds the messages on each partition of one topic, starting at
+// the offset of the first.
+type fakeKafka struct {
+	partitions map[int32][]*sarama.ConsumerMessage
+	err        error
+}
+
+func (k *fakeKafka) add(partition int32, offset int64, value []byte) {
+	if k.partitions == nil {
+		k.partitions = make(map[int32][]*sarama.ConsumerMessage)
+	}
+	k.partitions[partition] = append(k.partitions[partition], &sarama.ConsumerMessage{
+		Partition: partition,
+		Offset:    offset,
+		Timestamp: published.Add(time.Duration(offset) * time.Hour),
+		Value:     value,
+	})
+}
+
+func (k *fakeKafka) GetOffset(topic string, partition int32, when int64) (int64, error) {
+	msgs := k.partitions[partition]
+	if when == sarama.OffsetOldest {
+		return msgs[0].Offset, nil
+	}
+	return msgs[len(msgs)-1].Offset + 1, nil
+}
+
+func (k *fakeKafka) Topics() ([]string, error) { return []string{"topic"}, nil }
+
+func (k *fakeKafka) Partitions(topic string) ([]int32, error) {
+	var partitions []int32
+	for p := int32(0); int(p) < len(k.partitions); p++ {
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+func (k *fakeKafka) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	pc := &fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, len(k.partitions[partition])),
+		errors:   make(chan *sarama.ConsumerError, 1),
+	}
+	if k.err != nil {
+		pc.errors <- &sarama.ConsumerError{Topic: topic, Partition: partition, Err: k.err}
+		return pc, nil
+	}
+	for _, msg := range k.partitions[partition] {
+		if msg.Offset >= offset {
+			pc.messages <- msg
+		}
+	}
+	return pc, nil
+}
+
+func (k *fakeKafka) HighWaterMarks() map[string]map[int32]int64 { return nil }
+
+func (k *fakeKafka) Close() error { return nil }
+
+type fakePartitionConsumer struct {
+	messages chan *sarama.ConsumerMessage
+	errors   chan *sarama.ConsumerError
+}
+
+func (pc *fakePartitionConsumer) AsyncClose()                              {}
+func (pc *fakePartitionConsumer) Close() error                             { return nil }
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+func (pc *fakePartitionConsumer) Errors() <-chan *sarama.ConsumerError     { return pc.errors }
+func (pc *fakePartitionConsumer) HighWaterMarkOffset() int64               { return 0 }
+
+type mockSender struct {
+	sent []*sarama.ProducerMessage
+	err  error
+}
+
+func (m *mockSender) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	m.sent = append(m.sent, msg)
+	return 0, 0, m.err
+}
+
+func encode(t *testing.T, paymentID, reference string) []byte {
+	value, err := refundSchema.Marshal(data.RefundRequest{Attempt: 3, PaymentID: paymentID, RefundAmount: "10.00", RefundReference: reference})
+	require.NoError(t, err)
+	return value
+}
+
+func envelope(t *testing.T, payload []byte, class dlq.FailureClass) []byte {
+	value, err := json.Marshal(dlq.Envelope{Payload: payload, FailureClass: class, Error: "failed"})
+	require.NoError(t, err)
+	return value
+}
+
+// errorTopic returns two partitions of refund requests: P1..P3 at offsets 4
+// to 6 of partition 0, and P4 and an undecodable message at offsets 0 and 1
+// of partition 1.
+func errorTopic(t *testing.T) *fakeKafka {
+	k := &fakeKafka{}
+	k.add(0, 4, encode(t, "P1", "R1"))
+	k.add(0, 5, encode(t, "P2", "R2"))
+	k.add(0, 6, encode(t, "P3", "R3"))
+	k.add(1, 0, encode(t, "P4", "R4"))
+	k.add(1, 1, []byte("not a refund request"))
+	return k
+}
+
+func read(t *testing.T, k *fakeKafka, source Source, filter Filter) []Record {
+	reader := &Reader{Topic: "topic", Source: source, Client: k, Consumer: k, Decoder: refundDecoder}
+
+	var records []Record
+	require.NoError(t, reader.Read(context.Background(), filter, func(r Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	return records
+}
+
+func paymentIDs(records []Record) []string {
+	var ids []string
+	for _, r := range records {
+		ids = append(ids, r.Request.PaymentID)
+	}
+	return ids
+}
+
+func TestUnitReadAll(t *testing.T) {
+	records := read(t, errorTopic(t), SourceError, Filter{})
+	require.Len(t, records, 5)
+	assert.Equal(t, []string{"P1", "P2", "P3", "P4", ""}, paymentIDs(records))
+	assert.Equal(t, int64(5), records[1].Offset)
+	assert.Equal(t, published.Add(5*time.Hour), records[1].Timestamp)
+	assert.NoError(t, records[0].DecodeErr)
+	assert.Error(t, records[4].DecodeErr)
+}
+
+func TestUnitReadFiltered(t *testing.T) {
+	k := errorTopic(t)
+
+	assert.Equal(t, []string{"P2"}, paymentIDs(read(t, k, SourceError, Filter{PaymentID: "P2"})))
+	assert.Equal(t, []string{"P3"}, paymentIDs(read(t, k, SourceError, Filter{RefundReference: "R3"})))
+	assert.Equal(t, []string{"P1", "P2", "P4", ""}, paymentIDs(read(t, k, SourceError, Filter{ToOffset: 6})))
+	assert.Equal(t, []string{"P2", "P3"}, paymentIDs(read(t, k, SourceError, Filter{FromOffset: 5})))
+	assert.Equal(t, []string{"P1", "P2"}, paymentIDs(read(t, k, SourceError, Filter{After: published.Add(4 * time.Hour), Before: published.Add(6 * time.Hour)})))
+	assert.Equal(t, []string{"P3", ""}, paymentIDs(read(t, k, SourceError, Filter{Last: 1})))
+	assert.Equal(t, []string{"P2", ""}, paymentIDs(read(t, k, SourceError, Filter{Last: 1, ToOffset: 6})), "the last records before the end of the offset range")
+}
+
+func TestUnitReadDeadLetter(t *testing.T) {
+	k := &fakeKafka{}
+	k.add(0, 0, envelope(t, encode(t, "P1", "R1"), dlq.FailureRejected))
+	k.add(0, 1, envelope(t, []byte("garbage"), dlq.FailureDecode))
+	k.add(0, 2, envelope(t, encode(t, "P3", "R3"), dlq.FailureInvalidAmount))
+
+	records := read(t, k, SourceDeadLetter, Filter{Class: dlq.FailureRejected})
+	require.Len(t, records, 1)
+	assert.Equal(t, "P1", records[0].Request.PaymentID)
+	assert.Equal(t, dlq.FailureRejected, records[0].Class)
+	assert.Equal(t, "failed", records[0].Error)
+
+	records = read(t, k, SourceDeadLetter, Filter{Class: dlq.FailureDecode})
+	require.Len(t, records, 1)
+	assert.Error(t, records[0].DecodeErr)
+}
+
+func TestUnitReadDeadLetterFormats(t *testing.T) {
+	jsonPayload := []byte(`{"attempt":2,"payment_id":"P1","refund_amount":"10.00","refund_reference":"R1"}`)
+	withFormat := func(payload []byte, topic, format string) []byte {
+		value, err := json.Marshal(dlq.Envelope{Payload: payload, Topic: topic, Format: format, FailureClass: dlq.FailureRejected})
+		require.NoError(t, err)
+		return value
+	}
+
+	k := &fakeKafka{}
+	k.add(0, 0, withFormat(jsonPayload, "refund-request", codec.FormatJSON))
+	k.add(0, 1, withFormat(jsonPayload, "refund-request-ui", ""))
+	k.add(0, 2, withFormat(encode(t, "P3", "R3"), "refund-request", codec.FormatAvro))
+
+	records := read(t, k, SourceDeadLetter, Filter{})
+	require.Len(t, records, 3)
+	for _, record := range records {
+		assert.NoError(t, record.DecodeErr)
+	}
+	assert.Equal(t, []string{"P1", "P1", "P3"}, paymentIDs(records), "payloads are decoded in the recorded format, or that of their topic")
+
+	sender := &mockSender{}
+	require.NoError(t, (&Publisher{Topic: "main", Sender: sender, Schema: refundSchema}).Publish(records[0]))
+	var rr data.RefundRequest
+	value, _ := sender.sent[0].Value.Encode()
+	require.NoError(t, refundSchema.Unmarshal(value, &rr))
+	assert.Equal(t, data.RefundRequest{Attempt: 1, PaymentID: "P1", RefundAmount: "10.00", RefundReference: "R1"}, rr, "a json refund request is replayed as avro")
+}
+
+func TestUnitReadError(t *testing.T) {
+	k := errorTopic(t)
+	k.err = errors.New("broker down")
+	reader := &Reader{Topic: "topic", Source: SourceError, Client: k, Consumer: k, Decoder: refundDecoder}
+
+	err := reader.Read(context.Background(), Filter{}, func(Record) error { return nil })
+	assert.ErrorContains(t, err, "broker down")
+}
+
+func TestUnitFilterValidate(t *testing.T) {
+	assert.NoError(t, Filter{}.Validate(SourceError))
+	assert.ErrorIs(t, Filter{Class: dlq.FailureRejected}.Validate(SourceError), ErrNoFailureClass)
+	assert.NoError(t, Filter{Class: dlq.FailureRejected}.Validate(SourceDeadLetter))
+	assert.Error(t, Filter{FromOffset: 5, ToOffset: 5}.Validate(SourceError))
+	assert.Error(t, Filter{After: published, Before: published}.Validate(SourceError))
+}
+
+func TestUnitRunDryRun(t *testing.T) {
+	sender := &mockSender{}
+	reader := &Reader{Topic: "topic", Source: SourceError, Client: errorTopic(t), Consumer: errorTopic(t), Decoder: refundDecoder}
+	publisher := &Publisher{Topic: "main", Sender: sender, Schema: refundSchema}
+
+	var out bytes.Buffer
+	summary, err := Run(context.Background(), reader, Filter{}, publisher, true, &out)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Selected: 5, Skipped: 1}, summary)
+	assert.Empty(t, sender.sent, "nothing is published in a dry run")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5)
+	var first Line
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "P1", first.PaymentID)
+	assert.Equal(t, ActionWouldReplay, first.Action)
+	assert.Contains(t, lines[4], ActionSkipped)
+}
+
+func TestUnitRunReplays(t *testing.T) {
+	sender := &mockSender{}
+	k := errorTopic(t)
+	reader := &Reader{Topic: "topic", Source: SourceError, Client: k, Consumer: k, Decoder: refundDecoder}
+	publisher := &Publisher{Topic: "main", Sender: sender, Schema: refundSchema}
+
+	var out bytes.Buffer
+	summary, err := Run(context.Background(), reader, Filter{PaymentID: "P2"}, publisher, false, &out)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Selected: 1, Replayed: 1}, summary)
+	assert.Contains(t, out.String(), ActionReplayed)
+
+	require.Len(t, sender.sent, 1)
+	msg := sender.sent[0]
+	assert.Equal(t, "main", msg.Topic)
+	assert.Equal(t, sarama.StringEncoder("P2"), msg.Key)
+
+	var rr data.RefundRequest
+	value, _ := msg.Value.Encode()
+	require.NoError(t, refundSchema.Unmarshal(value, &rr))
+	assert.Equal(t, data.RefundRequest{Attempt: 1, PaymentID: "P2", RefundAmount: "10.00", RefundReference: "R2"}, rr, "a replay is a first attempt")
+}
+
+func TestUnitRunStopsOnPublishError(t *testing.T) {
+	sender := &mockSender{err: errors.New("broker down")}
+	k := errorTopic(t)
+	reader := &Reader{Topic: "topic", Source: SourceError, Client: k, Consumer: k, Decoder: refundDecoder}
+	publisher := &Publisher{Topic: "main", Sender: sender, Schema: refundSchema}
+
+	summary, err := Run(context.Background(), reader, Filter{}, publisher, false, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "partition 0 offset 4")
+	assert.Equal(t, Summary{Selected: 1}, summary)
+	assert.Len(t, sender.sent, 1)
+}
+
+func TestUnitRunInvalidFilter(t *testing.T) {
+	k := errorTopic(t)
+	reader := &Reader{Topic: "topic", Source: SourceError, Client: k, Consumer: k, Decoder: refundDecoder}
+
+	_, err := Run(context.Background(), reader, Filter{Class: dlq.FailureRejected}, nil, true, &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrNoFailureClass)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/metrics"
+)
+
+// PartitionStatus is the last offset consumed from a partition, and the last
+// committed, if any.
+type PartitionStatus struct {
+	Partition     int32  `json:"partition"`
+	LastConsumed  int64  `json:"last_consumed"`
+	LastCommitted *int64 `json:"last_committed,omitempty"`
+}
+
+// Status describes a consumer for operators. The partitions are those a
+// message has been consumed from since the consumer started, as the consumer
+// group does not report which partitions it is assigned.
+type Status struct {
+	Role        string            `json:"role"`
+	Group       string            `json:"group"`
+	Topic       string            `json:"topic"`
+	Paused      bool              `json:"paused"`
+	PausedSince *time.Time        `json:"paused_since,omitempty"`
+	Partitions  []PartitionStatus `json:"partitions"`
+}
+
+// Status returns the current status of the consumer.
+func (svc *Service) Status() Status {
+	status := Status{Role: svc.Role, Group: svc.Group, Topic: svc.Topic, Partitions: []PartitionStatus{}}
+
+	paused, since := svc.pause.state()
+	if paused {
+		status.Paused, status.PausedSince = true, &since
+	}
+
+	committed := svc.committed.snapshot()
+	for partition, offset := range svc.consumed.snapshot() {
+		p := PartitionStatus{Partition: partition, LastConsumed: offset}
+		if c, ok := committed[partition]; ok {
+			p.LastCommitted = &c
+		}
+		status.Partitions = append(status.Partitions, p)
+	}
+	sort.Slice(status.Partitions, func(i, j int) bool { return status.Partitions[i].Partition < status.Partitions[j].Partition })
+	return status
+}
+
+// Pause stops the workers taking further messages, once those in flight
+// finish, until Resume is called. It returns false if already paused.
+func (svc *Service) Pause() bool {
+	if !svc.pause.pause() {
+		return false
+	}
+	log.Info("consumer paused by operator", log.Data{"role": svc.Role})
+	metrics.ConsumerPaused.WithLabelValues(svc.Role).Set(1)
+	return true
+}
+
+// Resume lets a paused consumer continue. It returns false if not paused.
+func (svc *Service) Resume() bool {
+	if !svc.pause.resume() {
+		return false
+	}
+	log.Info("consumer resumed by operator", log.Data{"role": svc.Role})
+	metrics.ConsumerPaused.WithLabelValues(svc.Role).Set(0)
+	return true
+}
+
+// RecentOutcomes returns the outcomes of up to the last n messages processed,
+// most recent first.
+func (svc *Service) RecentOutcomes(n int) []Outcome {
+	return svc.outcomes.recent(n)
+}
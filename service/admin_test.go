@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitPauseGate(t *testing.T) {
+	Convey("A pause gate holds waiters back only while paused", t, func() {
+		var gate pauseGate
+
+		So(gate.wait(context.Background()), ShouldBeNil)
+		So(gate.resume(), ShouldBeFalse)
+
+		So(gate.pause(), ShouldBeTrue)
+		So(gate.pause(), ShouldBeFalse)
+		paused, since := gate.state()
+		So(paused, ShouldBeTrue)
+		So(since, ShouldHappenWithin, time.Second, time.Now())
+
+		Convey("A waiter is released when the gate is resumed", func() {
+			done := make(chan error, 1)
+			go func() { done <- gate.wait(context.Background()) }()
+
+			select {
+			case <-done:
+				So("waiter released while paused", ShouldBeEmpty)
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			So(gate.resume(), ShouldBeTrue)
+			So(<-done, ShouldBeNil)
+			paused, _ = gate.state()
+			So(paused, ShouldBeFalse)
+		})
+
+		Convey("A waiter is released with an error when its context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			So(gate.wait(ctx), ShouldEqual, context.Canceled)
+		})
+	})
+}
+
+func TestUnitOutcomeLog(t *testing.T) {
+	Convey("The outcome log keeps the most recent outcomes, newest first", t, func() {
+		log := outcomeLog{size: 3}
+		So(log.recent(5), ShouldBeEmpty)
+
+		for offset := int64(0); offset < 5; offset++ {
+			log.add(Outcome{Offset: offset})
+		}
+
+		offsets := func(outcomes []Outcome) []int64 {
+			var o []int64
+			for _, outcome := range outcomes {
+				o = append(o, outcome.Offset)
+			}
+			return o
+		}
+		So(offsets(log.recent(2)), ShouldResemble, []int64{4, 3})
+		So(offsets(log.recent(10)), ShouldResemble, []int64{4, 3, 2})
+		So(offsets(log.recent(-1)), ShouldResemble, []int64{4, 3, 2})
+	})
+}
+
+func TestUnitStatus(t *testing.T) {
+	Convey("Status reports the consumer's pause state and partition offsets", t, func() {
+		svc := &Service{Role: RoleMain, Group: "main-group", Topic: "refund-request"}
+		svc.consumed.set(1, 12)
+		svc.consumed.set(0, 7)
+		svc.committed.set(1, 10)
+
+		status := svc.Status()
+		So(status.Paused, ShouldBeFalse)
+		So(status.PausedSince, ShouldBeNil)
+		So(status.Partitions, ShouldHaveLength, 2)
+		So(status.Partitions[0], ShouldResemble, PartitionStatus{Partition: 0, LastConsumed: 7})
+		So(status.Partitions[1].LastConsumed, ShouldEqual, 12)
+		So(*status.Partitions[1].LastCommitted, ShouldEqual, 10)
+
+		So(svc.Pause(), ShouldBeTrue)
+		So(svc.Pause(), ShouldBeFalse)
+		status = svc.Status()
+		So(status.Paused, ShouldBeTrue)
+		So(status.PausedSince, ShouldNotBeNil)
+
+		So(svc.Resume(), ShouldBeTrue)
+		So(svc.Resume(), ShouldBeFalse)
+		So(svc.Status().Paused, ShouldBeFalse)
+	})
+}
+
+func TestUnitStartPaused(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a paused consumer with a message waiting", t, func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c := make(chan os.Signal)
+
+		mockPayment := payment.NewMockPayments(ctrl)
+		svc := createMockService(mockPayment)
+		svc.Consumer = createMockConsumer(&sarama.ConsumerMessage{Partition: 2, Offset: 5, Value: prepareTestKafkaMessage(1, paymentResourceID, "100.00", "ref")})
+		group := &MockGroup{}
+		svc.Consumer.Group = group
+		svc.Pause()
+
+		Convey("Then the message is only processed and committed once the consumer is resumed", func() {
+			var mu sync.Mutex
+			resumed, postedWhilePaused := false, false
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+				mu.Lock()
+				postedWhilePaused = !resumed
+				mu.Unlock()
+				endConsumerProcess(svc, c)
+			}).Return(nil).Times(1)
+
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				resumed = true
+				mu.Unlock()
+				svc.Resume()
+			}()
+			svc.Start(wg, c)
+
+			So(postedWhilePaused, ShouldBeFalse)
+			So(group.marked, ShouldResemble, []int64{5})
+			partitions := svc.Status().Partitions
+			So(partitions, ShouldHaveLength, 1)
+			So(*partitions[0].LastCommitted, ShouldEqual, 5)
+		})
+
+		Convey("Then the message is left unprocessed when the consumer shuts down while paused", func() {
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				endConsumerProcess(svc, c)
+			}()
+			svc.Start(wg, c)
+
+			So(group.marked, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// offsetTracker records the messages dispatched from each partition and which
+// of them have completed. Messages complete out of order when processed
+// concurrently, so an offset is only committable once every message before it
+// in its partition has also completed.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int32]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	// pending holds the messages dispatched but not yet committable, in the
+	// order they were dispatched, and completed whether each has completed.
+	pending   []*sarama.ConsumerMessage
+	completed map[*sarama.ConsumerMessage]bool
+}
+
+// add records that a message has been dispatched. A message at or before the
+// last dispatched offset means the partition has been rewound, for example
+// after a rebalance, so the partition's earlier messages are forgotten.
+func (t *offsetTracker) add(message *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.partitions == nil {
+		t.partitions = make(map[int32]*partitionOffsets)
+	}
+
+	p, ok := t.partitions[message.Partition]
+	if !ok || (len(p.pending) > 0 && message.Offset <= p.pending[len(p.pending)-1].Offset) {
+		p = &partitionOffsets{completed: make(map[*sarama.ConsumerMessage]bool)}
+		t.partitions[message.Partition] = p
+	}
+	p.pending = append(p.pending, message)
+	p.completed[message] = false
+}
+
+// complete records that a dispatched message has completed. If that makes
+// further offsets in the partition committable, commit is called, while the
+// tracker is locked, with the message at the highest of them. Messages
+// forgotten because their partition was rewound are ignored.
+func (t *offsetTracker) complete(message *sarama.ConsumerMessage, commit func(*sarama.ConsumerMessage)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[message.Partition]
+	if !ok {
+		return
+	}
+	if _, dispatched := p.completed[message]; !dispatched {
+		return
+	}
+	p.completed[message] = true
+
+	var highest *sarama.ConsumerMessage
+	for len(p.pending) > 0 && p.completed[p.pending[0]] {
+		highest = p.pending[0]
+		delete(p.completed, highest)
+		p.pending = p.pending[1:]
+	}
+
+	if highest != nil {
+		commit(highest)
+	}
+}
+
+// outstanding returns the number of dispatched messages whose offsets have not
+// been committed.
+func (t *offsetTracker) outstanding() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, p := range t.partitions {
+		n += len(p.pending)
+	}
+	return n
+}
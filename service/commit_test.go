@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitOffsetTracker(t *testing.T) {
+	Convey("Offsets are only committed once every earlier message in the partition has completed", t, func() {
+		tracker := &offsetTracker{}
+		var committed []int64
+		commit := func(message *sarama.ConsumerMessage) {
+			committed = append(committed, message.Offset)
+		}
+
+		messages := make([]*sarama.ConsumerMessage, 4)
+		for i := range messages {
+			messages[i] = &sarama.ConsumerMessage{Partition: 0, Offset: int64(10 + i)}
+			tracker.add(messages[i])
+		}
+		other := &sarama.ConsumerMessage{Partition: 1, Offset: 3}
+		tracker.add(other)
+
+		tracker.complete(messages[1], commit)
+		tracker.complete(messages[2], commit)
+		So(committed, ShouldBeEmpty)
+		So(tracker.outstanding(), ShouldEqual, 5)
+
+		tracker.complete(messages[0], commit)
+		So(committed, ShouldResemble, []int64{12})
+		So(tracker.outstanding(), ShouldEqual, 2)
+
+		tracker.complete(other, commit)
+		So(committed, ShouldResemble, []int64{12, 3})
+
+		Convey("A message completed twice is only committed once", func() {
+			tracker.complete(messages[0], commit)
+			So(committed, ShouldResemble, []int64{12, 3})
+		})
+
+		Convey("A rewound partition forgets the messages dispatched before it was rewound", func() {
+			redelivered := &sarama.ConsumerMessage{Partition: 0, Offset: 13}
+			tracker.add(redelivered)
+
+			tracker.complete(messages[3], commit)
+			So(committed, ShouldResemble, []int64{12, 3})
+
+			tracker.complete(redelivered, commit)
+			So(committed, ShouldResemble, []int64{12, 3, 13})
+		})
+	})
+}
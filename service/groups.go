@@ -0,0 +1,80 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/companieshouse/chs.go/kafka/resilience"
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/ladder"
+)
+
+// Assignment is the consumer group a role joins and the topic it consumes.
+type Assignment struct {
+	Role   string `json:"role"`
+	Group  string `json:"group"`
+	Topic  string `json:"topic"`
+	Active bool   `json:"active"`
+}
+
+// Assignments returns the assignment of every consumer role, marking those
+// run by this instance as active. The error role is only run by an error
+// consumer, which runs no other role. With a retry ladder, each tier is a role
+// with its own group in place of the single retry role.
+func Assignments(cfg *config.Config, tiers []ladder.Tier) []Assignment {
+	rh := resilience.NewHandler(cfg.ConsumerTopic, "refund-request-consumer", nil, nil, nil)
+
+	assignments := []Assignment{
+		{Role: RoleMain, Group: cfg.ConsumerGroupName, Topic: cfg.ConsumerTopic, Active: !cfg.IsErrorConsumer},
+	}
+	if len(tiers) == 0 {
+		assignments = append(assignments, Assignment{Role: RoleRetry, Group: cfg.ConsumerRetryGroupName, Topic: rh.GetRetryTopicName(), Active: !cfg.IsErrorConsumer})
+	}
+	for _, tier := range tiers {
+		assignments = append(assignments, Assignment{Role: TierRole(tier), Group: cfg.ConsumerRetryGroupName + "-" + tier.Name, Topic: tier.Topic, Active: !cfg.IsErrorConsumer})
+	}
+	return append(assignments, Assignment{Role: RoleError, Group: cfg.ConsumerErrorGroupName, Topic: rh.GetErrorTopicName(), Active: cfg.IsErrorConsumer})
+}
+
+// TierRole returns the role of the consumer of a retry ladder tier.
+func TierRole(tier ladder.Tier) string {
+	return RoleRetry + "-" + tier.Name
+}
+
+// ValidateAssignments returns an error if a role has no consumer group, or if
+// roles share a group or topic. A shared group would share offsets between
+// topics and rebalance each role's partitions onto the others.
+func ValidateAssignments(assignments []Assignment) error {
+	groups := make(map[string]string, len(assignments))
+	topics := make(map[string]string, len(assignments))
+	for _, a := range assignments {
+		if a.Group == "" {
+			return fmt.Errorf("no consumer group configured for the %s role", a.Role)
+		}
+		if owner, ok := groups[a.Group]; ok {
+			return fmt.Errorf("consumer group [%s] is used by both the %s and %s roles", a.Group, owner, a.Role)
+		}
+		if owner, ok := topics[a.Topic]; ok {
+			return fmt.Errorf("topic [%s] is consumed by both the %s and %s roles", a.Topic, owner, a.Role)
+		}
+		groups[a.Group] = a.Role
+		topics[a.Topic] = a.Role
+	}
+	return nil
+}
+
+// GroupFor returns the consumer group assigned to role, or an empty string if
+// it has none.
+func GroupFor(assignments []Assignment, role string) string {
+	a, _ := AssignmentFor(assignments, role)
+	return a.Group
+}
+
+// AssignmentFor returns the assignment of role, and false if it has none.
+func AssignmentFor(assignments []Assignment, role string) (Assignment, bool) {
+	for _, a := range assignments {
+		if a.Role == role {
+			return a, true
+		}
+	}
+	return Assignment{}, false
+}
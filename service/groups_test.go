@@ -0,0 +1,76 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/ladder"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitAssignments(t *testing.T) {
+	Convey("Each consumer role is assigned its own group", t, func() {
+		cfg := &config.Config{
+			ConsumerTopic:          "refund-request",
+			ConsumerGroupName:      "refund-request-consumer",
+			ConsumerRetryGroupName: "refund-request-consumer-retry",
+			ConsumerErrorGroupName: "refund-request-consumer-error",
+		}
+
+		assignments := Assignments(cfg, nil)
+		So(ValidateAssignments(assignments), ShouldBeNil)
+		So(GroupFor(assignments, RoleMain), ShouldEqual, "refund-request-consumer")
+		So(GroupFor(assignments, RoleRetry), ShouldEqual, "refund-request-consumer-retry")
+		So(GroupFor(assignments, RoleError), ShouldEqual, "refund-request-consumer-error")
+		So(GroupFor(assignments, "unknown"), ShouldBeEmpty)
+
+		a, ok := AssignmentFor(assignments, RoleError)
+		So(ok, ShouldBeTrue)
+		So(a.Topic, ShouldEqual, "refund-request-refund-request-consumer-error")
+		_, ok = AssignmentFor(assignments, "unknown")
+		So(ok, ShouldBeFalse)
+
+		Convey("Only the main and retry roles are active unless running as an error consumer", func() {
+			So(assignments[0].Active, ShouldBeTrue)
+			So(assignments[1].Active, ShouldBeTrue)
+			So(assignments[2].Active, ShouldBeFalse)
+
+			cfg.IsErrorConsumer = true
+			assignments = Assignments(cfg, nil)
+			So(assignments[0].Active, ShouldBeFalse)
+			So(assignments[1].Active, ShouldBeFalse)
+			So(assignments[2].Active, ShouldBeTrue)
+		})
+
+		Convey("Roles sharing a group are refused", func() {
+			cfg.ConsumerRetryGroupName = cfg.ConsumerGroupName
+			err := ValidateAssignments(Assignments(cfg, nil))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "used by both the main and retry roles")
+		})
+
+		Convey("A role without a group is refused", func() {
+			cfg.ConsumerErrorGroupName = ""
+			So(ValidateAssignments(Assignments(cfg, nil)), ShouldNotBeNil)
+		})
+
+		Convey("With a retry ladder each tier has its own role and group in place of the retry role", func() {
+			tiers, _ := ladder.ParseTiers(cfg.ConsumerTopic, []string{"1m,1h"})
+			assignments := Assignments(cfg, tiers)
+			So(ValidateAssignments(assignments), ShouldBeNil)
+			So(assignments, ShouldHaveLength, 4)
+			So(GroupFor(assignments, RoleRetry), ShouldBeEmpty)
+			So(assignments[1], ShouldResemble, Assignment{Role: "retry-1m", Group: "refund-request-consumer-retry-1m", Topic: "refund-request-retry-1m", Active: true})
+			So(GroupFor(assignments, TierRole(tiers[1])), ShouldEqual, "refund-request-consumer-retry-1h")
+		})
+
+		Convey("Roles consuming the same topic are refused", func() {
+			err := ValidateAssignments([]Assignment{
+				{Role: RoleMain, Group: "a", Topic: "refund-request"},
+				{Role: RoleRetry, Group: "b", Topic: "refund-request"},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "consumed by both")
+		})
+	})
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/hold"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/metrics"
+	"github.com/companieshouse/refund-request-consumer/policy"
+)
+
+// ErrHoldNotFound is returned when no held refund request awaiting a decision
+// has the requested ID.
+var ErrHoldNotFound = errors.New("held refund request not found")
+
+// PolicyLimits returns the configured refund policy limits.
+func PolicyLimits(cfg *config.Config) policy.Limits {
+	return policy.Limits{
+		HoldAbove:       int64(cfg.PolicyHoldAbove),
+		DailyPerPayment: int64(cfg.PolicyDailyPayment),
+		Hourly:          int64(cfg.PolicyHourly),
+	}
+}
+
+// Holds parks refund requests breaching the refund policy on the held topic
+// until an operator approves or rejects them. Approved refunds are submitted
+// by the submitter.
+type Holds struct {
+	Publisher *hold.Publisher
+	Ledger    *hold.Ledger
+	Policy    *policy.Engine
+	Submitter *Service
+
+	now func() time.Time
+	// mu serialises decisions, so that a refund is only submitted once
+	// however many times it is approved.
+	mu sync.Mutex
+}
+
+// NewHolds returns holds publishing to the held topic and tracking the
+// requests awaiting a decision in the ledger.
+func NewHolds(publisher *hold.Publisher, ledger *hold.Ledger, engine *policy.Engine, submitter *Service) *Holds {
+	return &Holds{
+		Publisher: publisher,
+		Ledger:    ledger,
+		Policy:    engine,
+		Submitter: submitter,
+		now:       time.Now,
+	}
+}
+
+// Pending returns the held refund requests awaiting a decision, oldest
+// first.
+func (h *Holds) Pending() []hold.Record {
+	return h.Ledger.Pending()
+}
+
+// Apply updates the ledger with a record, such as one read from the held
+// topic. Approved refunds are counted towards the refund policy totals.
+func (h *Holds) Apply(r hold.Record) {
+	h.Ledger.Apply(r)
+	if r.State == hold.StateApproved && h.Policy != nil {
+		h.Policy.Record(r.ID, r.PaymentID, r.AmountPence, r.DecidedAt)
+	}
+}
+
+// RecordOutcome counts a refund which succeeded, as recorded by an outcome
+// event, towards the refund policy totals. Refunds are counted by their
+// idempotency key, so one already admitted by this consumer is not counted
+// twice.
+func RecordOutcome(engine *policy.Engine, e data.RefundRequestOutcome) {
+	if e.Outcome != OutcomeSucceeded {
+		return
+	}
+	engine.Record(hold.ID(e.PaymentID, e.AmountPence, e.RefundReference), e.PaymentID, e.AmountPence, time.UnixMilli(e.ProcessedAt))
+}
+
+// park publishes a held refund request to the held topic.
+func (h *Holds) park(r hold.Record) error {
+	if err := h.Publisher.Publish(r); err != nil {
+		return err
+	}
+	h.Ledger.Apply(r)
+	return nil
+}
+
+// Approve submits a held refund request to the payments api and records its
+// approval. The request stays held if it cannot be submitted.
+func (h *Holds) Approve(ctx context.Context, id, note string) (hold.Record, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.Ledger.Get(id)
+	if !ok {
+		return hold.Record{}, ErrHoldNotFound
+	}
+	logData := log.Data{"hold_id": id, "payment_id": r.PaymentID}
+
+	rr := r.Request()
+	amount, err := h.Submitter.rules().Validate(rr)
+	if err != nil {
+		return hold.Record{}, fmt.Errorf("error submitting approved refund: %w", err)
+	}
+
+	// An approved refund is submitted without applying the refund policy
+	// again, which would hold it once more. Its outcome is published, as for
+	// a consumed message, whether or not it is submitted.
+	o, err := h.Submitter.submit(ctx, rr, amount)
+	o.Topic, o.Partition, o.Offset = r.Topic, r.Partition, r.Offset
+	if err != nil {
+		o.Outcome, o.Destination = OutcomeHeld, h.Publisher.Topic
+		if publishErr := h.Submitter.publishOutcome(o); publishErr != nil {
+			log.Error(publishErr, logData)
+		}
+		return hold.Record{}, fmt.Errorf("error submitting approved refund: %w", err)
+	}
+	if err := h.Submitter.publishOutcome(o); err != nil {
+		log.Error(err, logData)
+	}
+
+	// The refund has been submitted, so it is approved even if the decision
+	// cannot be published. Were it held again after a restart, approving it
+	// again would not submit it twice, as it has the same idempotency key.
+	approved := r.Decide(hold.StateApproved, note, h.now())
+	if err := h.Publisher.Publish(approved); err != nil {
+		log.Error(err, logData)
+	}
+	h.Apply(approved)
+	log.Info(fmt.Sprintf("held refund request approved for Payment ID: [%s]", r.PaymentID), logData)
+	return approved, nil
+}
+
+// Reject records the rejection of a held refund request, which is never
+// submitted.
+func (h *Holds) Reject(id, note string) (hold.Record, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.Ledger.Get(id)
+	if !ok {
+		return hold.Record{}, ErrHoldNotFound
+	}
+
+	rejected := r.Decide(hold.StateRejected, note, h.now())
+	if err := h.Publisher.Publish(rejected); err != nil {
+		return hold.Record{}, err
+	}
+	h.Ledger.Apply(rejected)
+	log.Info(fmt.Sprintf("held refund request rejected for Payment ID: [%s]", r.PaymentID), log.Data{"hold_id": id, "payment_id": r.PaymentID})
+	return rejected, nil
+}
+
+// admit applies the refund policy. Refunds which have already succeeded are
+// allowed without being counted, as they are not submitted again.
+func (svc *Service) admit(rr data.RefundRequest, amount int) policy.Decision {
+	if svc.Policy == nil {
+		return policy.Decision{Action: policy.Allow}
+	}
+	record, found, err := svc.Dedupe.Get(idempotency.Key(rr.PaymentID, rr.RefundReference))
+	if err == nil && found && record.State == idempotency.Succeeded {
+		return policy.Decision{Action: policy.Allow}
+	}
+	return svc.Policy.Admit(hold.ID(rr.PaymentID, int64(amount), rr.RefundReference), rr.PaymentID, int64(amount))
+}
+
+// hold parks a refund request on the held topic, retrying until it is
+// published. It returns false if the context is cancelled first, in which case
+// the message's offset must not be committed.
+func (svc *Service) hold(ctx context.Context, message *sarama.ConsumerMessage, r hold.Record) bool {
+	logData := log.Data{"message_offset": message.Offset, "payment_id": r.PaymentID, "hold_id": r.ID}
+	for {
+		err := svc.Holds.park(r)
+		svc.Health.ProducerResult(err)
+		if err == nil {
+			metrics.MessagesRedirected.WithLabelValues(svc.Role, metrics.DestinationHeld).Inc()
+			log.Info(fmt.Sprintf("refund request held for approval on topic [%s]: %s", svc.Holds.Publisher.Topic, r.Reason), logData)
+			return true
+		}
+
+		log.Error(err, logData)
+
+		select {
+		case <-ctx.Done():
+			log.Info("Shutting down, refund request not held", logData)
+			return false
+		case <-time.After(publishRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/avro"
+	"github.com/companieshouse/refund-request-consumer/audit"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/hold"
+	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/companieshouse/refund-request-consumer/policy"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Refunds breaching the refund policy", t, func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c := make(chan os.Signal)
+
+		mockPayment := payment.NewMockPayments(ctrl)
+		svc := createMockService(mockPayment)
+		svc.Policy = policy.New(policy.Limits{HoldAbove: 5000})
+		held := &mockSender{}
+		svc.Holds = NewHolds(hold.NewPublisher("refund-request-held", held), hold.NewLedger(), svc.Policy, svc)
+
+		Convey("Given a refund above the approval threshold", func() {
+			svc.Consumer = createMockConsumerWithMessage(1, paymentResourceID, "100.00", "ref")
+
+			Convey("Then it is held for approval and not sent to the Payments API", func() {
+				held.onSend = func() { endConsumerProcess(svc, c) }
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				svc.Start(wg, c)
+
+				So(held.sent, ShouldHaveLength, 1)
+				So(held.sent[0].Topic, ShouldEqual, "refund-request-held")
+				pending := svc.Holds.Pending()
+				So(pending, ShouldHaveLength, 1)
+				So(pending[0].PaymentID, ShouldEqual, paymentResourceID)
+				So(pending[0].AmountPence, ShouldEqual, 10000)
+				So(pending[0].Reason, ShouldContainSubstring, "approval threshold")
+
+				outcome := svc.RecentOutcomes(1)[0]
+				So(outcome.Outcome, ShouldEqual, OutcomeHeld)
+				So(outcome.Destination, ShouldEqual, "refund-request-held")
+			})
+		})
+
+		Convey("Given a refund within the limits", func() {
+			svc.Consumer = createMockConsumerWithMessage(1, paymentResourceID, "50.00", "ref")
+
+			Convey("Then it is sent to the Payments API", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(nil).Times(1)
+
+				svc.Start(wg, c)
+
+				So(held.sent, ShouldBeEmpty)
+				So(svc.RecentOutcomes(1)[0].Outcome, ShouldEqual, OutcomeSucceeded)
+			})
+		})
+	})
+}
+
+func TestUnitHolds(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Held refund requests", t, func() {
+		mockPayment := payment.NewMockPayments(ctrl)
+		submitter := createMockService(mockPayment)
+		sender := &mockSender{}
+		engine := policy.New(policy.Limits{Hourly: 20000})
+		holds := NewHolds(hold.NewPublisher("refund-request-held", sender), hold.NewLedger(), engine, submitter)
+
+		rr := data.RefundRequest{Attempt: 1, PaymentID: paymentResourceID, RefundAmount: "100.00", RefundReference: "ref"}
+		r := hold.New(rr, 10000, "over threshold", &sarama.ConsumerMessage{Topic: "refund-request", Offset: 3}, time.Now())
+		So(holds.park(r), ShouldBeNil)
+		So(holds.Pending(), ShouldHaveLength, 1)
+
+		Convey("An approved request is submitted and no longer held", func() {
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds", refundPostRequest, gomock.Any(), gomock.Any(), apiKey).Return(nil)
+
+			approved, err := holds.Approve(context.Background(), r.ID, "checked with finance")
+			So(err, ShouldBeNil)
+			So(approved.State, ShouldEqual, hold.StateApproved)
+			So(approved.Note, ShouldEqual, "checked with finance")
+			So(holds.Pending(), ShouldBeEmpty)
+			So(sender.sent, ShouldHaveLength, 2)
+			So(engine.Admit("other", "other", 10001).Action, ShouldEqual, policy.Hold)
+
+			_, err = holds.Approve(context.Background(), r.ID, "")
+			So(err, ShouldEqual, ErrHoldNotFound)
+		})
+
+		Convey("An approved request publishes its outcome event", func() {
+			outcomes := &mockSender{}
+			submitter.Audit = audit.New("refund-request-outcome", outcomes, &avro.Schema{Definition: outcomeSchema})
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+			_, err := holds.Approve(context.Background(), r.ID, "")
+			So(err, ShouldBeNil)
+			So(outcomes.sent, ShouldHaveLength, 1)
+			So(outcomes.sent[0].Topic, ShouldEqual, "refund-request-outcome")
+			value, _ := outcomes.sent[0].Value.Encode()
+			var event data.RefundRequestOutcome
+			So(submitter.Audit.Schema.(*avro.Schema).Unmarshal(value, &event), ShouldBeNil)
+			So(event.PaymentID, ShouldEqual, paymentResourceID)
+			So(event.AmountPence, ShouldEqual, 10000)
+			So(event.Outcome, ShouldEqual, OutcomeSucceeded)
+			So(event.Topic, ShouldEqual, "refund-request")
+			So(event.Offset, ShouldEqual, 3)
+
+			Convey("which counts it towards the totals of the consumers following the topic", func() {
+				follower := policy.New(policy.Limits{Hourly: 20000})
+				RecordOutcome(follower, event)
+				So(follower.Admit("other", "other", 10001).Action, ShouldEqual, policy.Hold)
+			})
+		})
+
+		Convey("A request which cannot be submitted publishes its outcome and stays held", func() {
+			outcomes := &mockSender{}
+			submitter.Audit = audit.New("refund-request-outcome", outcomes, &avro.Schema{Definition: outcomeSchema})
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("payments api unavailable"))
+
+			_, err := holds.Approve(context.Background(), r.ID, "")
+			So(err, ShouldNotBeNil)
+			So(holds.Pending(), ShouldHaveLength, 1)
+			So(outcomes.sent, ShouldHaveLength, 1)
+			value, _ := outcomes.sent[0].Value.Encode()
+			var event data.RefundRequestOutcome
+			So(submitter.Audit.Schema.(*avro.Schema).Unmarshal(value, &event), ShouldBeNil)
+			So(event.Outcome, ShouldEqual, OutcomeHeld)
+			So(event.FailureClass, ShouldNotBeEmpty)
+		})
+
+		Convey("An approval read from the held topic is counted towards the totals", func() {
+			holds.Apply(r.Decide(hold.StateApproved, "", time.Now()))
+
+			So(holds.Pending(), ShouldBeEmpty)
+			So(engine.Admit("other", "other", 10001).Action, ShouldEqual, policy.Hold)
+		})
+
+		Convey("A request which cannot be submitted stays held", func() {
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("payments api unavailable"))
+
+			_, err := holds.Approve(context.Background(), r.ID, "")
+			So(err, ShouldNotBeNil)
+			So(holds.Pending(), ShouldHaveLength, 1)
+		})
+
+		Convey("A rejected request is never submitted", func() {
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			rejected, err := holds.Reject(r.ID, "upstream bug")
+			So(err, ShouldBeNil)
+			So(rejected.State, ShouldEqual, hold.StateRejected)
+			So(holds.Pending(), ShouldBeEmpty)
+
+			_, err = holds.Reject(r.ID, "")
+			So(err, ShouldEqual, ErrHoldNotFound)
+		})
+
+		Convey("A request stays held if its rejection cannot be published", func() {
+			sender.err = errors.New("broker down")
+
+			_, err := holds.Reject(r.ID, "")
+			So(err, ShouldNotBeNil)
+			So(holds.Pending(), ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestUnitRecordOutcome(t *testing.T) {
+	Convey("Refunds recorded by outcome events", t, func() {
+		engine := policy.New(policy.Limits{Hourly: 20000})
+		succeeded := data.RefundRequestOutcome{PaymentID: paymentResourceID, RefundReference: "ref", AmountPence: 10000, Outcome: OutcomeSucceeded, ProcessedAt: time.Now().UnixMilli()}
+
+		Convey("are counted towards the totals once they succeed", func() {
+			RecordOutcome(engine, succeeded)
+			RecordOutcome(engine, succeeded)
+			So(engine.Admit("other", "other", 10000).Action, ShouldEqual, policy.Allow)
+			So(engine.Admit("another", "another", 1).Action, ShouldEqual, policy.Hold)
+		})
+
+		Convey("are not counted again when admitted by this consumer", func() {
+			d := engine.Admit(hold.ID(paymentResourceID, 10000, "ref"), paymentResourceID, 10000)
+			So(d.Action, ShouldEqual, policy.Allow)
+			RecordOutcome(engine, succeeded)
+			So(engine.Admit("other", "other", 10000).Action, ShouldEqual, policy.Allow)
+		})
+
+		Convey("are not counted unless they succeeded", func() {
+			failed := succeeded
+			failed.Outcome = OutcomeDeadLettered
+			RecordOutcome(engine, failed)
+			So(engine.Admit("other", "other", 20000).Action, ShouldEqual, policy.Allow)
+		})
+	})
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/metrics"
+)
+
+// lagInterval is how often consumer lag is recalculated.
+var lagInterval = 30 * time.Second
+
+// OffsetFetcher looks up partition offsets. It is satisfied by sarama.Client.
+type OffsetFetcher interface {
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+	Close() error
+}
+
+// consumedOffsets records the last offset consumed from each partition.
+type consumedOffsets struct {
+	mu      sync.Mutex
+	offsets map[int32]int64
+}
+
+func (o *consumedOffsets) set(partition int32, offset int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.offsets == nil {
+		o.offsets = make(map[int32]int64)
+	}
+	o.offsets[partition] = offset
+}
+
+func (o *consumedOffsets) snapshot() map[int32]int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	snapshot := make(map[int32]int64, len(o.offsets))
+	for partition, offset := range o.offsets {
+		snapshot[partition] = offset
+	}
+	return snapshot
+}
+
+// monitorLag updates the consumer lag metric every lagInterval until ctx is
+// cancelled. Lag is only reported for partitions a message has been consumed
+// from.
+func (svc *Service) monitorLag(ctx context.Context) {
+	if svc.Offsets == nil {
+		return
+	}
+
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			svc.updateLag()
+		}
+	}
+}
+
+func (svc *Service) updateLag() {
+	for partition, offset := range svc.consumed.snapshot() {
+		newest, err := svc.Offsets.GetOffset(svc.Topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			log.Error(err, log.Data{"topic": svc.Topic, "partition": partition})
+			continue
+		}
+
+		lag := newest - offset - 1
+		if lag < 0 {
+			lag = 0
+		}
+		metrics.ConsumerLag.WithLabelValues(svc.Role, svc.Topic, strconv.Itoa(int(partition))).Set(float64(lag))
+	}
+}
+
+// TopicOffsets looks up the partitions of a topic and their offsets. It is
+// satisfied by sarama.Client.
+type TopicOffsets interface {
+	Partitions(topic string) ([]int32, error)
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
+
+// GroupOffsets looks up the offsets committed by a consumer group. It is
+// satisfied by sarama.ClusterAdmin.
+type GroupOffsets interface {
+	ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error)
+}
+
+// PartitionLag is how far the consumer group of a role is behind the tail of
+// one partition of its topic. Committed is -1 if the group has committed no
+// offset, in which case every message still held is counted as lag.
+type PartitionLag struct {
+	Role      string `json:"role"`
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"`
+	Newest    int64  `json:"newest"`
+	Lag       int64  `json:"lag"`
+}
+
+// GroupLag returns the lag of every partition consumed by each assignment,
+// from the offsets committed by its group.
+func GroupLag(assignments []Assignment, topics TopicOffsets, groups GroupOffsets) ([]PartitionLag, error) {
+	var lags []PartitionLag
+	for _, a := range assignments {
+		partitions, err := topics.Partitions(a.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("error listing partitions of topic [%s]: %w", a.Topic, err)
+		}
+
+		committed, err := groups.ListConsumerGroupOffsets(a.Group, map[string][]int32{a.Topic: partitions})
+		if err != nil {
+			return nil, fmt.Errorf("error fetching offsets of consumer group [%s]: %w", a.Group, err)
+		}
+
+		for _, partition := range partitions {
+			lag := PartitionLag{Role: a.Role, Group: a.Group, Topic: a.Topic, Partition: partition, Committed: -1}
+			if lag.Newest, err = topics.GetOffset(a.Topic, partition, sarama.OffsetNewest); err != nil {
+				return nil, fmt.Errorf("error getting tail offset of topic [%s] partition %d: %w", a.Topic, partition, err)
+			}
+
+			var consumed int64
+			if block := committed.GetBlock(a.Topic, partition); block != nil && block.Err == sarama.ErrNoError && block.Offset >= 0 {
+				lag.Committed = block.Offset
+				consumed = block.Offset
+			} else if consumed, err = topics.GetOffset(a.Topic, partition, sarama.OffsetOldest); err != nil {
+				return nil, fmt.Errorf("error getting oldest offset of topic [%s] partition %d: %w", a.Topic, partition, err)
+			}
+
+			if lag.Lag = lag.Newest - consumed; lag.Lag < 0 {
+				lag.Lag = 0
+			}
+			lags = append(lags, lag)
+		}
+	}
+	return lags, nil
+}
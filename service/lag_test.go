@@ -0,0 +1,116 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type mockOffsetFetcher struct {
+	newest map[int32]int64
+}
+
+func (m mockOffsetFetcher) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	if time != sarama.OffsetNewest {
+		return 0, errors.New("unexpected offset time")
+	}
+	newest, ok := m.newest[partition]
+	if !ok {
+		return 0, errors.New("unknown partition")
+	}
+	return newest, nil
+}
+
+func (m mockOffsetFetcher) Close() error {
+	return nil
+}
+
+func TestUnitUpdateLag(t *testing.T) {
+	Convey("Consumer lag is calculated from the last consumed offset of each partition", t, func() {
+		svc := &Service{
+			Topic:   "lag-test",
+			Role:    RoleMain,
+			Offsets: mockOffsetFetcher{newest: map[int32]int64{0: 10, 1: 5}},
+		}
+		svc.consumed.set(0, 6)
+		svc.consumed.set(1, 4)
+		svc.consumed.set(2, 1)
+
+		svc.updateLag()
+
+		So(testutil.ToFloat64(metrics.ConsumerLag.WithLabelValues(RoleMain, "lag-test", "0")), ShouldEqual, 3)
+		So(testutil.ToFloat64(metrics.ConsumerLag.WithLabelValues(RoleMain, "lag-test", "1")), ShouldEqual, 0)
+	})
+}
+
+type mockTopicOffsets struct {
+	partitions []int32
+	oldest     map[int32]int64
+	newest     map[int32]int64
+}
+
+func (m mockTopicOffsets) Partitions(topic string) ([]int32, error) {
+	return m.partitions, nil
+}
+
+func (m mockTopicOffsets) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	if time == sarama.OffsetOldest {
+		return m.oldest[partition], nil
+	}
+	return m.newest[partition], nil
+}
+
+type mockGroupOffsets struct {
+	committed map[string]map[int32]int64
+	err       error
+}
+
+func (m mockGroupOffsets) ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	response := &sarama.OffsetFetchResponse{}
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			offset, ok := m.committed[group][partition]
+			if !ok {
+				offset = -1
+			}
+			response.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: offset, Err: sarama.ErrNoError})
+		}
+	}
+	return response, nil
+}
+
+func TestUnitGroupLag(t *testing.T) {
+	Convey("Consumer group lag is calculated from the offsets committed by each group", t, func() {
+		assignments := []Assignment{
+			{Role: RoleMain, Group: "main-group", Topic: "refund-request"},
+			{Role: RoleError, Group: "error-group", Topic: "refund-request-error"},
+		}
+		topics := mockTopicOffsets{
+			partitions: []int32{0, 1},
+			oldest:     map[int32]int64{0: 2, 1: 0},
+			newest:     map[int32]int64{0: 10, 1: 5},
+		}
+		groups := mockGroupOffsets{committed: map[string]map[int32]int64{"main-group": {0: 7, 1: 5}}}
+
+		lags, err := GroupLag(assignments, topics, groups)
+		So(err, ShouldBeNil)
+		So(lags, ShouldResemble, []PartitionLag{
+			{Role: RoleMain, Group: "main-group", Topic: "refund-request", Partition: 0, Committed: 7, Newest: 10, Lag: 3},
+			{Role: RoleMain, Group: "main-group", Topic: "refund-request", Partition: 1, Committed: 5, Newest: 5, Lag: 0},
+			{Role: RoleError, Group: "error-group", Topic: "refund-request-error", Partition: 0, Committed: -1, Newest: 10, Lag: 8},
+			{Role: RoleError, Group: "error-group", Topic: "refund-request-error", Partition: 1, Committed: -1, Newest: 5, Lag: 5},
+		})
+
+		Convey("A failure to fetch committed offsets is returned", func() {
+			_, err := GroupLag(assignments, topics, mockGroupOffsets{err: errors.New("coordinator unavailable")})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+)
+
+// Outcomes of processing a message.
+const (
+	OutcomeSucceeded        = "succeeded"
+	OutcomeAlreadySucceeded = "already_succeeded"
+	OutcomeRetried          = "retried"
+	OutcomeErrorTopic       = "error_topic"
+	OutcomeDeadLettered     = "dead_lettered"
+	OutcomeHeld             = "held"
+	OutcomeCancelled        = "cancelled"
+)
+
+// Outcome records what was decided for a message. A cancelled message was
+// left uncommitted, so is consumed again after restart.
+type Outcome struct {
+	Role            string    `json:"role"`
+	Topic           string    `json:"topic"`
+	Partition       int32     `json:"partition"`
+	Offset          int64     `json:"offset"`
+	PaymentID       string    `json:"payment_id,omitempty"`
+	RefundReference string    `json:"refund_reference,omitempty"`
+	RefundAmount    string    `json:"refund_amount,omitempty"`
+	AmountPence     int64     `json:"amount_pence,omitempty"`
+	Attempt         int32     `json:"attempt"`
+	Outcome         string    `json:"outcome"`
+	FailureClass    string    `json:"failure_class,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	Destination     string    `json:"destination,omitempty"`
+	HTTPStatus      int       `json:"http_status,omitempty"`
+	LatencyMillis   int64     `json:"latency_ms,omitempty"`
+	ProcessedAt     time.Time `json:"processed_at"`
+}
+
+// event returns the audit event recording the outcome.
+func (o Outcome) event() data.RefundRequestOutcome {
+	return data.RefundRequestOutcome{
+		PaymentID:       o.PaymentID,
+		RefundReference: o.RefundReference,
+		AmountPence:     o.AmountPence,
+		Attempt:         o.Attempt,
+		Outcome:         o.Outcome,
+		FailureClass:    o.FailureClass,
+		HTTPStatus:      int32(o.HTTPStatus),
+		LatencyMillis:   o.LatencyMillis,
+		Role:            o.Role,
+		Topic:           o.Topic,
+		Partition:       o.Partition,
+		Offset:          o.Offset,
+		ProcessedAt:     o.ProcessedAt.UnixMilli(),
+	}
+}
+
+// defaultOutcomeLogSize is the number of outcomes kept when none is
+// configured.
+const defaultOutcomeLogSize = 100
+
+// outcomeLog keeps the most recent outcomes. The zero value keeps
+// defaultOutcomeLogSize.
+type outcomeLog struct {
+	mu       sync.Mutex
+	size     int
+	outcomes []Outcome
+	next     int
+}
+
+func (l *outcomeLog) add(o Outcome) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := l.size
+	if size < 1 {
+		size = defaultOutcomeLogSize
+	}
+	if len(l.outcomes) < size {
+		l.outcomes = append(l.outcomes, o)
+		return
+	}
+	l.outcomes[l.next] = o
+	l.next = (l.next + 1) % size
+}
+
+// recent returns up to n outcomes, most recent first.
+func (l *outcomeLog) recent(n int) []Outcome {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n > len(l.outcomes) || n < 0 {
+		n = len(l.outcomes)
+	}
+	recent := make([]Outcome, 0, n)
+	for i := 0; i < n; i++ {
+		// The newest outcome is just before next, wrapping to the end.
+		idx := (l.next - 1 - i + 2*len(l.outcomes)) % len(l.outcomes)
+		recent = append(recent, l.outcomes[idx])
+	}
+	return recent
+}
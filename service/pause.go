@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pauseGate holds workers back from processing messages while it is paused.
+// The zero value is not paused.
+type pauseGate struct {
+	mu      sync.Mutex
+	paused  bool
+	since   time.Time
+	resumed chan struct{}
+}
+
+// pause closes the gate, returning false if it was already paused.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.paused {
+		return false
+	}
+	g.paused = true
+	g.since = time.Now()
+	g.resumed = make(chan struct{})
+	return true
+}
+
+// resume opens the gate, releasing waiting workers, returning false if it was
+// not paused.
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	close(g.resumed)
+	return true
+}
+
+// state returns whether the gate is paused, and since when.
+func (g *pauseGate) state() (bool, time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.paused, g.since
+}
+
+// wait blocks while the gate is paused, returning an error if ctx is or
+// becomes done first.
+func (g *pauseGate) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	paused, resumed := g.paused, g.resumed
+	g.mu.Unlock()
+
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
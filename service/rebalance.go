@@ -0,0 +1,42 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/companieshouse/chs.go/log"
+	cluster "gopkg.in/bsm/sarama-cluster.v2"
+)
+
+// notifier is satisfied by a group consumer which reports rebalances of its
+// group, such as a sarama-cluster consumer.
+type notifier interface {
+	Notifications() <-chan *cluster.Notification
+}
+
+// notifications returns the rebalance notifications of the group consumer,
+// or nil if it does not report them.
+func (svc *Service) notifications() <-chan *cluster.Notification {
+	if n, ok := svc.Consumer.GConsumer.(notifier); ok {
+		return n.Notifications()
+	}
+	return nil
+}
+
+// rebalanced records a change to the consumer's group membership. The
+// consumer is not a member of its group from the start of a rebalance until
+// it completes.
+func (svc *Service) rebalanced(n *cluster.Notification) {
+	logData := log.Data{"topic": svc.Topic, "group": svc.Group}
+	switch n.Type {
+	case cluster.RebalanceStart:
+		log.Info("consumer group rebalance started", logData)
+		svc.Health.Joined(false)
+	case cluster.RebalanceOK:
+		logData["partitions"] = n.Current[svc.Topic]
+		log.Info("consumer group rebalanced", logData)
+		svc.Health.Joined(true)
+	case cluster.RebalanceError:
+		log.Error(errors.New("consumer group rebalance failed"), logData)
+		svc.Health.Joined(false)
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	consumer "github.com/companieshouse/chs.go/kafka/consumer/cluster"
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+	cluster "gopkg.in/bsm/sarama-cluster.v2"
+)
+
+// notifyingConsumer is a MockConsumer which also reports consumer errors and
+// rebalances of its group.
+type notifyingConsumer struct {
+	MockConsumer
+	errors        chan error
+	notifications chan *cluster.Notification
+}
+
+func (m notifyingConsumer) Errors() <-chan error {
+	return m.errors
+}
+
+func (m notifyingConsumer) Notifications() <-chan *cluster.Notification {
+	return m.notifications
+}
+
+// waitForConsumerCheck waits for the consumer check to report the status.
+func waitForConsumerCheck(svc *Service, status string) string {
+	deadline := time.Now().Add(time.Second)
+	for svc.Health.ConsumerCheck().Status != status && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return svc.Health.ConsumerCheck().Status
+}
+
+func TestUnitRebalance(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a consumer which has joined its group", t, func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c := make(chan os.Signal)
+
+		svc := createMockService(payment.NewMockPayments(ctrl))
+		gc := notifyingConsumer{
+			MockConsumer:  newMockConsumer(),
+			errors:        make(chan error),
+			notifications: make(chan *cluster.Notification),
+		}
+		svc.Consumer = &consumer.GroupConsumer{GConsumer: gc, Group: &MockGroup{}}
+		svc.Health.Joined(true)
+
+		done := make(chan struct{})
+		go func() {
+			svc.Start(wg, c)
+			close(done)
+		}()
+		Reset(func() {
+			endConsumerProcess(svc, c)
+			<-done
+		})
+
+		Convey("Then it is not ready while its group rebalances, and ready again once the rebalance completes", func() {
+			gc.notifications <- &cluster.Notification{Type: cluster.RebalanceStart}
+			So(waitForConsumerCheck(svc, health.StatusFail), ShouldEqual, health.StatusFail)
+
+			gc.notifications <- &cluster.Notification{Type: cluster.RebalanceOK, Current: map[string][]int32{"test": {0, 1}}}
+			So(waitForConsumerCheck(svc, health.StatusOK), ShouldEqual, health.StatusOK)
+		})
+
+		Convey("Then it is not ready once a rebalance fails", func() {
+			gc.notifications <- &cluster.Notification{Type: cluster.RebalanceError}
+			So(waitForConsumerCheck(svc, health.StatusFail), ShouldEqual, health.StatusFail)
+		})
+
+		Convey("Then it is not ready once its notifications stop", func() {
+			close(gc.notifications)
+			So(waitForConsumerCheck(svc, health.StatusFail), ShouldEqual, health.StatusFail)
+		})
+
+		Convey("Then it is not ready after repeated consumer errors, and ready again once it rejoins", func() {
+			for i := 0; i < 5; i++ {
+				gc.errors <- errors.New("kafka: error while consuming")
+			}
+			So(waitForConsumerCheck(svc, health.StatusFail), ShouldEqual, health.StatusFail)
+
+			gc.notifications <- &cluster.Notification{Type: cluster.RebalanceOK}
+			So(waitForConsumerCheck(svc, health.StatusOK), ShouldEqual, health.StatusOK)
+		})
+	})
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/companieshouse/refund-request-consumer/audit"
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/registry"
+)
+
+// RefundRequestSubject is the schema registry subject of refund requests.
+const RefundRequestSubject = "refund-request"
+
+// Schemas provides the schemas of the messages the services read and write,
+// shared by every service in the process. Outcome is nil when outcome events
+// are not published.
+type Schemas struct {
+	RefundRequest *registry.Provider
+	Outcome       *registry.Provider
+}
+
+// NewSchemas loads the schemas from the schema registry or, if it is
+// unavailable, from the on-disk cache or embedded fallback.
+func NewSchemas(cfg *config.Config) (Schemas, error) {
+	var schemas Schemas
+	var err error
+	if schemas.RefundRequest, err = LoadSchema(cfg, RefundRequestSubject, data.RefundRequestSchema); err != nil {
+		return Schemas{}, err
+	}
+	if cfg.OutcomeTopic != "" {
+		if schemas.Outcome, err = LoadSchema(cfg, audit.SchemaName, data.RefundRequestOutcomeSchema); err != nil {
+			return Schemas{}, err
+		}
+	}
+	return schemas, nil
+}
+
+// LoadSchema returns a provider of the subject's schema, loaded from the
+// schema registry or, if it is unavailable, from the on-disk cache or the
+// fallback, if embedded fallbacks are enabled.
+func LoadSchema(cfg *config.Config, subject, fallback string) (*registry.Provider, error) {
+	if !cfg.SchemaFallback {
+		fallback = ""
+	}
+	p := registry.NewProvider(cfg.SchemaRegistryURL, subject, cfg.SchemaCacheDir, fallback, time.Duration(cfg.SchemaMaxAge)*time.Second)
+	if err := p.Load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Run refreshes the schemas every interval until ctx is done.
+func (s Schemas) Run(ctx context.Context, interval time.Duration) {
+	for _, p := range s.providers() {
+		go p.Run(ctx, interval)
+	}
+}
+
+// Register adds a check of each schema to the registry.
+func (s Schemas) Register(r *health.Registry) {
+	for _, p := range s.providers() {
+		r.Register("schema."+p.Subject, p.Check)
+	}
+}
+
+func (s Schemas) providers() []*registry.Provider {
+	providers := []*registry.Provider{s.RefundRequest}
+	if s.Outcome != nil {
+		providers = append(providers, s.Outcome)
+	}
+	return providers
+}
@@ -2,25 +2,36 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/companieshouse/chs.go/avro"
-	"github.com/companieshouse/chs.go/avro/schema"
 	"github.com/companieshouse/chs.go/kafka/client"
 	consumer "github.com/companieshouse/chs.go/kafka/consumer/cluster"
 	"github.com/companieshouse/chs.go/kafka/producer"
 	"github.com/companieshouse/chs.go/kafka/resilience"
 	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/audit"
+	"github.com/companieshouse/refund-request-consumer/backoff"
+	"github.com/companieshouse/refund-request-consumer/codec"
 	"github.com/companieshouse/refund-request-consumer/config"
 	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/dlq"
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/hold"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/ladder"
+	"github.com/companieshouse/refund-request-consumer/metrics"
 	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/companieshouse/refund-request-consumer/policy"
+	"github.com/companieshouse/refund-request-consumer/registry"
+	"github.com/companieshouse/refund-request-consumer/validation"
 )
 
 // Service represents service config for refund-request-consumer.
@@ -28,6 +39,10 @@ type Service struct {
 	Consumer            *consumer.GroupConsumer
 	Producer            *producer.Producer
 	RefundRequestSchema string
+	Decoder             *codec.Selector
+	Rules               *validation.Rules
+	Policy              *policy.Engine
+	Holds               *Holds
 	InitialOffset       int64
 	HandleError         func(err error, offset int64, str interface{}) error
 	Topic               string
@@ -35,25 +50,49 @@ type Service struct {
 	IsErrorConsumer     bool
 	BrokerAddr          []string
 	Payments            payment.Payments
+	VerifyPayments      bool
 	PaymentsAPIURL      string
 	Client              *http.Client
 	ApiKey              string
+	DeadLetter          *dlq.Publisher
+	Audit               *audit.Publisher
+	Dedupe              idempotency.Store
+	Role                string
+	Health              *health.ConsumerTracker
+	Offsets             OffsetFetcher
+	Concurrency         int
+	OrderBy             string
+	DrainTimeout        time.Duration
+	Backoff             *backoff.Policy
+	Retries             *ladder.Publisher
+	Invalid             *ladder.Publisher
+	Group               string
+	consumed            consumedOffsets
+	committed           consumedOffsets
+	commits             offsetTracker
+	pause               pauseGate
+	outcomes            outcomeLog
 }
 
-// New creates a new instance of service with a given consumerGroup name,
-// consumerTopic, throttleRate and refund-request-consumer config.
-func New(consumerTopic, consumerGroupName string, InitialOffset int64, cfg *config.Config, retry *resilience.ServiceRetry) (*Service, error) {
+// Consumer roles, identifying what a service consumes.
+const (
+	RoleMain  = "main"
+	RoleRetry = "retry"
+	RoleError = "error"
+)
 
-	schemaName := "refund-request"
-	refundRequestSchema, err := schema.Get(cfg.SchemaRegistryURL, schemaName)
-	if err != nil {
-		e := fmt.Errorf("error receiving %s schema: %w", schemaName, err)
-		log.Error(e)
+// publishRetryInterval is how long to wait before retrying a failed publish
+// to the retry ladder, dead-letter or outcome topic.
+var publishRetryInterval = 5 * time.Second
 
-		return nil, e
-	}
+// New creates a new instance of service with a given consumerGroup name,
+// consumerTopic, throttleRate and refund-request-consumer config. A service
+// given a tier consumes that tier of the retry ladder. The idempotency store
+// and schemas are shared by every service in the process so that a refund
+// submitted by one consumer is not resubmitted by another.
+func New(consumerTopic, consumerGroupName string, InitialOffset int64, cfg *config.Config, retry *resilience.ServiceRetry, dedupe idempotency.Store, tier *ladder.Tier, schemas Schemas) (*Service, error) {
 
-	log.Info(fmt.Sprintf("Successfully received %s schema", schemaName))
+	refundRequestSchema := schemas.RefundRequest.Definition()
 
 	appName := cfg.Namespace()
 
@@ -71,15 +110,23 @@ func New(consumerTopic, consumerGroupName string, InitialOffset int64, cfg *conf
 	}
 
 	log.Info("Start Request Create resilient Kafka service", log.Data{"base_topic": consumerTopic, "app_name": appName, "maxRetries": maxRetries, "producer": p})
+	// The resilience handler keeps the schema loaded at startup.
 	rh := resilience.NewHandler(consumerTopic, "refund-request-consumer", retry, p, &avro.Schema{Definition: refundRequestSchema})
 
 	// Work out what topic we're consuming from, depending on whether were processing resilience or error input
 	topicName := consumerTopic
+	role := RoleMain
 	if retry != nil {
 		topicName = rh.GetRetryTopicName()
+		role = RoleRetry
+	}
+	if tier != nil {
+		topicName = tier.Topic
+		role = TierRole(*tier)
 	}
 	if cfg.IsErrorConsumer {
 		topicName = rh.GetErrorTopicName()
+		role = RoleError
 	}
 
 	consumerConfig := &consumer.Config{
@@ -97,29 +144,191 @@ func New(consumerTopic, consumerGroupName string, InitialOffset int64, cfg *conf
 		Chroot:      cfg.ZookeeperChroot,
 	}
 
+	statuses, err := paymentStatuses(cfg)
+	if err != nil {
+		e := fmt.Errorf("error configuring payments api statuses: %w", err)
+		log.Error(e)
+
+		return nil, e
+	}
+
+	rules, err := ValidationRules(cfg)
+	if err != nil {
+		e := fmt.Errorf("error configuring refund request validation: %w", err)
+		log.Error(e)
+
+		return nil, e
+	}
+
+	tiers, err := ladder.ParseTiers(consumerTopic, cfg.RetryTiers)
+	if err != nil {
+		e := fmt.Errorf("error configuring retry ladder: %w", err)
+		log.Error(e)
+
+		return nil, e
+	}
+
+	if !validOrderBy(cfg.OrderBy) {
+		e := fmt.Errorf("unknown consumer ordering [%s], expected %s or %s", cfg.OrderBy, OrderByPartition, OrderByPaymentID)
+		log.Error(e)
+
+		return nil, e
+	}
+
+	// Avro messages in the wire format are read with the schema they were
+	// written with, and those without a schema ID with the latest schema.
+	avroDecoder, err := registry.NewDecoder(registry.NewClient(cfg.SchemaRegistryURL, nil), data.RefundRequestSchema, schemas.RefundRequest)
+	if err != nil {
+		e := fmt.Errorf("error initialising refund request decoder: %w", err)
+		log.Error(e)
+
+		return nil, e
+	}
+	formats, err := codec.ParseTopicFormats(cfg.TopicFormats)
+	if err != nil {
+		e := fmt.Errorf("error configuring topic formats: %w", err)
+		log.Error(e)
+
+		return nil, e
+	}
+	decoder, err := codec.NewSelector(codec.Avro{Schema: avroDecoder}, formats)
+	if err != nil {
+		e := fmt.Errorf("error initialising refund request decoder: %w", err)
+		log.Error(e)
+
+		return nil, e
+	}
+
+	// Every decision is audited to the outcome topic, when one is configured.
+	var auditor *audit.Publisher
+	if schemas.Outcome != nil {
+		auditor = audit.New(cfg.OutcomeTopic, p, schemas.Outcome)
+	}
+
 	c := consumer.NewConsumerGroup(consumerConfig)
 	if err = c.JoinGroup(groupConfig); err != nil {
 		log.Error(fmt.Errorf("error joining '"+consumerGroupName+"' consumer group", err))
 		return nil, err
 	}
 
+	tracker := health.NewConsumerTracker(time.Duration(cfg.StallTimeout) * time.Second)
+	tracker.Joined(true)
+
+	// Consumer lag is only reported if the brokers can be queried for offsets;
+	// failing to do so is not fatal.
+	var offsets OffsetFetcher
+	kafkaClient, err := sarama.NewClient(cfg.BrokerAddr, sarama.NewConfig())
+	if err != nil {
+		log.Error(fmt.Errorf("error creating kafka client, consumer lag will not be reported: %w", err))
+	} else {
+		offsets = kafkaClient
+	}
+
+	// Retried messages are held until their backoff delay has passed, which
+	// starts at the throttle rate. A tier's delay is a minimum, so its jitter
+	// is only ever added to it.
+	var retryBackoff *backoff.Policy
+	if retry != nil {
+		retryBackoff = backoff.New(retry.ThrottleRate*time.Second, time.Duration(cfg.RetryBackoffMax)*time.Second, cfg.RetryBackoffJitter)
+	}
+	if tier != nil {
+		retryBackoff = backoff.Fixed(tier.Delay, cfg.RetryBackoffJitter)
+	}
+
+	// With a retry ladder configured, refund requests which fail with a
+	// retryable error climb the ladder rather than being handled by the
+	// resilience handler. The error consumer is the end of the ladder.
+	var retries *ladder.Publisher
+	if len(tiers) > 0 && !cfg.IsErrorConsumer {
+		retries = ladder.NewPublisher(ladder.Ladder{Tiers: tiers, ErrorTopic: rh.GetErrorTopicName()}, p, schemas.RefundRequest)
+	}
+
+	// Invalid refund requests go to the error topic, except from the error
+	// consumer, which dead-letters those still invalid.
+	var invalid *ladder.Publisher
+	if !cfg.IsErrorConsumer {
+		invalid = ladder.NewPublisher(ladder.Ladder{ErrorTopic: rh.GetErrorTopicName()}, p, schemas.RefundRequest)
+	}
+
 	return &Service{
 		Consumer:            c,
+		Producer:            p,
 		RefundRequestSchema: refundRequestSchema,
+		Decoder:             decoder,
+		Rules:               rules,
 		HandleError:         rh.HandleError,
 		Topic:               topicName,
 		Retry:               retry,
 		IsErrorConsumer:     cfg.IsErrorConsumer,
 		BrokerAddr:          cfg.BrokerAddr,
-		Payments:            payment.New(),
+		Payments:            payment.New(statuses),
+		VerifyPayments:      cfg.VerifyPayment,
 		PaymentsAPIURL:      cfg.PaymentsAPIURL,
-		Client:              &http.Client{},
+		Client:              payment.NewHTTPClient(time.Duration(cfg.PaymentsConnectTimeout)*time.Second, time.Duration(cfg.PaymentsReadTimeout)*time.Second, time.Duration(cfg.PaymentsTimeout)*time.Second),
 		ApiKey:              cfg.ChsAPIKey,
+		DeadLetter:          dlq.New(cfg.DeadLetterTopic, p),
+		Audit:               auditor,
+		Dedupe:              dedupe,
+		Role:                role,
+		Health:              tracker,
+		Offsets:             offsets,
+		Concurrency:         cfg.Concurrency,
+		OrderBy:             cfg.OrderBy,
+		DrainTimeout:        time.Duration(cfg.DrainTimeout) * time.Second,
+		Backoff:             retryBackoff,
+		Retries:             retries,
+		Invalid:             invalid,
+		Group:               consumerGroupName,
+		outcomes:            outcomeLog{size: cfg.RecentOutcomes},
 	}, nil
 }
 
+// paymentStatuses builds the payments api status classifier from config,
+// using the default statuses for any outcome that is not configured.
+func paymentStatuses(cfg *config.Config) (*payment.StatusClassifier, error) {
+	parse := func(values []string, defaults []int) ([]int, error) {
+		statuses, err := payment.ParseStatuses(values)
+		if err != nil || len(statuses) > 0 {
+			return statuses, err
+		}
+		return defaults, nil
+	}
+
+	success, err := parse(cfg.SuccessStatuses, payment.DefaultSuccessStatuses)
+	if err != nil {
+		return nil, err
+	}
+	duplicate, err := parse(cfg.DuplicateStatuses, payment.DefaultDuplicateStatuses)
+	if err != nil {
+		return nil, err
+	}
+	retryable, err := parse(cfg.RetryableStatuses, payment.DefaultRetryableStatuses)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment.NewStatusClassifier(success, duplicate, retryable)
+}
+
+// ValidationRules returns the configured limits refund requests must be
+// within.
+func ValidationRules(cfg *config.Config) (*validation.Rules, error) {
+	return validation.New(cfg.PaymentIDPattern, cfg.MaxReferenceLength, int64(cfg.MaxRefundAmount), int32(cfg.MaxAttempt))
+}
+
+// rules returns the service's validation rules, or the defaults if it has
+// none.
+func (svc *Service) rules() *validation.Rules {
+	if svc.Rules != nil {
+		return svc.Rules
+	}
+	return validation.Default()
+}
+
 // Start begins the service.
-// Messages are consumed from the refund-request topic.
+// Messages are consumed from the refund-request topic. Refund requests wait
+// while the payments api circuit is open, so the workers, and in turn the
+// consume loop, pause rather than failing messages.
 func (svc *Service) Start(wg *sync.WaitGroup, c chan os.Signal) {
 	log.Info("service starting, consuming from " + svc.Topic + " topic")
 
@@ -134,7 +343,38 @@ func (svc *Service) Start(wg *sync.WaitGroup, c chan os.Signal) {
 		log.Info(fmt.Sprintf("error queue consumer will stop when backlog offset reached: %d", stopAtOffset))
 	}
 
+	// A shutdown signal stops the consume loop fetching messages. Work already
+	// in flight, such as a refund request to the payments api, is given until
+	// the drain deadline to finish before it is cancelled.
+	stop, stopFetching := context.WithCancel(context.Background())
+	defer stopFetching()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c:
+		case <-ctx.Done():
+			return
+		}
+		log.Info("Received close notification, draining in-flight work", log.Data{"drain_timeout": svc.DrainTimeout.String()})
+		stopFetching()
+
+		deadline := time.NewTimer(svc.DrainTimeout)
+		defer deadline.Stop()
+		select {
+		case <-deadline.C:
+			log.Info("Drain deadline reached, cancelling in-flight work")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	go svc.monitorLag(stop)
+
+	pool := svc.startWorkers(stop, ctx)
+
 	var message *sarama.ConsumerMessage
+	notifications := svc.notifications()
 
 	// We want to stop the processing of the service if consuming from an
 	// error queue if all messages that were initially in the queue have
@@ -143,74 +383,40 @@ func (svc *Service) Start(wg *sync.WaitGroup, c chan os.Signal) {
 	running := true
 	for running && (stopAtOffset == -1 || message == nil || message.Offset < stopAtOffset) {
 
-		if message != nil {
-			// Commit the message we've just been processing before starting the next
-			log.Trace(fmt.Sprintf("Committing message, offset: %d", message.Offset))
-			svc.Consumer.MarkOffset(message, "")
-			if err := svc.Consumer.CommitOffsets(); err != nil {
-				log.Error(err, log.Data{"offset": message.Offset})
-			}
-		}
-
-		if svc.Retry != nil && svc.Retry.ThrottleRate > 0 {
-			time.Sleep(svc.Retry.ThrottleRate * time.Second)
-		}
-
 		select {
-		case <-c:
+		case <-stop.Done():
 			running = false
 
 		case message = <-svc.Consumer.Messages():
 			// Falls into this block when a message becomes available from consumer
 			if message != nil {
-				if message.Offset >= svc.InitialOffset {
-					var rr data.RefundRequest
-					refundRequestSchema := &avro.Schema{
-						Definition: svc.RefundRequestSchema,
-					}
-
-					err = refundRequestSchema.Unmarshal(message.Value, &rr)
-					if err != nil {
-						log.Error(err, log.Data{"message_offset": message.Offset})
-						handleErr := svc.HandleError(err, message.Offset, refundRequestSchema)
-						if handleErr != nil {
-							log.Error(fmt.Errorf("error handling error: %w", handleErr))
-						}
-
-						continue
-					}
-
-					log.Info(fmt.Sprintf("refund request received for Payment ID: [%s]", rr.PaymentID))
-
-					refundRequestURL := fmt.Sprintf("%s/payments/%s/refunds", svc.PaymentsAPIURL, rr.PaymentID)
-
-					amount, err := convertDecimalAmountToPence(rr.RefundAmount)
-					if err != nil {
-						log.Error(fmt.Errorf("error converting amount: %w", err))
-						continue
-					}
-					refundPostRequest := data.RefundPostRequest{
-						Amount:          amount,
-						RefundReference: rr.RefundReference,
-					}
-					err = svc.Payments.RefundRequestPost(refundRequestURL, refundPostRequest, svc.Client, svc.ApiKey)
-					if err != nil {
-						log.Error(err, log.Data{"message_offset": message.Offset})
-						handleErr := svc.HandleError(err, message.Offset, &rr)
-						if handleErr != nil {
-							log.Error(fmt.Errorf("error handling error: %w", handleErr))
-						}
-						continue
-					}
-					log.Info(fmt.Sprintf("refund request completed for Payment ID: [%s]", rr.PaymentID))
-				}
+				svc.dispatch(stop, pool, message)
 			}
 
 		case err = <-svc.Consumer.Errors():
 			log.Error(err, log.Data{"topic": svc.Topic})
+			if err != nil {
+				svc.Health.ConsumerError(err)
+			}
+
+		case n, ok := <-notifications:
+			if ok {
+				svc.rebalanced(n)
+			} else {
+				notifications = nil
+				svc.Health.Joined(false)
+			}
 		}
 	}
 
+	// Wait for the workers to finish with the messages already dispatched or,
+	// when shutting down, with those in flight.
+	drainStarted := time.Now()
+	pool.stop()
+	if stop.Err() != nil {
+		svc.logDrain(time.Since(drainStarted), ctx.Err() == nil)
+	}
+
 	// We only get here if we're an error consumer and we've reached out stop offset
 	// We will not consume any further messages, so disconnect consumer.
 	svc.Shutdown()
@@ -220,10 +426,7 @@ func (svc *Service) Start(wg *sync.WaitGroup, c chan os.Signal) {
 	// restarted and will go on to consume further messages in the error
 	// topic and chasing its own tail, if something is really broken.
 	if running {
-		select {
-		case <-c: // Just wait for a shutdown event
-			log.Info("Received close notification")
-		}
+		<-stop.Done() // Just wait for a shutdown event
 	}
 
 	wg.Done()
@@ -232,14 +435,360 @@ func (svc *Service) Start(wg *sync.WaitGroup, c chan os.Signal) {
 
 }
 
-func convertDecimalAmountToPence(amount string) (int, error) {
-	penceAmount := strings.Replace(amount, ".", "", 1)
-	return strconv.Atoi(penceAmount)
+// process handles a decoded message, submitting its refund and redirecting it
+// to the retry or dead-letter topic if that fails. It returns false if the
+// message's offset must not be committed because processing was cut short by
+// shutdown. The outcome is recorded either way.
+func (svc *Service) process(ctx context.Context, j job) (commit bool) {
+	message := j.message
+	o := Outcome{
+		Role:            svc.Role,
+		Topic:           message.Topic,
+		Partition:       message.Partition,
+		Offset:          message.Offset,
+		PaymentID:       j.rr.PaymentID,
+		RefundReference: j.rr.RefundReference,
+		RefundAmount:    j.rr.RefundAmount,
+		Attempt:         j.rr.Attempt,
+	}
+	defer func() {
+		if !commit {
+			o.Outcome = OutcomeCancelled
+		}
+		o.ProcessedAt = time.Now()
+		svc.outcomes.add(o)
+
+		// A message is only committed once its outcome is audited, so that
+		// one whose event is lost is processed, and audited, again.
+		if svc.Audit != nil && !svc.audit(ctx, o) {
+			commit = false
+		}
+	}()
+
+	if j.err != nil {
+		log.Error(j.err, log.Data{"message_offset": message.Offset})
+		o.Outcome, o.Destination, o.FailureClass, o.Error = OutcomeDeadLettered, svc.DeadLetter.Topic, string(dlq.FailureDecode), j.err.Error()
+		return svc.deadLetter(ctx, message, dlq.FailureDecode, j.err)
+	}
+
+	rr := j.rr
+	log.Info(fmt.Sprintf("refund request received for Payment ID: [%s]", rr.PaymentID))
+
+	amount, err := svc.rules().Validate(rr)
+	if err != nil {
+		// A request which is only invalid because of its amount keeps the
+		// failure class it had before the other fields were validated.
+		class := dlq.FailureInvalid
+		var invalid *validation.Error
+		if errors.As(err, &invalid) && invalid.Only(validation.FieldRefundAmount) {
+			class = dlq.FailureInvalidAmount
+		}
+		log.Error(err, log.Data{"message_offset": message.Offset, "payment_id": rr.PaymentID})
+		o.FailureClass, o.Error = string(class), err.Error()
+		if svc.Invalid != nil {
+			result, ok := svc.redirect(ctx, message, rr, func() (ladder.Result, error) {
+				return svc.Invalid.PublishInvalid(rr, reasons(err))
+			})
+			o.Outcome, o.Destination = OutcomeErrorTopic, result.Topic
+			return ok
+		}
+		o.Outcome, o.Destination = OutcomeDeadLettered, svc.DeadLetter.Topic
+		return svc.deadLetter(ctx, message, class, err)
+	}
+	o.AmountPence = int64(amount)
+
+	// Refunds breaching the refund policy are held until an operator approves
+	// them.
+	decision := svc.admit(rr, amount)
+	if decision.Action == policy.Hold {
+		o.Outcome, o.Destination, o.Error = OutcomeHeld, svc.Holds.Publisher.Topic, decision.Reason
+		return svc.hold(ctx, message, hold.New(rr, int64(amount), decision.Reason, message, time.Now()))
+	}
+
+	submitted, err := svc.submitRefund(ctx, &rr, amount)
+	o.HTTPStatus, o.LatencyMillis = submitted.status, submitted.latency.Milliseconds()
+	if err != nil && svc.Policy != nil {
+		svc.Policy.Cancel(decision)
+	}
+	if errors.Is(err, context.Canceled) {
+		// Shutting down mid-request: leave the offset uncommitted so the
+		// refund is resubmitted, with the same idempotency key, after restart.
+		log.Info("refund request cancelled by shutdown", log.Data{"message_offset": message.Offset, "payment_id": rr.PaymentID})
+		return false
+	}
+	if err != nil {
+		class, ok := payment.ClassOf(err)
+		if !ok {
+			class = "unclassified"
+		}
+		log.Error(err, log.Data{"message_offset": message.Offset, "error_class": class})
+		metrics.RefundsFailed.WithLabelValues(svc.Role, string(class)).Inc()
+		o.FailureClass, o.Error = string(class), err.Error()
+
+		// Retrying a permanent failure can never succeed, so it goes straight
+		// to the dead-letter topic.
+		if payment.IsPermanent(err) {
+			failure := dlq.FailureRejected
+			if class == payment.ClassNotRefundable {
+				failure = dlq.FailureNotRefundable
+			}
+			o.Outcome, o.Destination = OutcomeDeadLettered, svc.DeadLetter.Topic
+			return svc.deadLetter(ctx, message, failure, err)
+		}
+
+		if svc.Retries != nil {
+			result, ok := svc.retry(ctx, message, rr)
+			o.Outcome, o.Destination = OutcomeRetried, result.Topic
+			if !result.Retry {
+				o.Outcome = OutcomeErrorTopic
+			}
+			return ok
+		}
+
+		// The attempt is incremented as on the retry ladder, so that the
+		// backoff grows and the attempt limit is reached.
+		if rr.Attempt < 1 {
+			rr.Attempt = 1
+		}
+		rr.Attempt++
+		metrics.MessagesRedirected.WithLabelValues(svc.Role, metrics.DestinationRetry).Inc()
+		o.Outcome = OutcomeRetried
+		handleErr := svc.HandleError(err, message.Offset, &rr)
+		if handleErr != nil {
+			log.Error(fmt.Errorf("error handling error: %w", handleErr))
+		}
+		return true
+	}
+
+	o.Outcome = OutcomeSucceeded
+	if !submitted.posted {
+		o.Outcome = OutcomeAlreadySucceeded
+	}
+	return true
 }
 
+// submission describes a refund posted to the payments api. The status is
+// zero if no response was received.
+type submission struct {
+	posted  bool
+	status  int
+	latency time.Duration
+}
+
+// submitRefund posts the refund to the payments API, unless the idempotency
+// store shows the same refund has already succeeded, and records the outcome.
+// A refund left in the submitted state by an earlier attempt is resubmitted as
+// its outcome is unknown.
+func (svc *Service) submitRefund(ctx context.Context, rr *data.RefundRequest, amount int) (submission, error) {
+	key := idempotency.Key(rr.PaymentID, rr.RefundReference)
+	logData := log.Data{"payment_id": rr.PaymentID, "refund_reference": rr.RefundReference}
+
+	record, found, err := svc.Dedupe.Get(key)
+	if err != nil {
+		return submission{}, fmt.Errorf("error reading idempotency store: %w", err)
+	}
+	if found && record.State == idempotency.Succeeded {
+		log.Info(fmt.Sprintf("refund request already completed for Payment ID: [%s], skipping", rr.PaymentID), logData)
+		return submission{}, nil
+	}
+	resubmit := found && record.State == idempotency.Submitted
+	if resubmit {
+		log.Info(fmt.Sprintf("previous refund request for Payment ID: [%s] has an unknown outcome, resubmitting", rr.PaymentID), logData)
+	}
+
+	statusCtx, status := payment.RecordStatus(ctx)
+	if svc.VerifyPayments {
+		// A resubmitted refund may already be among the payment's refunds, so
+		// only its status is checked; the payments api recognises the refund
+		// itself by its idempotency key.
+		if err := svc.verifyPayment(statusCtx, rr.PaymentID, amount, !resubmit); err != nil {
+			return submission{status: status()}, err
+		}
+	}
+
+	if err := svc.recordRefund(key, idempotency.Submitted); err != nil {
+		return submission{}, err
+	}
+
+	refundRequestURL := fmt.Sprintf("%s/payments/%s/refunds", svc.PaymentsAPIURL, rr.PaymentID)
+	refundPostRequest := data.RefundPostRequest{
+		Amount:          amount,
+		RefundReference: rr.RefundReference,
+	}
+
+	idempotencyKey := payment.IdempotencyKey(rr.PaymentID, refundPostRequest)
+
+	start := time.Now()
+	err = svc.Payments.RefundRequestPost(statusCtx, refundRequestURL, refundPostRequest, idempotencyKey, svc.Client, svc.ApiKey)
+	sub := submission{posted: true, status: status(), latency: time.Since(start)}
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	metrics.PaymentsAPILatency.WithLabelValues(outcome).Observe(sub.latency.Seconds())
+	if errors.Is(err, context.Canceled) {
+		// The request may have reached the payments api, so the refund is left
+		// as submitted.
+		return sub, err
+	}
+	if err != nil {
+		if recordErr := svc.recordRefund(key, idempotency.Failed); recordErr != nil {
+			log.Error(recordErr, logData)
+		}
+		return sub, err
+	}
+
+	// The refund has been made, so failing to record it must not cause a retry.
+	if err := svc.recordRefund(key, idempotency.Succeeded); err != nil {
+		log.Error(err, logData)
+	}
+
+	metrics.RefundsSubmitted.WithLabelValues(svc.Role).Inc()
+	log.Info(fmt.Sprintf("refund request completed for Payment ID: [%s]", rr.PaymentID))
+	return sub, nil
+}
+
+// verifyPayment fetches the payment from the payments api and checks a refund
+// of amount pence can be made from it.
+func (svc *Service) verifyPayment(ctx context.Context, paymentID string, amount int, checkBalance bool) error {
+	paymentURL := fmt.Sprintf("%s/payments/%s", svc.PaymentsAPIURL, paymentID)
+	resource, err := svc.Payments.GetPayment(ctx, paymentURL, svc.Client, svc.ApiKey)
+	if err != nil {
+		return fmt.Errorf("error getting payment [%s]: %w", paymentID, err)
+	}
+	return payment.CheckRefundable(paymentID, resource, int64(amount), checkBalance)
+}
+
+func (svc *Service) recordRefund(key string, state idempotency.State) error {
+	err := svc.Dedupe.Put(idempotency.Record{Key: key, State: state, UpdatedAt: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("error recording refund as %s: %w", state, err)
+	}
+	return nil
+}
+
+// retry republishes a refund request which failed with a retryable error to
+// the next topic on the retry ladder, retrying the publish until it succeeds.
+// It returns false if the context is cancelled first, in which case the
+// message's offset must not be committed.
+func (svc *Service) retry(ctx context.Context, message *sarama.ConsumerMessage, rr data.RefundRequest) (ladder.Result, bool) {
+	return svc.redirect(ctx, message, rr, func() (ladder.Result, error) {
+		return svc.Retries.Publish(rr)
+	})
+}
+
+// redirect republishes a refund request with publish, retrying until it
+// succeeds. It returns false if the context is cancelled first, in which case
+// the message's offset must not be committed.
+func (svc *Service) redirect(ctx context.Context, message *sarama.ConsumerMessage, rr data.RefundRequest, publish func() (ladder.Result, error)) (ladder.Result, bool) {
+	logData := log.Data{"message_offset": message.Offset, "payment_id": rr.PaymentID, "attempt": rr.Attempt}
+	for {
+		result, err := publish()
+		svc.Health.ProducerResult(err)
+		if err == nil {
+			destination := metrics.DestinationRetry
+			if !result.Retry {
+				destination = metrics.DestinationError
+			}
+			metrics.MessagesRedirected.WithLabelValues(svc.Role, destination).Inc()
+			log.Info(fmt.Sprintf("refund request sent to topic [%s] for attempt %d", result.Topic, result.Attempt), logData)
+			return result, true
+		}
+
+		log.Error(err, logData)
+
+		select {
+		case <-ctx.Done():
+			log.Info("Shutting down, refund request not republished", logData)
+			return ladder.Result{}, false
+		case <-time.After(publishRetryInterval):
+		}
+	}
+}
+
+// reasons returns the reason each field of a refund request is invalid.
+func reasons(err error) map[string]string {
+	var invalid *validation.Error
+	if errors.As(err, &invalid) {
+		return invalid.Reasons()
+	}
+	return map[string]string{"request": err.Error()}
+}
+
+// deadLetter publishes a message that can never be processed to the
+// dead-letter topic, retrying until the publish succeeds. It returns false if
+// the context is cancelled first, in which case the message has not been
+// published and its offset must not be committed.
+func (svc *Service) deadLetter(ctx context.Context, message *sarama.ConsumerMessage, class dlq.FailureClass, cause error) bool {
+	format := codec.FormatAvro
+	if svc.Decoder != nil {
+		// The format is left unknown if the message's content type is not
+		// supported.
+		format, _ = svc.Decoder.Format(message)
+	}
+	for {
+		err := svc.DeadLetter.Publish(message, format, class, cause)
+		svc.Health.ProducerResult(err)
+		if err == nil {
+			metrics.MessagesRedirected.WithLabelValues(svc.Role, metrics.DestinationDeadLetter).Inc()
+			log.Info(fmt.Sprintf("message sent to dead-letter topic [%s]", svc.DeadLetter.Topic), log.Data{"message_offset": message.Offset, "failure_class": class})
+			return true
+		}
+
+		log.Error(err, log.Data{"message_offset": message.Offset, "failure_class": class})
+
+		select {
+		case <-ctx.Done():
+			log.Info("Shutting down, message not sent to dead-letter topic", log.Data{"message_offset": message.Offset})
+			return false
+		case <-time.After(publishRetryInterval):
+		}
+	}
+}
+
+// audit publishes the event recording a message's outcome, retrying until
+// the publish succeeds. It returns false if the context is cancelled first, in
+// which case the message's offset must not be committed.
+func (svc *Service) audit(ctx context.Context, o Outcome) bool {
+	logData := log.Data{"message_offset": o.Offset, "payment_id": o.PaymentID, "outcome": o.Outcome}
+	for {
+		err := svc.Audit.Publish(o.event())
+		svc.Health.ProducerResult(err)
+		if err == nil {
+			return true
+		}
+
+		log.Error(err, logData)
+
+		select {
+		case <-ctx.Done():
+			log.Info("Shutting down, refund request outcome not sent to outcome topic", logData)
+			return false
+		case <-time.After(publishRetryInterval):
+		}
+	}
+}
+
+// logDrain reports the outcome of draining in-flight work at shutdown.
+func (svc *Service) logDrain(took time.Duration, completed bool) {
+	logData := log.Data{"took": took.String(), "uncommitted_messages": svc.commits.outstanding()}
+	if completed {
+		log.Info("In-flight work drained", logData)
+		return
+	}
+	log.Info("In-flight work cancelled at drain deadline, uncommitted messages will be consumed again after restart", logData)
+}
+
+// Shutdown commits the offsets marked so far, then closes the producer,
+// consumer and kafka client in turn.
 func (svc *Service) Shutdown() {
 	log.Info("Shutting down service")
 
+	log.Info("Committing final offsets")
+	if err := svc.Consumer.CommitOffsets(); err != nil {
+		log.Error(fmt.Errorf("error committing final offsets: %w", err))
+	}
+
 	log.Info("Closing producer")
 	err := svc.Producer.Close()
 	if err != nil {
@@ -248,9 +797,16 @@ func (svc *Service) Shutdown() {
 	log.Info("Producer successfully closed")
 
 	log.Info("Closing consumer")
+	svc.Health.Joined(false)
 	err = svc.Consumer.Close()
 	if err != nil {
 		log.Error(fmt.Errorf("error closing consumer: %w", err))
 	}
 	log.Info("Consumer successfully closed")
+
+	if svc.Offsets != nil {
+		if err := svc.Offsets.Close(); err != nil {
+			log.Error(fmt.Errorf("error closing kafka client: %w", err))
+		}
+	}
 }
@@ -1,17 +1,31 @@
 package service
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/companieshouse/chs.go/avro"
 	consumer "github.com/companieshouse/chs.go/kafka/consumer/cluster"
 	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/refund-request-consumer/audit"
+	"github.com/companieshouse/refund-request-consumer/backoff"
+	"github.com/companieshouse/refund-request-consumer/codec"
 	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/dlq"
+	"github.com/companieshouse/refund-request-consumer/health"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/ladder"
+	"github.com/companieshouse/refund-request-consumer/money"
 	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/companieshouse/refund-request-consumer/registry"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -20,6 +34,8 @@ const paymentsAPIUrl = "paymentsAPIUrl"
 const apiKey = "apiKey"
 const paymentResourceID = "paymentResourceID"
 
+var refundPostRequest = data.RefundPostRequest{Amount: 10000, RefundReference: "ref"}
+
 func createMockService(mockPayment *payment.MockPayments) *Service {
 	return &Service{
 		Producer:            createMockProducer(),
@@ -29,6 +45,8 @@ func createMockService(mockPayment *payment.MockPayments) *Service {
 		ApiKey:              apiKey,
 		Client:              &http.Client{},
 		Topic:               "test",
+		Dedupe:              idempotency.NewMemoryStore(0),
+		Health:              health.NewConsumerTracker(time.Minute),
 	}
 }
 
@@ -37,9 +55,15 @@ func createMockConsumerWithRefundMessage(paymentId string) *consumer.GroupConsum
 }
 
 func createMockConsumerWithMessage(attempt int32, paymentId string, refundAmount string, refundReference string) *consumer.GroupConsumer {
+	return createMockConsumer(&sarama.ConsumerMessage{
+		Value: prepareTestKafkaMessage(attempt, paymentId, refundAmount, refundReference),
+	})
+}
+
+func createMockConsumer(messages ...*sarama.ConsumerMessage) *consumer.GroupConsumer {
 	return &consumer.GroupConsumer{
-		GConsumer: MockConsumer{Attempt: attempt, PaymentID: paymentId, RefundAmount: refundAmount, RefundReference: refundReference},
-		Group:     MockGroup{},
+		GConsumer: newMockConsumer(messages...),
+		Group:     &MockGroup{},
 	}
 }
 
@@ -61,6 +85,8 @@ func getDefaultSchema() string {
 	return "{\"type\":\"record\",\"name\":\"refund_request\",\"namespace\":\"payments\",\"fields\":[{\"name\":\"attempt\",\"type\":\"int\"},{\"name\":\"payment_id\",\"type\":\"string\"},{\"name\":\"refund_amount\",\"type\":\"string\"},{\"name\":\"refund_reference\",\"type\":\"string\"}]}"
 }
 
+const outcomeSchema = `{"type":"record","name":"refund_request_outcome","namespace":"payments","fields":[{"name":"payment_id","type":"string"},{"name":"refund_reference","type":"string"},{"name":"amount_pence","type":"long"},{"name":"attempt","type":"int"},{"name":"outcome","type":"string"},{"name":"failure_class","type":"string"},{"name":"http_status","type":"int"},{"name":"latency_ms","type":"long"},{"name":"consumer_role","type":"string"},{"name":"topic","type":"string"},{"name":"partition","type":"int"},{"name":"offset","type":"long"},{"name":"processed_at","type":"long"}]}`
+
 var MockSchema = &avro.Schema{
 	Definition: getDefaultSchema(),
 }
@@ -68,9 +94,6 @@ var MockSchema = &avro.Schema{
 // endConsumerProcess facilitates service termination
 func endConsumerProcess(svc *Service, c chan os.Signal) {
 
-	// Increment the offset to escape an endless loop in the service
-	svc.InitialOffset = int64(100)
-
 	// Send a kill command to the input channel to terminate program execution
 	go func() {
 		c <- os.Kill
@@ -78,20 +101,28 @@ func endConsumerProcess(svc *Service, c chan os.Signal) {
 	}()
 }
 
+// MockConsumer delivers a fixed set of messages, then waits for more as a
+// real consumer would.
 type MockConsumer struct {
-	Attempt         int32
-	PaymentID       string
-	RefundAmount    string
-	RefundReference string
-}
-
-func (m MockConsumer) prepareTestKafkaMessage() ([]byte, error) {
-	return MockSchema.Marshal(data.RefundRequest{
-		Attempt:         m.Attempt,
-		PaymentID:       m.PaymentID,
-		RefundAmount:    m.RefundAmount,
-		RefundReference: m.RefundReference,
+	messages chan *sarama.ConsumerMessage
+}
+
+func newMockConsumer(messages ...*sarama.ConsumerMessage) MockConsumer {
+	m := MockConsumer{messages: make(chan *sarama.ConsumerMessage, len(messages))}
+	for _, message := range messages {
+		m.messages <- message
+	}
+	return m
+}
+
+func prepareTestKafkaMessage(attempt int32, paymentId string, refundAmount string, refundReference string) []byte {
+	bytes, _ := MockSchema.Marshal(data.RefundRequest{
+		Attempt:         attempt,
+		PaymentID:       paymentId,
+		RefundAmount:    refundAmount,
+		RefundReference: refundReference,
 	})
+	return bytes
 }
 
 func (m MockConsumer) Close() error {
@@ -99,31 +130,55 @@ func (m MockConsumer) Close() error {
 }
 
 func (m MockConsumer) Messages() <-chan *sarama.ConsumerMessage {
-	out := make(chan *sarama.ConsumerMessage)
-
-	bytes, _ := m.prepareTestKafkaMessage()
-	go func() {
-		out <- &sarama.ConsumerMessage{
-			Value: bytes,
-		}
-		close(out)
-	}()
-
-	return out
+	return m.messages
 }
 
 func (m MockConsumer) Errors() <-chan error {
 	return nil
 }
 
-type MockGroup struct{}
+type MockGroup struct {
+	marked []int64
+	onMark func()
+}
 
-func (m MockGroup) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {}
+func (m *MockGroup) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	m.marked = append(m.marked, msg.Offset)
+	if m.onMark != nil {
+		onMark := m.onMark
+		m.onMark = nil
+		onMark()
+	}
+}
 
-func (m MockGroup) CommitOffsets() error {
+func (m *MockGroup) CommitOffsets() error {
 	return nil
 }
 
+type fakeSchemas map[int32]string
+
+func (f fakeSchemas) SchemaByID(id int32) (string, error) {
+	definition, ok := f[id]
+	if !ok {
+		return "", registry.ErrSchemaNotFound
+	}
+	return definition, nil
+}
+
+type mockSender struct {
+	sent   []*sarama.ProducerMessage
+	err    error
+	onSend func()
+}
+
+func (m *mockSender) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	m.sent = append(m.sent, msg)
+	if m.onSend != nil {
+		m.onSend()
+	}
+	return 0, 0, m.err
+}
+
 func TestUnitStart(t *testing.T) {
 
 	ctrl := gomock.NewController(t)
@@ -142,7 +197,7 @@ func TestUnitStart(t *testing.T) {
 			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
 
 			Convey("Then a refund request is sent to the Payments API", func() {
-				mockPayment.EXPECT().RefundRequestPost(paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds", gomock.Any(), svc.Client, apiKey).Do(func(postURL string, postBody data.RefundPostRequest, HTTPClient *http.Client, apiKey string) {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds", refundPostRequest, payment.IdempotencyKey(paymentResourceID, refundPostRequest), svc.Client, apiKey).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
 					endConsumerProcess(svc, c)
 				}).Times(1)
 
@@ -155,20 +210,634 @@ func TestUnitStart(t *testing.T) {
 			svc.IsErrorConsumer = true
 
 			Convey("Then a refund request is sent to the Payments API", func() {
-				mockPayment.EXPECT().RefundRequestPost(paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds", gomock.Any(), svc.Client, apiKey).Do(func(postURL string, postBody data.RefundPostRequest, HTTPClient *http.Client, apiKey string) {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds", gomock.Any(), gomock.Any(), svc.Client, apiKey).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
 					endConsumerProcess(svc, c)
 				}).Times(1)
 
 				svc.Start(wg, c)
 			})
 		})
+
+		Convey("Given a json message with a content-type header", func() {
+			decoder, err := codec.NewSelector(codec.Avro{Schema: MockSchema}, nil)
+			So(err, ShouldBeNil)
+			svc.Decoder = decoder
+
+			value := `{"attempt":1,"payment_id":"` + paymentResourceID + `","refund_amount":"100.00","refund_reference":"ref"}`
+			svc.Consumer = createMockConsumer(&sarama.ConsumerMessage{
+				Value:   []byte(value),
+				Headers: []*sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte("application/json; charset=utf-8")}},
+			})
+
+			Convey("Then it is decoded as json and a refund request is sent to the Payments API", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds", refundPostRequest, gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(nil).Times(1)
+
+				svc.Start(wg, c)
+			})
+		})
+
+		Convey("Given a message in the wire format written with a newer schema", func() {
+			writer := `{"type":"record","name":"refund_request","fields":[{"name":"attempt","type":"int"},{"name":"payment_id","type":"string"},{"name":"refund_amount","type":"string"},{"name":"refund_reference","type":"string"},{"name":"currency","type":["null","string"],"default":null}]}`
+			decoder, err := registry.NewDecoder(fakeSchemas{7: writer}, data.RefundRequestSchema, MockSchema)
+			So(err, ShouldBeNil)
+			svc.Decoder, err = codec.NewSelector(codec.Avro{Schema: decoder}, nil)
+			So(err, ShouldBeNil)
+
+			value := binary.AppendVarint(nil, 1)
+			for _, s := range []string{paymentResourceID, "100.00", "ref"} {
+				value = append(binary.AppendVarint(value, int64(len(s))), s...)
+			}
+			value = append(binary.AppendVarint(binary.AppendVarint(value, 1), 3), "GBP"...)
+			svc.Consumer = createMockConsumer(&sarama.ConsumerMessage{Value: registry.Frame(7, value)})
+
+			Convey("Then it is decoded with its writer schema and a refund request is sent to the Payments API", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds", refundPostRequest, gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(nil).Times(1)
+
+				svc.Start(wg, c)
+			})
+		})
+
+		Convey("Given a message for a refund that has already succeeded is readily available for the service to consume", func() {
+			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
+			svc.Dedupe.Put(idempotency.Record{Key: idempotency.Key(paymentResourceID, "ref"), State: idempotency.Succeeded})
+
+			Convey("Then no refund request is sent to the Payments API and the message is committed", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				group := &MockGroup{onMark: func() { endConsumerProcess(svc, c) }}
+				svc.Consumer.Group = group
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldNotBeEmpty)
+				So(svc.RecentOutcomes(1)[0].Outcome, ShouldEqual, OutcomeAlreadySucceeded)
+			})
+		})
+
+		Convey("Given a message for a refund is readily available for the service to consume", func() {
+			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
+			key := idempotency.Key(paymentResourceID, "ref")
+
+			Convey("Then a successful refund is recorded in the idempotency store", func() {
+				var submitted idempotency.Record
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					submitted, _, _ = svc.Dedupe.Get(key)
+					endConsumerProcess(svc, c)
+				}).Return(nil).Times(1)
+
+				svc.Start(wg, c)
+
+				So(submitted.State, ShouldEqual, idempotency.Submitted)
+				record, found, _ := svc.Dedupe.Get(key)
+				So(found, ShouldBeTrue)
+				So(record.State, ShouldEqual, idempotency.Succeeded)
+
+				outcomes := svc.RecentOutcomes(-1)
+				So(outcomes, ShouldHaveLength, 1)
+				So(outcomes[0].Outcome, ShouldEqual, OutcomeSucceeded)
+				So(outcomes[0].PaymentID, ShouldEqual, paymentResourceID)
+			})
+
+			Convey("Then a failed refund is recorded in the idempotency store and the error handled", func() {
+				var handledErr error
+				var retried *data.RefundRequest
+				svc.HandleError = func(err error, offset int64, str interface{}) error {
+					handledErr = err
+					retried = str.(*data.RefundRequest)
+					return nil
+				}
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(errors.New("payments api unavailable")).Times(1)
+
+				svc.Start(wg, c)
+
+				So(handledErr, ShouldNotBeNil)
+				So(retried.Attempt, ShouldEqual, 2)
+				record, _, _ := svc.Dedupe.Get(key)
+				So(record.State, ShouldEqual, idempotency.Failed)
+			})
+		})
+
+		Convey("Given a shutdown signal arrives while a refund request is in flight", func() {
+			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
+			group := &MockGroup{}
+			svc.Consumer.Group = group
+			svc.HandleError = func(err error, offset int64, str interface{}) error {
+				t.Errorf("cancelled refund sent for retry: %s", err)
+				return nil
+			}
+
+			Convey("Then the request is cancelled at the drain deadline, the refund left as submitted and the offset not committed", func() {
+				svc.DrainTimeout = 10 * time.Millisecond
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+					endConsumerProcess(svc, c)
+					<-ctx.Done()
+					return ctx.Err()
+				}).Times(1)
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldBeEmpty)
+				record, _, _ := svc.Dedupe.Get(idempotency.Key(paymentResourceID, "ref"))
+				So(record.State, ShouldEqual, idempotency.Submitted)
+			})
+
+			Convey("Then a request finishing before the drain deadline completes and its offset is committed", func() {
+				svc.DrainTimeout = time.Minute
+				var postErr error
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+					c <- os.Kill
+					time.Sleep(20 * time.Millisecond)
+					postErr = ctx.Err()
+					return nil
+				}).Times(1)
+
+				svc.Start(wg, c)
+
+				So(postErr, ShouldBeNil)
+				So(group.marked, ShouldResemble, []int64{0})
+				record, _, _ := svc.Dedupe.Get(idempotency.Key(paymentResourceID, "ref"))
+				So(record.State, ShouldEqual, idempotency.Succeeded)
+			})
+		})
+
+		Convey("Given a shutdown signal arrives while further messages are waiting to be processed", func() {
+			svc.Consumer = createMockConsumer(
+				&sarama.ConsumerMessage{Offset: 0, Value: prepareTestKafkaMessage(1, "payment-1", "100.00", "ref")},
+				&sarama.ConsumerMessage{Offset: 1, Value: prepareTestKafkaMessage(1, "payment-2", "100.00", "ref")},
+			)
+			group := &MockGroup{}
+			svc.Consumer.Group = group
+			svc.DrainTimeout = time.Minute
+
+			Convey("Then only the message in flight is processed and committed", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/payment-1/refunds", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+					c <- os.Kill
+					time.Sleep(20 * time.Millisecond)
+					return nil
+				}).Times(1)
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldResemble, []int64{0})
+				So(svc.commits.outstanding(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("Given a message for a refund on the first attempt with a retry ladder configured", func() {
+			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
+			sender := &mockSender{}
+			tiers, _ := ladder.ParseTiers("refund-request", []string{"1m,1h"})
+			svc.Retries = ladder.NewPublisher(ladder.Ladder{Tiers: tiers, ErrorTopic: "refund-request-error"}, sender, MockSchema)
+			svc.HandleError = func(err error, offset int64, str interface{}) error {
+				t.Errorf("retryable failure sent to the resilience handler: %s", err)
+				return nil
+			}
+
+			Convey("Then a retryable failure sends it to the first tier with its attempt incremented", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(&payment.TransportError{Err: errors.New("connection refused")}).Times(1)
+
+				svc.Start(wg, c)
+
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].Topic, ShouldEqual, "refund-request-retry-1m")
+				value, _ := sender.sent[0].Value.Encode()
+				var rr data.RefundRequest
+				So(MockSchema.Unmarshal(value, &rr), ShouldBeNil)
+				So(rr.Attempt, ShouldEqual, 2)
+			})
+
+			Convey("Then the offset is not committed when the retry tier is unavailable at shutdown", func() {
+				group := &MockGroup{}
+				svc.Consumer.Group = group
+				sender.err = errors.New("broker down")
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(&payment.TransportError{Err: errors.New("connection refused")}).Times(1)
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldBeEmpty)
+			})
+		})
+
+		Convey("Given a message for a refund while the payments api circuit is open", func() {
+			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
+			group := &MockGroup{}
+			svc.Consumer.Group = group
+			breaker := payment.NewCircuitBreaker(1, time.Hour, 1)
+			breaker.Record(false, &payment.TransportError{Err: errors.New("connection refused")})
+			svc.Payments = payment.WithCircuitBreaker(mockPayment, breaker)
+			svc.HandleError = func(err error, offset int64, str interface{}) error {
+				t.Errorf("message failed while the circuit is open: %s", err)
+				return nil
+			}
+
+			Convey("Then the refund waits for the circuit rather than failing, and is not committed at shutdown", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					endConsumerProcess(svc, c)
+				}()
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldBeEmpty)
+			})
+		})
+
+		Convey("Given a message for a refund the Payments API permanently rejects", func() {
+			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
+			sender := &mockSender{}
+			svc.DeadLetter = dlq.New("refund-request-dlq", sender)
+			svc.HandleError = func(err error, offset int64, str interface{}) error {
+				t.Errorf("permanent failure sent for retry: %s", err)
+				return nil
+			}
+
+			Convey("Then the message is sent to the dead-letter topic rather than retried", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(&payment.InvalidPaymentAPIResponse{}).Times(1)
+
+				svc.Start(wg, c)
+
+				So(sender.sent, ShouldHaveLength, 1)
+				value, _ := sender.sent[0].Value.Encode()
+				var envelope dlq.Envelope
+				So(json.Unmarshal(value, &envelope), ShouldBeNil)
+				So(envelope.FailureClass, ShouldEqual, dlq.FailureRejected)
+				So(envelope.Format, ShouldEqual, codec.FormatAvro)
+			})
+		})
+
+		Convey("Given a message for a refund of a payment which has enough left to refund", func() {
+			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
+			svc.VerifyPayments = true
+
+			Convey("Then the payment is checked before a refund request is sent to the Payments API", func() {
+				gomock.InOrder(
+					mockPayment.EXPECT().GetPayment(gomock.Any(), paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).Return(&data.PaymentResource{
+						Amount:  "150.00",
+						Status:  payment.PaymentStatusPaid,
+						Refunds: []data.RefundResource{{Amount: 5000, Status: "success"}},
+					}, nil),
+					mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), refundPostRequest, gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+						endConsumerProcess(svc, c)
+					}).Return(nil),
+				)
+
+				svc.Start(wg, c)
+
+				So(svc.RecentOutcomes(1)[0].Outcome, ShouldEqual, OutcomeSucceeded)
+			})
+		})
+
+		Convey("Given a message for a refund of a payment with too little left to refund", func() {
+			svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID)
+			svc.VerifyPayments = true
+			sender := &mockSender{}
+			svc.DeadLetter = dlq.New("refund-request-dlq", sender)
+			svc.HandleError = func(err error, offset int64, str interface{}) error {
+				t.Errorf("permanent failure sent for retry: %s", err)
+				return nil
+			}
+
+			Convey("Then it is sent to the dead-letter topic and no refund request is sent to the Payments API", func() {
+				sender.onSend = func() { endConsumerProcess(svc, c) }
+				mockPayment.EXPECT().GetPayment(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&data.PaymentResource{
+					Amount:  "150.00",
+					Status:  payment.PaymentStatusPaid,
+					Refunds: []data.RefundResource{{Amount: 6000, Status: "success"}},
+				}, nil)
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				svc.Start(wg, c)
+
+				So(sender.sent, ShouldHaveLength, 1)
+				value, _ := sender.sent[0].Value.Encode()
+				var envelope dlq.Envelope
+				So(json.Unmarshal(value, &envelope), ShouldBeNil)
+				So(envelope.FailureClass, ShouldEqual, dlq.FailureNotRefundable)
+				So(envelope.Error, ShouldContainSubstring, "exceeds the 9000 pence remaining")
+
+				outcome := svc.RecentOutcomes(1)[0]
+				So(outcome.Outcome, ShouldEqual, OutcomeDeadLettered)
+				So(outcome.FailureClass, ShouldEqual, string(payment.ClassNotRefundable))
+			})
+		})
+
+		Convey("Given a message with an invalid payment ID and an empty refund reference consumed by the error consumer", func() {
+			svc.Consumer = createMockConsumerWithMessage(1, "payment/../1", "100.00", "")
+			sender := &mockSender{}
+			svc.DeadLetter = dlq.New("refund-request-dlq", sender)
+
+			Convey("Then it is sent to the dead-letter topic with the reason for each field", func() {
+				sender.onSend = func() { endConsumerProcess(svc, c) }
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				svc.Start(wg, c)
+
+				So(sender.sent, ShouldHaveLength, 1)
+				value, _ := sender.sent[0].Value.Encode()
+				var envelope dlq.Envelope
+				So(json.Unmarshal(value, &envelope), ShouldBeNil)
+				So(envelope.FailureClass, ShouldEqual, dlq.FailureInvalid)
+				So(envelope.Fields, ShouldContainKey, "payment_id")
+				So(envelope.Fields["refund_reference"], ShouldEqual, "is empty")
+
+				outcome := svc.RecentOutcomes(1)[0]
+				So(outcome.Outcome, ShouldEqual, OutcomeDeadLettered)
+				So(outcome.FailureClass, ShouldEqual, string(dlq.FailureInvalid))
+			})
+		})
+
+		Convey("Given an invalid message consumed by a consumer with an error topic", func() {
+			svc.Consumer = createMockConsumerWithMessage(1, "payment/../1", "100.00", "")
+			sender := &mockSender{}
+			svc.Invalid = ladder.NewPublisher(ladder.Ladder{ErrorTopic: "refund-request-error"}, sender, MockSchema)
+			deadLetters := &mockSender{}
+			svc.DeadLetter = dlq.New("refund-request-dlq", deadLetters)
+
+			Convey("Then it is sent to the error topic with the reason for each field", func() {
+				sender.onSend = func() { endConsumerProcess(svc, c) }
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				svc.Start(wg, c)
+
+				So(deadLetters.sent, ShouldBeEmpty)
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].Topic, ShouldEqual, "refund-request-error")
+				So(sender.sent[0].Headers, ShouldHaveLength, 1)
+				So(string(sender.sent[0].Headers[0].Key), ShouldEqual, ladder.HeaderInvalidFields)
+				var fields map[string]string
+				So(json.Unmarshal(sender.sent[0].Headers[0].Value, &fields), ShouldBeNil)
+				So(fields, ShouldContainKey, "payment_id")
+				So(fields["refund_reference"], ShouldEqual, "is empty")
+
+				outcome := svc.RecentOutcomes(1)[0]
+				So(outcome.Outcome, ShouldEqual, OutcomeErrorTopic)
+				So(outcome.Destination, ShouldEqual, "refund-request-error")
+				So(outcome.FailureClass, ShouldEqual, string(dlq.FailureInvalid))
+			})
+		})
+
+		Convey("Given a message with an invalid refund amount is readily available for the service to consume", func() {
+			svc.Consumer = createMockConsumerWithMessage(1, paymentResourceID, "12.345", "ref")
+			sender := &mockSender{}
+			svc.DeadLetter = dlq.New("refund-request-dlq", sender)
+
+			Convey("Then the message is sent to the dead-letter topic and no refund request is sent to the Payments API", func() {
+				sender.onSend = func() { endConsumerProcess(svc, c) }
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				svc.Start(wg, c)
+
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].Topic, ShouldEqual, "refund-request-dlq")
+				value, _ := sender.sent[0].Value.Encode()
+				var envelope dlq.Envelope
+				So(json.Unmarshal(value, &envelope), ShouldBeNil)
+				So(envelope.FailureClass, ShouldEqual, dlq.FailureInvalidAmount)
+				So(envelope.Error, ShouldContainSubstring, money.ErrScale.Error())
+
+				outcome := svc.RecentOutcomes(1)[0]
+				So(outcome.Outcome, ShouldEqual, OutcomeDeadLettered)
+				So(outcome.FailureClass, ShouldEqual, string(dlq.FailureInvalidAmount))
+				So(outcome.Destination, ShouldEqual, "refund-request-dlq")
+			})
+
+			Convey("Then the offset is not committed when the dead-letter topic is unavailable at shutdown", func() {
+				group := &MockGroup{}
+				svc.Consumer.Group = group
+				sender.err = errors.New("broker down")
+				sender.onSend = func() { endConsumerProcess(svc, c) }
+
+				svc.Start(wg, c)
+
+				So(sender.sent, ShouldHaveLength, 1)
+				So(group.marked, ShouldBeEmpty)
+				So(svc.RecentOutcomes(1)[0].Outcome, ShouldEqual, OutcomeCancelled)
+			})
+		})
 	})
 }
 
-func TestUnitConvertToPenceFromDecimal(t *testing.T) {
-	Convey("Convert decimal payment in pounds to pence", t, func() {
-		amount, err := convertDecimalAmountToPence("116.32")
-		So(err, ShouldBeNil)
-		So(amount, ShouldEqual, 11632)
+func TestUnitStartAudit(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a message for a refund with an outcome topic configured", t, func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c := make(chan os.Signal)
+
+		mockPayment := payment.NewMockPayments(ctrl)
+		svc := createMockService(mockPayment)
+		svc.Consumer = createMockConsumer(&sarama.ConsumerMessage{Topic: "refund-request", Partition: 1, Offset: 9, Value: prepareTestKafkaMessage(1, paymentResourceID, "100.00", "ref")})
+		group := &MockGroup{}
+		svc.Consumer.Group = group
+		sender := &mockSender{}
+		svc.Audit = audit.New("refund-request-outcome", sender, &avro.Schema{Definition: outcomeSchema})
+
+		Convey("Then an outcome event is published before the offset is committed", func() {
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			sender.onSend = func() { endConsumerProcess(svc, c) }
+
+			svc.Start(wg, c)
+
+			So(sender.sent, ShouldHaveLength, 1)
+			So(sender.sent[0].Topic, ShouldEqual, "refund-request-outcome")
+			value, _ := sender.sent[0].Value.Encode()
+			var event data.RefundRequestOutcome
+			So(svc.Audit.Schema.(*avro.Schema).Unmarshal(value, &event), ShouldBeNil)
+			So(event.PaymentID, ShouldEqual, paymentResourceID)
+			So(event.RefundReference, ShouldEqual, "ref")
+			So(event.AmountPence, ShouldEqual, 10000)
+			So(event.Attempt, ShouldEqual, 1)
+			So(event.Outcome, ShouldEqual, OutcomeSucceeded)
+			So(event.Role, ShouldEqual, svc.Role)
+			So(event.Topic, ShouldEqual, "refund-request")
+			So(event.Partition, ShouldEqual, 1)
+			So(event.Offset, ShouldEqual, 9)
+			So(event.ProcessedAt, ShouldBeGreaterThan, 0)
+			So(group.marked, ShouldResemble, []int64{9})
+		})
+
+		Convey("Then the offset is not committed when the outcome topic is unavailable at shutdown", func() {
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			sender.err = errors.New("broker down")
+			sender.onSend = func() { endConsumerProcess(svc, c) }
+
+			svc.Start(wg, c)
+
+			So(sender.sent, ShouldNotBeEmpty)
+			So(group.marked, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestUnitStartConcurrency(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Concurrent processing of Kafka messages for refunds", t, func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c := make(chan os.Signal)
+
+		mockPayment := payment.NewMockPayments(ctrl)
+
+		svc := createMockService(mockPayment)
+		svc.Concurrency = 2
+
+		refundMessage := func(offset int64, paymentID string) *sarama.ConsumerMessage {
+			return &sarama.ConsumerMessage{Offset: offset, Value: prepareTestKafkaMessage(1, paymentID, "100.00", "ref")}
+		}
+
+		Convey("Given messages for different payments on one partition ordered by payment ID", func() {
+			svc.OrderBy = OrderByPaymentID
+			svc.Consumer = createMockConsumer(refundMessage(0, "payment-1"), refundMessage(1, "payment-2"))
+			group := &MockGroup{onMark: func() { endConsumerProcess(svc, c) }}
+			svc.Consumer.Group = group
+
+			Convey("Then a later message completing first is not committed until the earlier one completes", func() {
+				secondDone := make(chan struct{})
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/payment-1/refunds", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+					<-secondDone
+					for svc.Health.LoopCheck().Data["in_flight"] != 1 {
+						time.Sleep(time.Millisecond)
+					}
+					return nil
+				}).Times(1)
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/payment-2/refunds", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+					close(secondDone)
+					return nil
+				}).Times(1)
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldResemble, []int64{1})
+			})
+		})
+
+		Convey("Given messages for different payments on one partition ordered by partition", func() {
+			svc.OrderBy = OrderByPartition
+			svc.Consumer = createMockConsumer(refundMessage(0, "payment-1"), refundMessage(1, "payment-2"))
+			group := &MockGroup{}
+			svc.Consumer.Group = group
+
+			Convey("Then the messages are processed one at a time, in order", func() {
+				var mu sync.Mutex
+				var posted []string
+				inFlight, maxInFlight := 0, 0
+				post := func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) error {
+					mu.Lock()
+					inFlight++
+					if inFlight > maxInFlight {
+						maxInFlight = inFlight
+					}
+					posted = append(posted, postURL)
+					mu.Unlock()
+
+					time.Sleep(10 * time.Millisecond)
+
+					mu.Lock()
+					inFlight--
+					if len(posted) == 2 {
+						endConsumerProcess(svc, c)
+					}
+					mu.Unlock()
+					return nil
+				}
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(post).Times(2)
+
+				svc.Start(wg, c)
+
+				So(maxInFlight, ShouldEqual, 1)
+				So(posted, ShouldResemble, []string{paymentsAPIUrl + "/payments/payment-1/refunds", paymentsAPIUrl + "/payments/payment-2/refunds"})
+			})
+		})
+
+		Convey("Given retried messages on two partitions, one of which is not yet due", func() {
+			svc.Backoff = backoff.New(time.Hour, time.Hour, 0)
+			svc.Consumer = createMockConsumer(
+				&sarama.ConsumerMessage{Partition: 0, Offset: 0, Timestamp: time.Now(), Value: prepareTestKafkaMessage(1, "payment-1", "100.00", "ref")},
+				&sarama.ConsumerMessage{Partition: 1, Offset: 0, Timestamp: time.Now().Add(-2 * time.Hour), Value: prepareTestKafkaMessage(1, "payment-2", "100.00", "ref")},
+			)
+			group := &MockGroup{}
+			svc.Consumer.Group = group
+
+			Convey("Then the message that is due is processed while the other partition is paused", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/payment-2/refunds", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(nil).Times(1)
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/payment-1/refunds", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldResemble, []int64{0})
+				So(svc.commits.outstanding(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("Given more retried messages not yet due on one partition than a worker queue holds, followed by one that is due on another", func() {
+			svc.Backoff = backoff.New(time.Hour, time.Hour, 0)
+			var messages []*sarama.ConsumerMessage
+			for offset := int64(0); offset < 2*workerQueueSize; offset++ {
+				messages = append(messages, &sarama.ConsumerMessage{Partition: 0, Offset: offset, Timestamp: time.Now(), Value: prepareTestKafkaMessage(1, "payment-1", "100.00", "ref")})
+			}
+			messages = append(messages, &sarama.ConsumerMessage{Partition: 1, Offset: 0, Timestamp: time.Now().Add(-2 * time.Hour), Value: prepareTestKafkaMessage(1, "payment-2", "100.00", "ref")})
+			svc.Consumer = createMockConsumer(messages...)
+			group := &MockGroup{}
+			svc.Consumer.Group = group
+
+			Convey("Then the other partition keeps making progress", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/payment-2/refunds", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, postURL string, postBody data.RefundPostRequest, idempotencyKey string, HTTPClient *http.Client, apiKey string) {
+					endConsumerProcess(svc, c)
+				}).Return(nil).Times(1)
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/payment-1/refunds", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldResemble, []int64{0})
+				So(svc.commits.outstanding(), ShouldEqual, 2*workerQueueSize)
+			})
+		})
+
+		Convey("Given more retried messages not yet due on one partition than its delay queue holds", func() {
+			svc.Backoff = backoff.New(time.Hour, time.Hour, 0)
+			var messages []*sarama.ConsumerMessage
+			for offset := int64(0); offset < 2*delayQueueSize; offset++ {
+				messages = append(messages, &sarama.ConsumerMessage{Partition: 0, Offset: offset, Timestamp: time.Now(), Value: prepareTestKafkaMessage(1, "payment-1", "100.00", "ref")})
+			}
+			svc.Consumer = createMockConsumer(messages...)
+			group := &MockGroup{}
+			svc.Consumer.Group = group
+
+			Convey("Then the consumer stops fetching once the queue is full", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				var consumed int64
+				time.AfterFunc(100*time.Millisecond, func() {
+					consumed = svc.consumed.snapshot()[0]
+					endConsumerProcess(svc, c)
+				})
+
+				svc.Start(wg, c)
+
+				So(group.marked, ShouldBeEmpty)
+				// One message is waiting to fall due, the queue holds the next
+				// ones, and the one after them waits to be queued.
+				So(consumed, ShouldEqual, delayQueueSize+1)
+			})
+		})
 	})
 }
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/hold"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/companieshouse/refund-request-consumer/policy"
+)
+
+// RoleSubmit is the role of a service submitting refunds outside of kafka.
+const RoleSubmit = "submit"
+
+// NewSubmitter returns a service which submits refunds to the payments api as
+// the consumers do, but does not consume or publish messages.
+func NewSubmitter(cfg *config.Config, dedupe idempotency.Store) (*Service, error) {
+	statuses, err := paymentStatuses(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring payments api statuses: %w", err)
+	}
+	rules, err := ValidationRules(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring refund request validation: %w", err)
+	}
+
+	return &Service{
+		Payments:       payment.New(statuses),
+		VerifyPayments: cfg.VerifyPayment,
+		PaymentsAPIURL: cfg.PaymentsAPIURL,
+		Client:         payment.NewHTTPClient(time.Duration(cfg.PaymentsConnectTimeout)*time.Second, time.Duration(cfg.PaymentsReadTimeout)*time.Second, time.Duration(cfg.PaymentsTimeout)*time.Second),
+		ApiKey:         cfg.ChsAPIKey,
+		Dedupe:         dedupe,
+		Rules:          rules,
+		Role:           RoleSubmit,
+	}, nil
+}
+
+// ErrHeld is returned when a submitted refund breaches the refund policy, so
+// is held for approval rather than submitted.
+var ErrHeld = errors.New("refund held for approval")
+
+// Submit validates a refund request and submits it to the payments api in the
+// same way as a consumed message, sharing its idempotency records. A refund
+// breaching the refund policy is parked on the held topic instead, returning
+// ErrHeld. With an outcome topic, a submitted refund's outcome is published
+// so that other consumers count it towards the refund policy totals.
+func (svc *Service) Submit(ctx context.Context, rr data.RefundRequest) error {
+	amount, err := svc.rules().Validate(rr)
+	if err != nil {
+		return err
+	}
+
+	decision := svc.admit(rr, amount)
+	if decision.Action == policy.Hold {
+		if svc.Holds == nil {
+			return fmt.Errorf("refund needs approval but no held topic is configured: %s", decision.Reason)
+		}
+		r := hold.New(rr, int64(amount), decision.Reason, &sarama.ConsumerMessage{}, time.Now())
+		if err := svc.Holds.park(r); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w with ID [%s]: %s", ErrHeld, r.ID, decision.Reason)
+	}
+
+	o, err := svc.submit(ctx, rr, amount)
+	if err != nil {
+		if svc.Policy != nil {
+			svc.Policy.Cancel(decision)
+		}
+		return err
+	}
+	if err := svc.publishOutcome(o); err != nil {
+		return fmt.Errorf("refund submitted but its outcome was not published: %w", err)
+	}
+	return nil
+}
+
+// submit submits a valid refund request to the payments api without applying
+// the refund policy, returning the outcome to publish for it. The outcome of
+// a failed refund is left for the caller to set.
+func (svc *Service) submit(ctx context.Context, rr data.RefundRequest, amount int) (Outcome, error) {
+	submitted, err := svc.submitRefund(ctx, &rr, amount)
+	o := Outcome{
+		Role:            svc.Role,
+		PaymentID:       rr.PaymentID,
+		RefundReference: rr.RefundReference,
+		RefundAmount:    rr.RefundAmount,
+		AmountPence:     int64(amount),
+		Attempt:         rr.Attempt,
+		HTTPStatus:      submitted.status,
+		LatencyMillis:   submitted.latency.Milliseconds(),
+		ProcessedAt:     time.Now(),
+	}
+	if err != nil {
+		class, ok := payment.ClassOf(err)
+		if !ok {
+			class = "unclassified"
+		}
+		o.FailureClass, o.Error = string(class), err.Error()
+		return o, err
+	}
+
+	o.Outcome = OutcomeSucceeded
+	if !submitted.posted {
+		o.Outcome = OutcomeAlreadySucceeded
+	}
+	return o, nil
+}
+
+// publishOutcome publishes the event recording the outcome of a refund
+// submitted outside of the consume loop, if outcome events are on.
+func (svc *Service) publishOutcome(o Outcome) error {
+	if svc.Audit == nil {
+		return nil
+	}
+	return svc.Audit.Publish(o.event())
+}
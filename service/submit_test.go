@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/hold"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/payment"
+	"github.com/companieshouse/refund-request-consumer/policy"
+	"github.com/companieshouse/refund-request-consumer/validation"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitSubmit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("A submitter is built from config without kafka", t, func() {
+		svc, err := NewSubmitter(&config.Config{PaymentsAPIURL: paymentsAPIUrl, ChsAPIKey: apiKey, VerifyPayment: true}, idempotency.NewMemoryStore(0))
+		So(err, ShouldBeNil)
+		So(svc.Role, ShouldEqual, RoleSubmit)
+		So(svc.Consumer, ShouldBeNil)
+		So(svc.VerifyPayments, ShouldBeTrue)
+
+		_, err = NewSubmitter(&config.Config{SuccessStatuses: []string{"abc"}}, idempotency.NewMemoryStore(0))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A refund is submitted as a consumed message would be", t, func() {
+		mockPayment := payment.NewMockPayments(mockCtrl)
+		svc := createMockService(mockPayment)
+		rr := data.RefundRequest{PaymentID: paymentResourceID, RefundAmount: "100.00", RefundReference: "ref"}
+
+		mockPayment.EXPECT().RefundRequestPost(gomock.Any(), paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds", refundPostRequest, payment.IdempotencyKey(paymentResourceID, refundPostRequest), gomock.Any(), apiKey).Return(nil)
+		So(svc.Submit(context.Background(), rr), ShouldBeNil)
+
+		record, found, _ := svc.Dedupe.Get(idempotency.Key(paymentResourceID, "ref"))
+		So(found, ShouldBeTrue)
+		So(record.State, ShouldEqual, idempotency.Succeeded)
+
+		Convey("and is not submitted again once it has succeeded", func() {
+			So(svc.Submit(context.Background(), rr), ShouldBeNil)
+		})
+
+		Convey("A failure is returned", func() {
+			rr.RefundReference = "other"
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("failed"))
+			So(svc.Submit(context.Background(), rr), ShouldNotBeNil)
+		})
+
+		Convey("An invalid amount is refused before submitting", func() {
+			rr.RefundAmount = "ten pounds"
+			So(svc.Submit(context.Background(), rr), ShouldNotBeNil)
+		})
+
+		Convey("Every invalid field is reported before submitting", func() {
+			rr.PaymentID, rr.RefundReference = "../payments", " "
+			err := svc.Submit(context.Background(), rr)
+
+			var invalid *validation.Error
+			So(errors.As(err, &invalid), ShouldBeTrue)
+			So(invalid.Reasons(), ShouldContainKey, validation.FieldPaymentID)
+			So(invalid.Reasons(), ShouldContainKey, validation.FieldRefundReference)
+		})
+
+		Convey("A payment which is not paid is refused before submitting", func() {
+			svc.VerifyPayments = true
+			rr.RefundReference = "unpaid"
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentsAPIUrl+"/payments/"+paymentResourceID, gomock.Any(), apiKey).Return(&data.PaymentResource{Amount: "100.00", Status: "failed"}, nil)
+			err := svc.Submit(context.Background(), rr)
+
+			var notRefundable *payment.NotRefundableError
+			So(errors.As(err, &notRefundable), ShouldBeTrue)
+			So(payment.IsPermanent(err), ShouldBeTrue)
+		})
+
+		Convey("A payment the Payments API does not know is refused before submitting", func() {
+			svc.VerifyPayments = true
+			rr.RefundReference = "unknown"
+			mockPayment.EXPECT().GetPayment(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, &payment.InvalidPaymentAPIResponse{})
+			err := svc.Submit(context.Background(), rr)
+
+			So(payment.IsPermanent(err), ShouldBeTrue)
+		})
+
+		Convey("A refund breaching the refund policy is held instead of submitted", func() {
+			sender := &mockSender{}
+			svc.Policy = policy.New(policy.Limits{HoldAbove: 5000})
+			svc.Holds = NewHolds(hold.NewPublisher("refund-request-held", sender), hold.NewLedger(), svc.Policy, svc)
+			rr.RefundReference = "large"
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			err := svc.Submit(context.Background(), rr)
+			So(errors.Is(err, ErrHeld), ShouldBeTrue)
+			So(sender.sent, ShouldHaveLength, 1)
+			So(svc.Holds.Pending(), ShouldHaveLength, 1)
+
+			Convey("and submitted without the policy once approved", func() {
+				mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				_, err := svc.Holds.Approve(context.Background(), svc.Holds.Pending()[0].ID, "")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("A refund with an unknown outcome is resubmitted without checking the balance it may already be part of", func() {
+			svc.VerifyPayments = true
+			rr.RefundReference = "resubmitted"
+			So(svc.recordRefund(idempotency.Key(paymentResourceID, "resubmitted"), idempotency.Submitted), ShouldBeNil)
+			mockPayment.EXPECT().GetPayment(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&data.PaymentResource{
+				Amount:  "100.00",
+				Status:  payment.PaymentStatusPaid,
+				Refunds: []data.RefundResource{{Amount: 10000, Status: "submitted"}},
+			}, nil)
+			mockPayment.EXPECT().RefundRequestPost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+			So(svc.Submit(context.Background(), rr), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/companieshouse/refund-request-consumer/codec"
+	"github.com/companieshouse/refund-request-consumer/config"
+	"github.com/companieshouse/refund-request-consumer/idempotency"
+	"github.com/companieshouse/refund-request-consumer/ladder"
+)
+
+// ValidateConfig returns every problem with cfg that would stop the consumers
+// starting, joined into one error, or nil if there are none.
+func ValidateConfig(cfg *config.Config) error {
+	var errs []error
+	required := func(name, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is not configured", name))
+		}
+	}
+
+	if len(cfg.BrokerAddr) == 0 {
+		errs = append(errs, errors.New("kafka broker address is not configured"))
+	}
+	required("schema registry url", cfg.SchemaRegistryURL)
+	required("refund request topic", cfg.ConsumerTopic)
+	required("payments api url", cfg.PaymentsAPIURL)
+
+	if _, err := paymentStatuses(cfg); err != nil {
+		errs = append(errs, fmt.Errorf("invalid payments api statuses: %w", err))
+	}
+	if _, err := ValidationRules(cfg); err != nil {
+		errs = append(errs, fmt.Errorf("invalid refund request validation: %w", err))
+	}
+	if cfg.PolicyHoldAbove < 0 || cfg.PolicyDailyPayment < 0 || cfg.PolicyHourly < 0 {
+		errs = append(errs, errors.New("refund policy limits must not be negative"))
+	}
+	if limits := PolicyLimits(cfg); limits.Enabled() {
+		required("held topic", cfg.HeldTopic)
+		// The totals are only shared between consumers, and kept across
+		// restarts, through the outcome topic.
+		if limits.Totals() {
+			required("outcome topic, required by the refund policy totals,", cfg.OutcomeTopic)
+		}
+	}
+	if !validOrderBy(cfg.OrderBy) {
+		errs = append(errs, fmt.Errorf("unknown consumer ordering [%s], expected %s or %s", cfg.OrderBy, OrderByPartition, OrderByPaymentID))
+	}
+	switch cfg.IdempotencyStore {
+	case "", idempotency.KindMemory:
+	case idempotency.KindFile:
+		required("idempotency store path", cfg.IdempotencyStorePath)
+	default:
+		errs = append(errs, fmt.Errorf("unknown idempotency store kind [%s]", cfg.IdempotencyStore))
+	}
+
+	if _, err := codec.ParseTopicFormats(cfg.TopicFormats); err != nil {
+		errs = append(errs, fmt.Errorf("invalid topic formats: %w", err))
+	}
+	tiers, err := ladder.ParseTiers(cfg.ConsumerTopic, cfg.RetryTiers)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid retry ladder: %w", err))
+	}
+	if err := ValidateAssignments(Assignments(cfg, tiers)); err != nil {
+		errs = append(errs, fmt.Errorf("invalid consumer group configuration: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/companieshouse/refund-request-consumer/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitValidateConfig(t *testing.T) {
+	Convey("Given a complete configuration", t, func() {
+		cfg := &config.Config{
+			BrokerAddr:             []string{"kafka:9092"},
+			SchemaRegistryURL:      "http://schema-registry",
+			ConsumerTopic:          "refund-request",
+			ConsumerGroupName:      "refund-request-consumer",
+			ConsumerRetryGroupName: "refund-request-consumer-retry",
+			ConsumerErrorGroupName: "refund-request-consumer-error",
+			PaymentsAPIURL:         "http://payments",
+			RetryTiers:             []string{"1m", "10m"},
+		}
+		So(ValidateConfig(cfg), ShouldBeNil)
+
+		Convey("Every problem is reported", func() {
+			cfg.BrokerAddr = nil
+			cfg.PaymentsAPIURL = ""
+			cfg.OrderBy = "random"
+			cfg.RetryableStatuses = []string{"abc"}
+			cfg.IdempotencyStore = "file"
+			cfg.RetryTiers = []string{"1m", "1m"}
+			cfg.ConsumerErrorGroupName = cfg.ConsumerGroupName
+
+			err := ValidateConfig(cfg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "kafka broker address is not configured")
+			So(err.Error(), ShouldContainSubstring, "payments api url is not configured")
+			So(err.Error(), ShouldContainSubstring, "unknown consumer ordering [random]")
+			So(err.Error(), ShouldContainSubstring, "invalid payments api statuses")
+			So(err.Error(), ShouldContainSubstring, "idempotency store path is not configured")
+			So(err.Error(), ShouldContainSubstring, "invalid retry ladder")
+			So(err.Error(), ShouldContainSubstring, "invalid consumer group configuration")
+		})
+
+		Convey("The refund policy totals require the outcome topic", func() {
+			cfg.HeldTopic = "refund-request-held"
+			cfg.PolicyHoldAbove = 100000
+			So(ValidateConfig(cfg), ShouldBeNil)
+
+			cfg.PolicyHourly = 500000
+			err := ValidateConfig(cfg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "outcome topic")
+
+			cfg.OutcomeTopic = "refund-request-outcome"
+			So(ValidateConfig(cfg), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/avro"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/metrics"
+)
+
+// Ways of assigning messages to workers. Messages from the same partition, or
+// for the same payment ID, are always processed in order by the same worker.
+const (
+	OrderByPartition = "partition"
+	OrderByPaymentID = "payment_id"
+)
+
+// workerQueueSize is the number of messages that may wait for each worker
+// before the consume loop stops fetching.
+const workerQueueSize = 16
+
+// job is a message dispatched to a worker. It is decoded before dispatch so
+// that it can be assigned to a worker by payment ID.
+type job struct {
+	message  *sarama.ConsumerMessage
+	received time.Time
+	due      time.Time
+	rr       data.RefundRequest
+	err      error
+}
+
+// workerPool is a fixed set of workers, each processing the messages on its
+// own queue in order. Messages which are not yet due wait in a bounded queue
+// for their partition until they are.
+type workerPool struct {
+	queues  []chan job
+	wg      sync.WaitGroup
+	delayed map[int32]*delayQueue
+	delayWg sync.WaitGroup
+}
+
+// delayQueueSize is the number of retried messages which may wait on a
+// partition's delay queue before the consume loop stops fetching.
+const delayQueueSize = 64
+
+// delayQueue holds the messages of a partition until they are due. Once it
+// holds size messages, pushing blocks until the message at the front is due,
+// so that a retry consumer never reads more of its topic than it can hold.
+// The group consumer cannot pause a single partition, so blocking stops the
+// consume loop fetching from every partition, while the messages already
+// queued for the others are still passed to their workers as they fall due.
+type delayQueue struct {
+	mu     sync.Mutex
+	size   int
+	jobs   []job
+	closed bool
+	ready  chan struct{}
+	space  chan struct{}
+}
+
+func newDelayQueue(size int) *delayQueue {
+	return &delayQueue{size: size, ready: make(chan struct{}, 1), space: make(chan struct{}, 1)}
+}
+
+// push adds a job to the back of the queue, waiting while it is full. It
+// returns false if ctx is cancelled first.
+func (q *delayQueue) push(ctx context.Context, j job) bool {
+	for {
+		q.mu.Lock()
+		if len(q.jobs) < q.size {
+			q.jobs = append(q.jobs, j)
+			q.mu.Unlock()
+			notify(q.ready)
+			return true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.space:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// close stops the queue once the jobs already on it have been popped.
+func (q *delayQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	notify(q.ready)
+}
+
+// notify wakes the goroutine waiting on c, if any, without blocking.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes the job at the front of the queue, waiting for one if it is
+// empty. It returns false once the queue is closed and empty.
+func (q *delayQueue) pop() (job, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.jobs) > 0 {
+			j := q.jobs[0]
+			q.jobs[0] = job{}
+			q.jobs = q.jobs[1:]
+			q.mu.Unlock()
+			notify(q.space)
+			return j, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return job{}, false
+		}
+		<-q.ready
+	}
+}
+
+// stop closes the delay and worker queues and waits for the workers to
+// finish.
+func (p *workerPool) stop() {
+	for _, queue := range p.delayed {
+		queue.close()
+	}
+	p.delayWg.Wait()
+
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// validOrderBy reports whether orderBy is a known way of assigning messages
+// to workers. Empty means OrderByPartition.
+func validOrderBy(orderBy string) bool {
+	switch orderBy {
+	case "", OrderByPartition, OrderByPaymentID:
+		return true
+	}
+	return false
+}
+
+// startWorkers starts svc.Concurrency workers, or one if it is not set. The
+// workers stop taking jobs once stop is cancelled, and the jobs in flight are
+// cancelled with ctx.
+func (svc *Service) startWorkers(stop, ctx context.Context) *workerPool {
+	n := svc.Concurrency
+	if n < 1 {
+		n = 1
+	}
+
+	log.Info(fmt.Sprintf("starting %d workers, ordered by %s", n, svc.orderBy()))
+
+	pool := &workerPool{queues: make([]chan job, n), delayed: make(map[int32]*delayQueue)}
+	for i := range pool.queues {
+		pool.queues[i] = make(chan job, workerQueueSize)
+		pool.wg.Add(1)
+		go svc.work(stop, ctx, pool.queues[i], &pool.wg)
+	}
+	return pool
+}
+
+func (svc *Service) orderBy() string {
+	if svc.OrderBy == "" {
+		return OrderByPartition
+	}
+	return svc.OrderBy
+}
+
+// dispatch decodes a message and queues it for its worker. Messages before the
+// initial offset are not processed, but are still committed in order. Retried
+// messages are parked on their partition's delay queue, waiting while it is
+// full.
+func (svc *Service) dispatch(ctx context.Context, pool *workerPool, message *sarama.ConsumerMessage) {
+	svc.consumed.set(message.Partition, message.Offset)
+	metrics.MessagesConsumed.WithLabelValues(svc.Role, svc.Topic).Inc()
+
+	j := job{message: message, received: time.Now()}
+	svc.commits.add(message)
+
+	if message.Offset < svc.InitialOffset {
+		svc.finish(j, true)
+		return
+	}
+
+	if svc.Decoder != nil {
+		j.rr, j.err = svc.Decoder.Decode(message)
+	} else {
+		j.err = (&avro.Schema{Definition: svc.RefundRequestSchema}).Unmarshal(message.Value, &j.rr)
+	}
+
+	if svc.Backoff != nil {
+		j.due = svc.due(j)
+		if !svc.delayQueue(ctx, pool, message.Partition).push(ctx, j) {
+			svc.finish(j, false)
+		}
+		return
+	}
+
+	select {
+	case pool.queues[svc.workerFor(j, len(pool.queues))] <- j:
+	case <-ctx.Done():
+		svc.finish(j, false)
+	}
+}
+
+// due returns when a retried message is due to be processed: its backoff
+// delay after it was published, or after it was received if the message has
+// no timestamp.
+func (svc *Service) due(j job) time.Time {
+	published := j.message.Timestamp
+	if published.IsZero() {
+		published = j.received
+	}
+	return svc.Backoff.Due(published, int(j.rr.Attempt))
+}
+
+// delayQueue returns the delay queue for a partition, starting it if needed.
+// The queue holds each message until it is due, then passes it to its worker,
+// so the partition is paused without holding up the others.
+func (svc *Service) delayQueue(ctx context.Context, pool *workerPool, partition int32) *delayQueue {
+	queue, ok := pool.delayed[partition]
+	if ok {
+		return queue
+	}
+
+	queue = newDelayQueue(delayQueueSize)
+	pool.delayed[partition] = queue
+	pool.delayWg.Add(1)
+	go svc.delay(ctx, pool, queue)
+	return queue
+}
+
+// delay passes the jobs on a delay queue to their workers once they are due,
+// until the queue is closed. Once ctx is cancelled the remaining jobs are left
+// uncommitted.
+func (svc *Service) delay(ctx context.Context, pool *workerPool, queue *delayQueue) {
+	defer pool.delayWg.Done()
+
+	for j, ok := queue.pop(); ok; j, ok = queue.pop() {
+		if wait := time.Until(j.due); wait > 0 && ctx.Err() == nil {
+			log.Debug(fmt.Sprintf("pausing partition %d until retry is due", j.message.Partition), log.Data{"message_offset": j.message.Offset, "due": j.due, "attempt": j.rr.Attempt})
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+		if ctx.Err() != nil {
+			svc.finish(j, false)
+			continue
+		}
+
+		select {
+		case pool.queues[svc.workerFor(j, len(pool.queues))] <- j:
+		case <-ctx.Done():
+			svc.finish(j, false)
+		}
+	}
+}
+
+// workerFor returns the index of the worker a job is assigned to. A message
+// that could not be decoded has no payment ID, so is assigned by partition.
+func (svc *Service) workerFor(j job, workers int) int {
+	if svc.OrderBy == OrderByPaymentID && j.err == nil {
+		h := fnv.New32a()
+		h.Write([]byte(j.rr.PaymentID))
+		return int(h.Sum32() % uint32(workers))
+	}
+	return int(j.message.Partition) % workers
+}
+
+// work processes the jobs on a queue until it is closed. While the service is
+// paused each job waits before being processed. Once stop is cancelled the
+// remaining jobs are left uncommitted, to be consumed again after restart.
+func (svc *Service) work(stop, ctx context.Context, queue <-chan job, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for j := range queue {
+		if svc.pause.wait(stop) != nil {
+			svc.finish(j, false)
+			continue
+		}
+
+		done := svc.Health.Processing()
+		svc.finish(j, svc.process(ctx, j))
+		done()
+	}
+}
+
+// finish records that a job is no longer being processed and, if commit is
+// true, that its offset may be committed once those before it have been.
+func (svc *Service) finish(j job, commit bool) {
+	if commit {
+		svc.commits.complete(j.message, svc.commit)
+	}
+
+	metrics.ProcessingDuration.WithLabelValues(svc.Role).Observe(time.Since(j.received).Seconds())
+}
+
+// commit marks and commits the offset of a message.
+func (svc *Service) commit(message *sarama.ConsumerMessage) {
+	log.Trace(fmt.Sprintf("Committing message, offset: %d", message.Offset), log.Data{"partition": message.Partition})
+	svc.Consumer.MarkOffset(message, "")
+	if err := svc.Consumer.CommitOffsets(); err != nil {
+		log.Error(err, log.Data{"offset": message.Offset, "partition": message.Partition})
+		return
+	}
+	svc.committed.set(message.Partition, message.Offset)
+}
@@ -0,0 +1,114 @@
+// Package topic reads the messages on every partition of a topic outside of a
+// consumer group, first up to the tail of each partition and then as they
+// are published, to rebuild state kept on the topic.
+package topic
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// OffsetClient looks up partition offsets. It is satisfied by sarama.Client.
+type OffsetClient interface {
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+// Offsets are the offsets of the next message to read on each partition.
+type Offsets map[int32]int64
+
+// Load calls apply with every message on the topic, from the offset of each
+// partition at or after from up to its tail when Load is called. From is
+// sarama.OffsetOldest or a time in milliseconds since the epoch. Load returns
+// the offsets to follow the topic from.
+func Load(ctx context.Context, client OffsetClient, consumer sarama.Consumer, topic string, from int64, apply func(*sarama.ConsumerMessage)) (Offsets, error) {
+	partitions, err := consumer.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("error listing partitions of topic [%s]: %w", topic, err)
+	}
+	offsets := Offsets{}
+	for _, partition := range partitions {
+		end, err := loadPartition(ctx, client, consumer, topic, partition, from, apply)
+		if err != nil {
+			return nil, err
+		}
+		offsets[partition] = end
+	}
+	return offsets, nil
+}
+
+func loadPartition(ctx context.Context, client OffsetClient, consumer sarama.Consumer, topic string, partition int32, from int64, apply func(*sarama.ConsumerMessage)) (int64, error) {
+	end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("error getting tail offset of topic [%s] partition %d: %w", topic, partition, err)
+	}
+	start, err := client.GetOffset(topic, partition, from)
+	if err != nil {
+		return 0, fmt.Errorf("error getting start offset of topic [%s] partition %d: %w", topic, partition, err)
+	}
+	// No message was published at or after a start time.
+	if start < 0 || start >= end {
+		return end, nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return 0, fmt.Errorf("error consuming topic [%s] partition %d: %w", topic, partition, err)
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case err := <-pc.Errors():
+			return 0, fmt.Errorf("error reading topic [%s] partition %d: %w", topic, partition, err)
+		case msg := <-pc.Messages():
+			apply(msg)
+			if msg.Offset+1 >= end {
+				return end, nil
+			}
+		}
+	}
+}
+
+// Follow calls apply with every message published to the topic from the
+// offsets, until the context is cancelled. Apply is called from one goroutine
+// per partition. Errors reading a partition are passed to onError, and the
+// partition is still followed.
+func Follow(ctx context.Context, consumer sarama.Consumer, topic string, offsets Offsets, apply func(*sarama.ConsumerMessage), onError func(error)) error {
+	var pcs []sarama.PartitionConsumer
+	for partition, offset := range offsets {
+		pc, err := consumer.ConsumePartition(topic, partition, offset)
+		if err != nil {
+			for _, pc := range pcs {
+				pc.Close()
+			}
+			return fmt.Errorf("error consuming topic [%s] partition %d: %w", topic, partition, err)
+		}
+		pcs = append(pcs, pc)
+	}
+
+	for _, pc := range pcs {
+		go func(pc sarama.PartitionConsumer) {
+			defer pc.Close()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err := <-pc.Errors():
+					if err != nil {
+						onError(fmt.Errorf("error reading topic [%s]: %w", topic, err))
+					}
+				case msg, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					apply(msg)
+				}
+			}
+		}(pc)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package topic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+// fakeKafka holds the messages on each partition of one topic, starting at
+// offset zero.
+type fakeKafka struct {
+	partitions [][]*sarama.ConsumerMessage
+}
+
+func (k *fakeKafka) add(partition int, value string, at time.Time) {
+	for len(k.partitions) <= partition {
+		k.partitions = append(k.partitions, nil)
+	}
+	msgs := k.partitions[partition]
+	k.partitions[partition] = append(msgs, &sarama.ConsumerMessage{Partition: int32(partition), Offset: int64(len(msgs)), Value: []byte(value), Timestamp: at})
+}
+
+func (k *fakeKafka) GetOffset(topic string, partition int32, when int64) (int64, error) {
+	msgs := k.partitions[partition]
+	switch when {
+	case sarama.OffsetOldest:
+		return 0, nil
+	case sarama.OffsetNewest:
+		return int64(len(msgs)), nil
+	}
+	for _, msg := range msgs {
+		if msg.Timestamp.UnixMilli() >= when {
+			return msg.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+func (k *fakeKafka) Topics() ([]string, error) { return []string{"topic"}, nil }
+
+func (k *fakeKafka) Partitions(topic string) ([]int32, error) {
+	var partitions []int32
+	for p := range k.partitions {
+		partitions = append(partitions, int32(p))
+	}
+	return partitions, nil
+}
+
+func (k *fakeKafka) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	pc := &fakePartitionConsumer{messages: make(chan *sarama.ConsumerMessage, len(k.partitions[partition]))}
+	for _, msg := range k.partitions[partition][offset:] {
+		pc.messages <- msg
+	}
+	return pc, nil
+}
+
+func (k *fakeKafka) HighWaterMarks() map[string]map[int32]int64 { return nil }
+
+func (k *fakeKafka) Close() error { return nil }
+
+type fakePartitionConsumer struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (pc *fakePartitionConsumer) AsyncClose()                              {}
+func (pc *fakePartitionConsumer) Close() error                             { return nil }
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+func (pc *fakePartitionConsumer) Errors() <-chan *sarama.ConsumerError     { return nil }
+func (pc *fakePartitionConsumer) HighWaterMarkOffset() int64               { return 0 }
+
+func values(msgs []*sarama.ConsumerMessage) []string {
+	var v []string
+	for _, msg := range msgs {
+		v = append(v, string(msg.Value))
+	}
+	return v
+}
+
+func TestUnitLoad(t *testing.T) {
+	k := &fakeKafka{}
+	k.add(0, "a", start)
+	k.add(0, "b", start.Add(time.Minute))
+	k.add(1, "c", start)
+	k.add(2, "d", start.Add(2*time.Minute))
+
+	t.Run("from the oldest offset", func(t *testing.T) {
+		var read []*sarama.ConsumerMessage
+		offsets, err := Load(context.Background(), k, k, "topic", sarama.OffsetOldest, func(msg *sarama.ConsumerMessage) { read = append(read, msg) })
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c", "d"}, values(read))
+		assert.Equal(t, Offsets{0: 2, 1: 1, 2: 1}, offsets)
+	})
+
+	t.Run("from a time", func(t *testing.T) {
+		var read []*sarama.ConsumerMessage
+		offsets, err := Load(context.Background(), k, k, "topic", start.Add(time.Minute).UnixMilli(), func(msg *sarama.ConsumerMessage) { read = append(read, msg) })
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b", "d"}, values(read), "partitions with no message since the time are not read")
+		assert.Equal(t, Offsets{0: 2, 1: 1, 2: 1}, offsets)
+	})
+}
+
+func TestUnitFollow(t *testing.T) {
+	k := &fakeKafka{}
+	k.add(0, "a", start)
+	k.add(0, "b", start)
+	k.add(1, "c", start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	read := make(chan string, 3)
+	require.NoError(t, Follow(ctx, k, "topic", Offsets{0: 1, 1: 0}, func(msg *sarama.ConsumerMessage) { read <- string(msg.Value) }, func(error) {}))
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case v := <-read:
+			got = append(got, v)
+		case <-time.After(time.Second):
+			t.Fatalf("read %v, want the messages from the offsets", got)
+		}
+	}
+	assert.ElementsMatch(t, []string{"b", "c"}, got)
+}
@@ -0,0 +1,211 @@
+// Package validation checks that refund requests are well formed before they
+// are sent to the payments api, reporting every invalid field.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/money"
+)
+
+// Names of the validated fields, as in the avro schema.
+const (
+	FieldAttempt         = "attempt"
+	FieldPaymentID       = "payment_id"
+	FieldRefundAmount    = "refund_amount"
+	FieldRefundReference = "refund_reference"
+	FieldCurrency        = "currency"
+)
+
+// Default rules. Payment IDs are restricted to characters that are safe in
+// the payments api url they are sent to.
+const (
+	DefaultPaymentIDPattern   = `^[A-Za-z0-9_-]{1,64}$`
+	DefaultMaxReferenceLength = 64
+	DefaultMaxAttempt         = 100
+)
+
+// FieldError describes why a field is invalid.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+	// Err is the underlying error, if any.
+	Err error `json:"-"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// Error is returned for an invalid refund request, listing each invalid field.
+type Error struct {
+	Fields []FieldError
+}
+
+func (e *Error) Error() string {
+	reasons := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		reasons[i] = f.Error()
+	}
+	return "invalid refund request: " + strings.Join(reasons, ", ")
+}
+
+// Unwrap returns the underlying errors of the fields, so that callers can use
+// errors.Is to find, for example, a money.ErrScale amount.
+func (e *Error) Unwrap() []error {
+	var errs []error
+	for _, f := range e.Fields {
+		if f.Err != nil {
+			errs = append(errs, f.Err)
+		}
+	}
+	return errs
+}
+
+// Reasons returns the reason each field is invalid.
+func (e *Error) Reasons() map[string]string {
+	reasons := make(map[string]string, len(e.Fields))
+	for _, f := range e.Fields {
+		reasons[f.Field] = f.Reason
+	}
+	return reasons
+}
+
+// Only reports whether every invalid field is one of the given fields.
+func (e *Error) Only(fields ...string) bool {
+	for _, f := range e.Fields {
+		if !contains(fields, f.Field) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules are the limits a refund request must be within.
+type Rules struct {
+	PaymentID          *regexp.Regexp
+	MaxReferenceLength int
+	// MaxAmount is the largest refund, in pence.
+	MaxAmount  int64
+	MaxAttempt int32
+	// Currency is the ISO 4217 code of the currency refunds are made in. A
+	// request without a currency is taken to be in it.
+	Currency string
+}
+
+// New returns rules with the given limits, using the defaults for any that
+// are empty or zero.
+func New(paymentIDPattern string, maxReferenceLength int, maxAmount int64, maxAttempt int32) (*Rules, error) {
+	if paymentIDPattern == "" {
+		paymentIDPattern = DefaultPaymentIDPattern
+	}
+	paymentID, err := regexp.Compile(paymentIDPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payment id pattern [%s]: %w", paymentIDPattern, err)
+	}
+	if maxReferenceLength == 0 {
+		maxReferenceLength = DefaultMaxReferenceLength
+	}
+	if maxAmount == 0 {
+		maxAmount = money.DefaultMaxMinorUnits
+	}
+	if maxAttempt == 0 {
+		maxAttempt = DefaultMaxAttempt
+	}
+	if maxReferenceLength < 0 || maxAmount < 0 || maxAttempt < 0 {
+		return nil, errors.New("refund request limits must not be negative")
+	}
+	if maxAmount > money.DefaultMaxMinorUnits {
+		return nil, fmt.Errorf("maximum refund amount [%d] exceeds the payments api limit [%d]", maxAmount, int64(money.DefaultMaxMinorUnits))
+	}
+
+	return &Rules{
+		PaymentID:          paymentID,
+		MaxReferenceLength: maxReferenceLength,
+		MaxAmount:          maxAmount,
+		MaxAttempt:         maxAttempt,
+		Currency:           money.DefaultCurrency,
+	}, nil
+}
+
+// defaults are the default rules, which cannot fail to compile.
+var defaults, _ = New("", 0, 0, 0)
+
+// Default returns the default rules.
+func Default() *Rules {
+	return defaults
+}
+
+// Validate checks a refund request against the rules and returns its amount
+// in pence. The error is an *Error listing every invalid field.
+func (r *Rules) Validate(rr data.RefundRequest) (int, error) {
+	var fields []FieldError
+	invalid := func(field, reason string) {
+		fields = append(fields, FieldError{Field: field, Reason: reason})
+	}
+	invalidErr := func(field, reason string, err error) {
+		fields = append(fields, FieldError{Field: field, Reason: reason, Err: err})
+	}
+
+	if rr.Attempt < 0 || rr.Attempt > r.MaxAttempt {
+		invalid(FieldAttempt, fmt.Sprintf("must be between 0 and %d", r.MaxAttempt))
+	}
+
+	switch {
+	case rr.PaymentID == "":
+		invalid(FieldPaymentID, "is empty")
+	case !r.PaymentID.MatchString(rr.PaymentID):
+		invalid(FieldPaymentID, fmt.Sprintf("does not match %s", r.PaymentID))
+	}
+
+	// The amount is parsed in the currency of the refund, so that an amount
+	// given in another currency is not mistaken for one in pence.
+	currency := strings.ToUpper(strings.TrimSpace(rr.Currency))
+	if currency == "" {
+		currency = r.Currency
+	}
+	if currency != r.Currency {
+		invalidErr(FieldCurrency, fmt.Sprintf("must be %s", r.Currency), money.ErrCurrency)
+	}
+
+	parser := money.NewParser()
+	parser.Currency = r.Currency
+	parser.MaxMinorUnits = r.MaxAmount
+	amount, err := parser.Parse(rr.RefundAmount)
+	var amountErr *money.InvalidAmountError
+	switch {
+	case errors.Is(err, money.ErrTooLarge):
+		invalidErr(FieldRefundAmount, fmt.Sprintf("exceeds the maximum of %d pence", r.MaxAmount), err)
+	case errors.As(err, &amountErr):
+		invalidErr(FieldRefundAmount, strings.TrimPrefix(amountErr.Reason.Error(), "amount "), err)
+	case err != nil:
+		invalidErr(FieldRefundAmount, err.Error(), err)
+	case amount == 0:
+		invalid(FieldRefundAmount, "must be positive")
+	}
+
+	switch reference := strings.TrimSpace(rr.RefundReference); {
+	case reference == "":
+		invalid(FieldRefundReference, "is empty")
+	case len([]rune(rr.RefundReference)) > r.MaxReferenceLength:
+		invalid(FieldRefundReference, fmt.Sprintf("is longer than %d characters", r.MaxReferenceLength))
+	}
+
+	if len(fields) > 0 {
+		return 0, &Error{Fields: fields}
+	}
+	return int(amount), nil
+}
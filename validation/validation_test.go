@@ -0,0 +1,116 @@
+package validation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/companieshouse/refund-request-consumer/data"
+	"github.com/companieshouse/refund-request-consumer/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var valid = data.RefundRequest{Attempt: 1, PaymentID: "Sj9A7ZyRdj9Yomf", RefundAmount: "12.50", RefundReference: "ref"}
+
+func TestUnitValidate(t *testing.T) {
+	amount, err := Default().Validate(valid)
+	require.NoError(t, err)
+	assert.Equal(t, 1250, amount)
+
+	tests := []struct {
+		name   string
+		modify func(rr *data.RefundRequest)
+		field  string
+		reason string
+	}{
+		{"negative attempt", func(rr *data.RefundRequest) { rr.Attempt = -1 }, FieldAttempt, "must be between 0 and 100"},
+		{"attempt too high", func(rr *data.RefundRequest) { rr.Attempt = 101 }, FieldAttempt, "must be between 0 and 100"},
+		{"empty payment ID", func(rr *data.RefundRequest) { rr.PaymentID = "" }, FieldPaymentID, "is empty"},
+		{"payment ID with a path", func(rr *data.RefundRequest) { rr.PaymentID = "a/../b" }, FieldPaymentID, "does not match " + DefaultPaymentIDPattern},
+		{"unparseable amount", func(rr *data.RefundRequest) { rr.RefundAmount = "1.2.3" }, FieldRefundAmount, "is not a decimal number"},
+		{"zero amount", func(rr *data.RefundRequest) { rr.RefundAmount = "0.00" }, FieldRefundAmount, "must be positive"},
+		{"blank reference", func(rr *data.RefundRequest) { rr.RefundReference = "  " }, FieldRefundReference, "is empty"},
+		{"long reference", func(rr *data.RefundRequest) { rr.RefundReference = string(make([]byte, 65)) }, FieldRefundReference, "is longer than 64 characters"},
+		{"another currency", func(rr *data.RefundRequest) { rr.Currency = "EUR"; rr.RefundAmount = "10.00" }, FieldCurrency, "must be GBP"},
+		{"amount in another currency", func(rr *data.RefundRequest) { rr.RefundAmount = "€10.00" }, FieldRefundAmount, "is in an unsupported currency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := valid
+			tt.modify(&rr)
+
+			_, err := Default().Validate(rr)
+			var invalid *Error
+			require.True(t, errors.As(err, &invalid))
+			assert.Equal(t, []FieldError{{Field: tt.field, Reason: tt.reason}}, stripErrs(invalid.Fields))
+		})
+	}
+}
+
+func TestUnitValidateCurrency(t *testing.T) {
+	for _, currency := range []string{"", "GBP", "gbp", " GBP "} {
+		rr := valid
+		rr.Currency = currency
+		amount, err := Default().Validate(rr)
+		require.NoError(t, err, "currency %q", currency)
+		assert.Equal(t, 1250, amount)
+	}
+
+	rr := valid
+	rr.Currency = "EUR"
+	_, err := Default().Validate(rr)
+	assert.ErrorIs(t, err, money.ErrCurrency)
+}
+
+func stripErrs(fields []FieldError) []FieldError {
+	stripped := make([]FieldError, len(fields))
+	for i, f := range fields {
+		stripped[i] = FieldError{Field: f.Field, Reason: f.Reason}
+	}
+	return stripped
+}
+
+func TestUnitValidateEveryField(t *testing.T) {
+	_, err := Default().Validate(data.RefundRequest{Attempt: -1, RefundAmount: "1.234"})
+	assert.EqualError(t, err, "invalid refund request: attempt must be between 0 and 100, payment_id is empty, "+
+		"refund_amount has too many decimal places, refund_reference is empty")
+	assert.ErrorIs(t, err, money.ErrScale)
+
+	var invalid *Error
+	require.True(t, errors.As(err, &invalid))
+	assert.Equal(t, map[string]string{
+		FieldAttempt:         "must be between 0 and 100",
+		FieldPaymentID:       "is empty",
+		FieldRefundAmount:    "has too many decimal places",
+		FieldRefundReference: "is empty",
+	}, invalid.Reasons())
+	assert.False(t, invalid.Only(FieldRefundAmount))
+	assert.True(t, invalid.Only(FieldAttempt, FieldPaymentID, FieldRefundAmount, FieldRefundReference))
+}
+
+func TestUnitNew(t *testing.T) {
+	r, err := New(`^P[0-9]+$`, 10, 100000, 5)
+	require.NoError(t, err)
+
+	_, err = r.Validate(data.RefundRequest{Attempt: 6, PaymentID: valid.PaymentID, RefundAmount: "1000.01", RefundReference: "reference-11"})
+	var invalid *Error
+	require.True(t, errors.As(err, &invalid))
+	assert.Equal(t, map[string]string{
+		FieldAttempt:         "must be between 0 and 5",
+		FieldPaymentID:       "does not match ^P[0-9]+$",
+		FieldRefundAmount:    "exceeds the maximum of 100000 pence",
+		FieldRefundReference: "is longer than 10 characters",
+	}, invalid.Reasons())
+	assert.ErrorIs(t, err, money.ErrTooLarge)
+
+	amount, err := r.Validate(data.RefundRequest{Attempt: 5, PaymentID: "P1", RefundAmount: "1,000.00", RefundReference: "ref"})
+	require.NoError(t, err)
+	assert.Equal(t, 100000, amount)
+
+	_, err = New(`[`, 0, 0, 0)
+	assert.Error(t, err)
+	_, err = New("", -1, 0, 0)
+	assert.Error(t, err)
+	_, err = New("", 0, money.DefaultMaxMinorUnits+1, 0)
+	assert.Error(t, err)
+}